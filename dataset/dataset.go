@@ -0,0 +1,154 @@
+// Package dataset
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dataset
+
+import (
+	"context"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+)
+
+// Dataset is a complete snapshot of an AI trainer group including all of its dataset lines
+type Dataset struct {
+	TrainerGroup graphql.AITrainerGroup
+	Lines        []graphql.DatasetLine
+}
+
+// Line is the file representation of a single dataset line, as used by the JSONL and CSV formats
+type Line struct {
+	ID          string   `json:"id,omitempty"`
+	UserMessage string   `json:"userMessage"`
+	Message     string   `json:"message"`
+	ASM         string   `json:"asm,omitempty"`
+	Endearment  string   `json:"endearment,omitempty"`
+	Recent      string   `json:"recent,omitempty"`
+	Time        string   `json:"time,omitempty"`
+	History     []string `json:"history,omitempty"`
+	Deleted     bool     `json:"deleted,omitempty"`
+}
+
+// LineFromDatasetLine converts a dataset line as returned by the backend into its file representation
+func LineFromDatasetLine(datasetLine graphql.DatasetLine) Line {
+	line := Line{
+		ID:          string(datasetLine.ID),
+		UserMessage: string(datasetLine.UserMessage),
+		Message:     string(datasetLine.Message),
+		ASM:         string(datasetLine.ASM),
+		Endearment:  string(datasetLine.Endearment),
+		Recent:      string(datasetLine.Recent),
+		Time:        string(datasetLine.Time),
+		Deleted:     bool(datasetLine.Deleted),
+	}
+	for _, historyMessage := range datasetLine.History {
+		line.History = append(line.History, string(historyMessage))
+	}
+	return line
+}
+
+// ToDialogueInput converts the line into the input type used for training; empty conditions are omitted
+func (l Line) ToDialogueInput() *graphql.AiDialogueInput {
+	input := &graphql.AiDialogueInput{
+		Message:     gql.String(l.Message),
+		UserMessage: gql.String(l.UserMessage),
+		History:     make([]gql.String, 0, len(l.History)),
+		Conditions: graphql.AITrainingCondition{
+			ASM:        optionalCondition(l.ASM),
+			Endearment: optionalCondition(l.Endearment),
+			Recent:     optionalCondition(l.Recent),
+			Time:       optionalCondition(l.Time),
+		},
+	}
+	for _, historyMessage := range l.History {
+		input.History = append(input.History, gql.String(historyMessage))
+	}
+	return input
+}
+
+func optionalCondition(condition string) *string {
+	if condition == "" {
+		return nil
+	}
+	return &condition
+}
+
+// Remote is the part of the Kajiwoto backend used by the dataset tooling.
+// It is implemented by graphql.Session, and by NewClientRemote for a client with a fixed auth token.
+type Remote interface {
+	GetAITrainerGroup(ctx context.Context, aiTrainerGroupID string) (graphql.AITrainerGroup, error)
+	GetDatasetLines(ctx context.Context, aiTrainerGroupID, searchQuery string, limit, offset int) ([]graphql.DatasetLine, error)
+	AddToDataset(ctx context.Context, aiTrainerGroupID string, dialogues []*graphql.AiDialogueInput) (graphql.AIEditorResult, error)
+}
+
+// RemoteEditor is a Remote which can also edit and delete existing dataset lines.
+// Sync only uses it when SyncOptions.ApplyChanges is set.
+type RemoteEditor interface {
+	Remote
+	UpdateDatasetLine(ctx context.Context, aiTrainerGroupID, datasetLineID string, dialogue *graphql.AiDialogueInput) (graphql.AIEditorResult, error)
+	DeleteDatasetLines(ctx context.Context, aiTrainerGroupID string, datasetLineIDs []string) (graphql.AIEditorResult, error)
+}
+
+var _ Remote = (*graphql.Session)(nil)
+
+// clientRemote implements Remote on top of a client and a fixed auth token
+type clientRemote struct {
+	client    *graphql.KajiwotoGraphQLClient
+	authToken string
+}
+
+// NewClientRemote returns a Remote which sends all requests with authToken
+func NewClientRemote(client *graphql.KajiwotoGraphQLClient, authToken string) Remote {
+	return &clientRemote{
+		client:    client,
+		authToken: authToken,
+	}
+}
+
+func (c *clientRemote) GetAITrainerGroup(ctx context.Context, aiTrainerGroupID string) (graphql.AITrainerGroup, error) {
+	return c.client.GetAITrainerGroupWithContext(ctx, aiTrainerGroupID, c.authToken)
+}
+
+func (c *clientRemote) GetDatasetLines(ctx context.Context, aiTrainerGroupID, searchQuery string, limit, offset int) ([]graphql.DatasetLine, error) {
+	return c.client.GetDatasetLinesWithContext(ctx, aiTrainerGroupID, searchQuery, c.authToken, limit, offset)
+}
+
+func (c *clientRemote) AddToDataset(ctx context.Context, aiTrainerGroupID string, dialogues []*graphql.AiDialogueInput) (graphql.AIEditorResult, error) {
+	return c.client.AddToDatasetWithContext(ctx, aiTrainerGroupID, c.authToken, dialogues)
+}
+
+// iterateLines returns an iterator over all dataset lines of an AI trainer group
+func iterateLines(remote Remote, aiTrainerGroupID string) *graphql.DatasetLineIterator {
+	return graphql.NewDatasetLineIterator(func(ctx context.Context, limit, offset int) ([]graphql.DatasetLine, error) {
+		return remote.GetDatasetLines(ctx, aiTrainerGroupID, "", limit, offset)
+	}, 0)
+}
+
+// Fetch downloads an AI trainer group including all of its dataset lines
+func Fetch(ctx context.Context, remote Remote, aiTrainerGroupID string) (*Dataset, error) {
+	trainerGroup, errGroup := remote.GetAITrainerGroup(ctx, aiTrainerGroupID)
+	if errGroup != nil {
+		return nil, errGroup
+	}
+	lines, errLines := iterateLines(remote, aiTrainerGroupID).Collect(ctx)
+	if errLines != nil {
+		return nil, fmt.Errorf("unable to fetch dataset lines of trainer group '%v': %w", aiTrainerGroupID, errLines)
+	}
+	return &Dataset{
+		TrainerGroup: trainerGroup,
+		Lines:        lines,
+	}, nil
+}
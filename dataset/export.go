@@ -0,0 +1,335 @@
+// Package dataset
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dataset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/constants"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// BackupFormatVersion is the version of the backup format written by WriteBackup
+	BackupFormatVersion = 1
+
+	// JSONL record types
+	RecordTypeTrainerGroup = "trainerGroup"
+	RecordTypeDocument     = "document"
+	RecordTypeLine         = "line"
+)
+
+var (
+	// CSV headers
+	linesCSVHeader        = []string{"id", "userMessage", "message", "asm", "endearment", "recent", "time", "history", "deleted"}
+	documentsCSVHeader    = []string{"id", "order", "title", "content", "queueStatus", "queuedAt", "builtAt", "createdAt", "updatedAt"}
+	trainerGroupCSVHeader = []string{"id", "name", "description", "tags", "nsfw", "personalities", "price", "profilePhotoUri", "status", "count", "updatedAt"}
+)
+
+// TrainerGroupMeta is the file representation of the AI trainer group metadata
+type TrainerGroupMeta struct {
+	ID              string     `json:"id"`
+	Name            string     `json:"name"`
+	Description     string     `json:"description"`
+	Tags            []string   `json:"tags"`
+	NSFW            bool       `json:"nsfw"`
+	Personalities   [][]string `json:"personalities"`
+	Price           int        `json:"price"`
+	ProfilePhotoUri string     `json:"profilePhotoUri"`
+	Status          string     `json:"status"`
+	Count           int        `json:"count"`
+	UpdatedAt       uint64     `json:"updatedAt"`
+}
+
+// Document is the file representation of an AI document
+type Document struct {
+	ID          string `json:"id"`
+	Order       int    `json:"order"`
+	Title       string `json:"title"`
+	Content     string `json:"content"`
+	QueueStatus string `json:"queueStatus"`
+	QueuedAt    uint64 `json:"queuedAt"`
+	BuiltAt     uint64 `json:"builtAt"`
+	CreatedAt   uint64 `json:"createdAt"`
+	UpdatedAt   uint64 `json:"updatedAt"`
+}
+
+// Record is a single line of a JSONL export. Type tells which of the other fields is set.
+type Record struct {
+	Type         string            `json:"type"`
+	TrainerGroup *TrainerGroupMeta `json:"trainerGroup,omitempty"`
+	Document     *Document         `json:"document,omitempty"`
+	Line         *Line             `json:"line,omitempty"`
+}
+
+// Backup is the native backup format. Unlike JSONL and CSV it stores the backend types as they are,
+// so reading a backup restores the exact Dataset it was written from.
+type Backup struct {
+	FormatVersion int                    `json:"formatVersion"`
+	SDKVersion    string                 `json:"sdkVersion"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	TrainerGroup  graphql.AITrainerGroup `json:"trainerGroup"`
+	Lines         []graphql.DatasetLine  `json:"lines"`
+}
+
+func TrainerGroupMetaFromAITrainerGroup(trainerGroup graphql.AITrainerGroup) TrainerGroupMeta {
+	meta := TrainerGroupMeta{
+		ID:              string(trainerGroup.ID),
+		Name:            string(trainerGroup.Name),
+		Description:     string(trainerGroup.Description),
+		Tags:            make([]string, 0, len(trainerGroup.Tags)),
+		NSFW:            bool(trainerGroup.NSFW),
+		Personalities:   make([][]string, 0, len(trainerGroup.Personalities)),
+		Price:           int(trainerGroup.Price),
+		ProfilePhotoUri: string(trainerGroup.ProfilePhotoUri),
+		Status:          string(trainerGroup.Status),
+		Count:           int(trainerGroup.Count),
+		UpdatedAt:       trainerGroup.UpdatedAt,
+	}
+	for _, tag := range trainerGroup.Tags {
+		meta.Tags = append(meta.Tags, string(tag))
+	}
+	for _, personality := range trainerGroup.Personalities {
+		traits := make([]string, 0, len(personality))
+		for _, trait := range personality {
+			traits = append(traits, string(trait))
+		}
+		meta.Personalities = append(meta.Personalities, traits)
+	}
+	return meta
+}
+
+func DocumentFromAIDocument(document graphql.AIDocument) Document {
+	return Document{
+		ID:          string(document.ID),
+		Order:       int(document.Order),
+		Title:       string(document.Title),
+		Content:     string(document.Content),
+		QueueStatus: string(document.QueueStatus),
+		QueuedAt:    document.QueuedAt,
+		BuiltAt:     document.BuiltAt,
+		CreatedAt:   document.CreatedAt,
+		UpdatedAt:   document.UpdatedAt,
+	}
+}
+
+// WriteJSONL writes the dataset as JSON lines: the trainer group metadata first, followed by its documents and lines
+func WriteJSONL(w io.Writer, ds *Dataset) error {
+	encoder := json.NewEncoder(w)
+	meta := TrainerGroupMetaFromAITrainerGroup(ds.TrainerGroup)
+	if errEncode := encoder.Encode(Record{Type: RecordTypeTrainerGroup, TrainerGroup: &meta}); errEncode != nil {
+		return errEncode
+	}
+	for _, aiDocument := range ds.TrainerGroup.Documents {
+		document := DocumentFromAIDocument(aiDocument)
+		if errEncode := encoder.Encode(Record{Type: RecordTypeDocument, Document: &document}); errEncode != nil {
+			return errEncode
+		}
+	}
+	for _, datasetLine := range ds.Lines {
+		line := LineFromDatasetLine(datasetLine)
+		if errEncode := encoder.Encode(Record{Type: RecordTypeLine, Line: &line}); errEncode != nil {
+			return errEncode
+		}
+	}
+	return nil
+}
+
+// WriteLinesCSV writes dataset lines as CSV. History is stored as JSON array, since it holds multiple messages.
+func WriteLinesCSV(w io.Writer, datasetLines []graphql.DatasetLine) error {
+	csvWriter := csv.NewWriter(w)
+	if errWrite := csvWriter.Write(linesCSVHeader); errWrite != nil {
+		return errWrite
+	}
+	for _, datasetLine := range datasetLines {
+		line := LineFromDatasetLine(datasetLine)
+		history, errMarshal := json.Marshal(line.History)
+		if errMarshal != nil {
+			return errMarshal
+		}
+		if line.History == nil {
+			history = []byte("[]")
+		}
+		record := []string{
+			line.ID,
+			line.UserMessage,
+			line.Message,
+			line.ASM,
+			line.Endearment,
+			line.Recent,
+			line.Time,
+			string(history),
+			strconv.FormatBool(line.Deleted),
+		}
+		if errWrite := csvWriter.Write(record); errWrite != nil {
+			return errWrite
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteDocumentsCSV writes the AI documents of a trainer group as CSV
+func WriteDocumentsCSV(w io.Writer, aiDocuments []graphql.AIDocument) error {
+	csvWriter := csv.NewWriter(w)
+	if errWrite := csvWriter.Write(documentsCSVHeader); errWrite != nil {
+		return errWrite
+	}
+	for _, aiDocument := range aiDocuments {
+		document := DocumentFromAIDocument(aiDocument)
+		record := []string{
+			document.ID,
+			strconv.Itoa(document.Order),
+			document.Title,
+			document.Content,
+			document.QueueStatus,
+			strconv.FormatUint(document.QueuedAt, 10),
+			strconv.FormatUint(document.BuiltAt, 10),
+			strconv.FormatUint(document.CreatedAt, 10),
+			strconv.FormatUint(document.UpdatedAt, 10),
+		}
+		if errWrite := csvWriter.Write(record); errWrite != nil {
+			return errWrite
+		}
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteTrainerGroupCSV writes the trainer group metadata as single row CSV. Tags and personalities are stored as JSON arrays.
+func WriteTrainerGroupCSV(w io.Writer, trainerGroup graphql.AITrainerGroup) error {
+	meta := TrainerGroupMetaFromAITrainerGroup(trainerGroup)
+	tags, errMarshal := json.Marshal(meta.Tags)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	personalities, errMarshal := json.Marshal(meta.Personalities)
+	if errMarshal != nil {
+		return errMarshal
+	}
+
+	csvWriter := csv.NewWriter(w)
+	if errWrite := csvWriter.Write(trainerGroupCSVHeader); errWrite != nil {
+		return errWrite
+	}
+	record := []string{
+		meta.ID,
+		meta.Name,
+		meta.Description,
+		string(tags),
+		strconv.FormatBool(meta.NSFW),
+		string(personalities),
+		strconv.Itoa(meta.Price),
+		meta.ProfilePhotoUri,
+		meta.Status,
+		strconv.Itoa(meta.Count),
+		strconv.FormatUint(meta.UpdatedAt, 10),
+	}
+	if errWrite := csvWriter.Write(record); errWrite != nil {
+		return errWrite
+	}
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// WriteBackup writes the dataset in the native backup format
+func WriteBackup(w io.Writer, ds *Dataset) error {
+	backup := Backup{
+		FormatVersion: BackupFormatVersion,
+		SDKVersion:    constants.SDKVersion,
+		CreatedAt:     time.Now().UTC(),
+		TrainerGroup:  ds.TrainerGroup,
+		Lines:         ds.Lines,
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(backup)
+}
+
+// ReadBackup reads a dataset written by WriteBackup
+func ReadBackup(r io.Reader) (*Dataset, error) {
+	backup := Backup{}
+	if errDecode := json.NewDecoder(r).Decode(&backup); errDecode != nil {
+		return nil, errDecode
+	}
+	if backup.FormatVersion < 1 || backup.FormatVersion > BackupFormatVersion {
+		return nil, fmt.Errorf("unsupported backup format version: %v", backup.FormatVersion)
+	}
+	return &Dataset{
+		TrainerGroup: backup.TrainerGroup,
+		Lines:        backup.Lines,
+	}, nil
+}
+
+// ExportToDirectory writes the dataset in all supported formats into dir. File names are prefixed with the trainer group ID:
+//   - <id>.jsonl: metadata, documents and lines as JSON lines
+//   - <id>.group.csv, <id>.documents.csv, <id>.lines.csv: metadata, documents and lines as CSV
+//   - <id>.backup.json: native backup format
+//
+// IDs which are not a plain file name, e.g. containing a path separator, are rejected.
+func ExportToDirectory(dir string, ds *Dataset) error {
+	id := string(ds.TrainerGroup.ID)
+	if id == "" || id == "." || id == ".." || strings.ContainsAny(id, `/\`) || filepath.Base(id) != id {
+		return fmt.Errorf("invalid trainer group ID for file name: %q", id)
+	}
+	if errMkdir := os.MkdirAll(dir, 0755); errMkdir != nil {
+		return errMkdir
+	}
+	prefix := filepath.Join(dir, id)
+	exports := map[string]func(w io.Writer) error{
+		prefix + ".jsonl": func(w io.Writer) error {
+			return WriteJSONL(w, ds)
+		},
+		prefix + ".group.csv": func(w io.Writer) error {
+			return WriteTrainerGroupCSV(w, ds.TrainerGroup)
+		},
+		prefix + ".documents.csv": func(w io.Writer) error {
+			return WriteDocumentsCSV(w, ds.TrainerGroup.Documents)
+		},
+		prefix + ".lines.csv": func(w io.Writer) error {
+			return WriteLinesCSV(w, ds.Lines)
+		},
+		prefix + ".backup.json": func(w io.Writer) error {
+			return WriteBackup(w, ds)
+		},
+	}
+	for path, export := range exports {
+		if errExport := writeFile(path, export); errExport != nil {
+			return fmt.Errorf("unable to export '%v': %w", path, errExport)
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, errCreate := os.Create(path)
+	if errCreate != nil {
+		return errCreate
+	}
+	if errWrite := write(file); errWrite != nil {
+		file.Close()
+		return errWrite
+	}
+	return file.Close()
+}
@@ -0,0 +1,146 @@
+// Package dataset
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dataset
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type ExportTestSuite struct {
+	suite.Suite
+}
+
+func TestExportTestSuite(t *testing.T) {
+	suite.Run(t, new(ExportTestSuite))
+}
+
+func helperTestDataset() *Dataset {
+	return &Dataset{
+		TrainerGroup: graphql.AITrainerGroup{
+			ID:            "g1",
+			Name:          "Wanda",
+			Count:         2,
+			Description:   "canine musketeer, \"significant\" other",
+			Tags:          []gql.String{"fantasy", "romance"},
+			NSFW:          false,
+			Personalities: [][]gql.String{{"loyal", "brave"}},
+			Price:         100,
+			Status:        "PUBLISHED",
+			UpdatedAt:     1675538034488,
+			Documents: []graphql.AIDocument{
+				{ID: "d1", Order: 1, Title: "Backstory", Content: "Wanda grew up\nin the woods.", QueueStatus: "BUILT", QueuedAt: 1, BuiltAt: 2, CreatedAt: 3, UpdatedAt: 4},
+			},
+		},
+		Lines: []graphql.DatasetLine{
+			{ID: "l1", UserMessage: "hi", Message: "Hey my sweet *smiles*", AITrainerGroupID: "g1"},
+			{ID: "l2", UserMessage: "good night", Message: "sweet dreams", ASM: "sad", Endearment: "high", Recent: "hug", Time: "NIGHT", History: []gql.String{"hi", "hey"}, AITrainerGroupID: "g1"},
+		},
+	}
+}
+
+func (s *ExportTestSuite) TestWriteJSONL() {
+	buffer := &bytes.Buffer{}
+	assert.Nil(s.T(), WriteJSONL(buffer, helperTestDataset()))
+
+	records := make([]Record, 0)
+	for _, jsonLine := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		record := Record{}
+		assert.Nil(s.T(), json.Unmarshal([]byte(jsonLine), &record))
+		records = append(records, record)
+	}
+	assert.Len(s.T(), records, 4)
+	assert.Equal(s.T(), RecordTypeTrainerGroup, records[0].Type)
+	assert.Equal(s.T(), "Wanda", records[0].TrainerGroup.Name)
+	assert.Equal(s.T(), [][]string{{"loyal", "brave"}}, records[0].TrainerGroup.Personalities)
+	assert.Equal(s.T(), RecordTypeDocument, records[1].Type)
+	assert.Equal(s.T(), "Backstory", records[1].Document.Title)
+	assert.Equal(s.T(), RecordTypeLine, records[3].Type)
+	assert.Equal(s.T(), Line{ID: "l2", UserMessage: "good night", Message: "sweet dreams", ASM: "sad", Endearment: "high", Recent: "hug", Time: "NIGHT", History: []string{"hi", "hey"}}, *records[3].Line)
+}
+
+func (s *ExportTestSuite) TestWriteLinesCSV() {
+	buffer := &bytes.Buffer{}
+	assert.Nil(s.T(), WriteLinesCSV(buffer, helperTestDataset().Lines))
+
+	rows, errRead := csv.NewReader(buffer).ReadAll()
+	assert.Nil(s.T(), errRead)
+	assert.Len(s.T(), rows, 3)
+	assert.Equal(s.T(), linesCSVHeader, rows[0])
+	assert.Equal(s.T(), []string{"l1", "hi", "Hey my sweet *smiles*", "", "", "", "", "[]", "false"}, rows[1])
+	assert.Equal(s.T(), []string{"l2", "good night", "sweet dreams", "sad", "high", "hug", "NIGHT", `["hi","hey"]`, "false"}, rows[2])
+}
+
+func (s *ExportTestSuite) TestWriteMetadataCSV() {
+	ds := helperTestDataset()
+	buffer := &bytes.Buffer{}
+	assert.Nil(s.T(), WriteTrainerGroupCSV(buffer, ds.TrainerGroup))
+	rows, errRead := csv.NewReader(buffer).ReadAll()
+	assert.Nil(s.T(), errRead)
+	assert.Len(s.T(), rows, 2)
+	assert.Equal(s.T(), string(ds.TrainerGroup.Description), rows[1][2])
+	assert.Equal(s.T(), `["fantasy","romance"]`, rows[1][3])
+
+	buffer.Reset()
+	assert.Nil(s.T(), WriteDocumentsCSV(buffer, ds.TrainerGroup.Documents))
+	rows, errRead = csv.NewReader(buffer).ReadAll()
+	assert.Nil(s.T(), errRead)
+	assert.Len(s.T(), rows, 2)
+	assert.Equal(s.T(), "Wanda grew up\nin the woods.", rows[1][3])
+}
+
+func (s *ExportTestSuite) TestBackupRoundTrip() {
+	ds := helperTestDataset()
+	buffer := &bytes.Buffer{}
+	assert.Nil(s.T(), WriteBackup(buffer, ds))
+
+	restored, errRead := ReadBackup(buffer)
+	assert.Nil(s.T(), errRead)
+	assert.Equal(s.T(), ds, restored)
+}
+
+func (s *ExportTestSuite) TestBackupUnsupportedVersion() {
+	_, errRead := ReadBackup(strings.NewReader(`{"formatVersion":99}`))
+	assert.NotNil(s.T(), errRead)
+}
+
+func (s *ExportTestSuite) TestExportToDirectory() {
+	dir := s.T().TempDir()
+	assert.Nil(s.T(), ExportToDirectory(dir, helperTestDataset()))
+	for _, name := range []string{"g1.jsonl", "g1.group.csv", "g1.documents.csv", "g1.lines.csv", "g1.backup.json"} {
+		_, errStat := os.Stat(filepath.Join(dir, name))
+		assert.Nil(s.T(), errStat, name)
+	}
+
+	// IDs must not leave the target directory
+	for _, id := range []string{"", "..", "../g1", "sub/g1", `..\g1`} {
+		ds := helperTestDataset()
+		ds.TrainerGroup.ID = gql.String(id)
+		assert.NotNil(s.T(), ExportToDirectory(filepath.Join(dir, "nested"), ds), id)
+	}
+	_, errStat := os.Stat(filepath.Join(dir, "nested"))
+	assert.True(s.T(), os.IsNotExist(errStat))
+}
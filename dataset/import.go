@@ -0,0 +1,301 @@
+// Package dataset
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dataset
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultImportBatchSize is the number of dialogues uploaded per addToDataset request if not configured otherwise
+	DefaultImportBatchSize = 50
+)
+
+var (
+	ErrEmptyUserMessage = errors.New("user message is empty")
+	ErrEmptyMessage     = errors.New("message is empty")
+	ErrEmptyHistory     = errors.New("history contains empty message")
+)
+
+// ImportOptions configures an import
+type ImportOptions struct {
+	// BatchSize is the number of dialogues uploaded per request; DefaultImportBatchSize if < 1
+	BatchSize int
+}
+
+// RejectedLine is a line which did not pass validation
+type RejectedLine struct {
+	Index int // Position of the line within the imported lines
+	Line  Line
+	Err   error
+}
+
+// ImportReport describes the outcome of an import
+type ImportReport struct {
+	Added    []Line         // Uploaded lines
+	Skipped  []Line         // Lines already present in the remote dataset, or earlier in the imported lines
+	Rejected []RejectedLine // Invalid lines
+	Results  []graphql.AIEditorResult
+}
+
+// Validate checks whether the line can be added to a dataset
+func (l Line) Validate() error {
+	if strings.TrimSpace(l.UserMessage) == "" {
+		return ErrEmptyUserMessage
+	}
+	if strings.TrimSpace(l.Message) == "" {
+		return ErrEmptyMessage
+	}
+	for _, historyMessage := range l.History {
+		if strings.TrimSpace(historyMessage) == "" {
+			return ErrEmptyHistory
+		}
+	}
+	return nil
+}
+
+// ReadLinesJSONL reads dataset lines from JSON lines.
+// Exports written by WriteJSONL are supported, as well as files containing a plain Line object per row.
+// Records which are not lines, like trainer group metadata or documents, are skipped.
+func ReadLinesJSONL(r io.Reader) ([]Line, error) {
+	lines := make([]Line, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	row := 0
+	for scanner.Scan() {
+		row++
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		record := Record{}
+		if errUnmarshal := json.Unmarshal([]byte(data), &record); errUnmarshal != nil {
+			return nil, fmt.Errorf("unable to parse row %v: %w", row, errUnmarshal)
+		}
+		switch record.Type {
+		case RecordTypeLine:
+			if record.Line != nil {
+				lines = append(lines, *record.Line)
+			}
+		case "":
+			// Plain line without record wrapper
+			line := Line{}
+			if errUnmarshal := json.Unmarshal([]byte(data), &line); errUnmarshal != nil {
+				return nil, fmt.Errorf("unable to parse row %v: %w", row, errUnmarshal)
+			}
+			lines = append(lines, line)
+		}
+	}
+	if errScan := scanner.Err(); errScan != nil {
+		return nil, errScan
+	}
+	return lines, nil
+}
+
+// ReadLinesCSV reads dataset lines from CSV as written by WriteLinesCSV.
+// Columns are matched by header and may appear in any order; only userMessage and message are required.
+func ReadLinesCSV(r io.Reader) ([]Line, error) {
+	csvReader := csv.NewReader(r)
+	header, errHeader := csvReader.Read()
+	if errHeader != nil {
+		return nil, fmt.Errorf("unable to read CSV header: %w", errHeader)
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	for _, required := range []string{"userMessage", "message"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV header is missing column '%v'", required)
+		}
+	}
+
+	lines := make([]Line, 0)
+	for {
+		record, errRead := csvReader.Read()
+		if errors.Is(errRead, io.EOF) {
+			break
+		} else if errRead != nil {
+			return nil, errRead
+		}
+		value := func(column string) string {
+			if i, ok := columns[column]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		line := Line{
+			ID:          value("id"),
+			UserMessage: value("userMessage"),
+			Message:     value("message"),
+			ASM:         value("asm"),
+			Endearment:  value("endearment"),
+			Recent:      value("recent"),
+			Time:        value("time"),
+		}
+		if history := value("history"); history != "" && history != "[]" {
+			if errUnmarshal := json.Unmarshal([]byte(history), &line.History); errUnmarshal != nil {
+				return nil, fmt.Errorf("unable to parse history of line %v: %w", len(lines)+1, errUnmarshal)
+			}
+		}
+		if deleted := value("deleted"); deleted != "" {
+			line.Deleted, _ = strconv.ParseBool(deleted)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// ReadLinesFile reads dataset lines from a .jsonl or .csv file
+func ReadLinesFile(path string) ([]Line, error) {
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer file.Close()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		return ReadLinesJSONL(file)
+	case ".csv":
+		return ReadLinesCSV(file)
+	default:
+		return nil, fmt.Errorf("unsupported dataset file type: %v", path)
+	}
+}
+
+// Import validates the given lines and adds them to the dataset of an AI trainer group.
+// Lines which duplicate an existing remote line, or a line earlier in the list, are skipped.
+// Deleted lines are never imported. If an upload fails, the report contains all lines added so far.
+func Import(ctx context.Context, remote Remote, aiTrainerGroupID string, lines []Line, options ImportOptions) (*ImportReport, error) {
+	report := &ImportReport{
+		Added:    make([]Line, 0),
+		Skipped:  make([]Line, 0),
+		Rejected: make([]RejectedLine, 0),
+		Results:  make([]graphql.AIEditorResult, 0),
+	}
+	batchSize := options.BatchSize
+	if batchSize < 1 {
+		batchSize = DefaultImportBatchSize
+	}
+
+	// Load remote dataset for duplicate detection
+	known := newDialogueIndex()
+	it := iterateLines(remote, aiTrainerGroupID)
+	for it.Next(ctx) {
+		if remoteLine := it.Line(); !remoteLine.Deleted {
+			known.add(LineFromDatasetLine(remoteLine).ToDialogueInput())
+		}
+	}
+	if errIterate := it.Err(); errIterate != nil {
+		return report, fmt.Errorf("unable to fetch remote dataset lines: %w", errIterate)
+	}
+
+	// Validate & filter
+	pendingLines := make([]Line, 0, len(lines))
+	for i, line := range lines {
+		if line.Deleted {
+			continue
+		}
+		if errValidate := line.Validate(); errValidate != nil {
+			report.Rejected = append(report.Rejected, RejectedLine{Index: i, Line: line, Err: errValidate})
+			continue
+		}
+		dialogue := line.ToDialogueInput()
+		if known.contains(dialogue) {
+			report.Skipped = append(report.Skipped, line)
+			continue
+		}
+		known.add(dialogue)
+		pendingLines = append(pendingLines, line)
+	}
+
+	added, results, errUpload := uploadLines(ctx, remote, aiTrainerGroupID, pendingLines, batchSize)
+	report.Added = append(report.Added, added...)
+	report.Results = append(report.Results, results...)
+	return report, errUpload
+}
+
+// uploadLines adds lines to the dataset in batches, retrying transient failures. On error, the lines added up to then are returned.
+func uploadLines(ctx context.Context, remote Remote, aiTrainerGroupID string, lines []Line, batchSize int) (added []Line, results []graphql.AIEditorResult, err error) {
+	dialogues := make([]*graphql.AiDialogueInput, 0, len(lines))
+	for _, line := range lines {
+		dialogues = append(dialogues, line.ToDialogueInput())
+	}
+	add := func(ctx context.Context, chunk []*graphql.AiDialogueInput) (graphql.AIEditorResult, error) {
+		return remote.AddToDataset(ctx, aiTrainerGroupID, chunk)
+	}
+	progress, errUpload := graphql.BulkAddToDatasetFunc(ctx, add, dialogues, graphql.BulkOptions{
+		ChunkSize: batchSize,
+		Lines: func(ctx context.Context) ([]graphql.DatasetLine, error) {
+			return iterateLines(remote, aiTrainerGroupID).Collect(ctx)
+		},
+		OnChunk: func(progress graphql.BulkProgress, chunkResult graphql.AIEditorResult) {
+			results = append(results, chunkResult)
+			log.Debugf("Added %v of %v lines to trainer group '%v'", progress.Uploaded, progress.Total, aiTrainerGroupID)
+		},
+	})
+	return lines[:progress.Uploaded], results, errUpload
+}
+
+// dialogueIndex allows fast duplicate lookups by bucketing dialogues by their messages
+type dialogueIndex map[string][]*graphql.AiDialogueInput
+
+func newDialogueIndex() dialogueIndex {
+	return make(dialogueIndex)
+}
+
+func (d dialogueIndex) key(dialogue *graphql.AiDialogueInput) string {
+	return string(dialogue.UserMessage) + "\x00" + string(dialogue.Message)
+}
+
+func (d dialogueIndex) add(dialogue *graphql.AiDialogueInput) {
+	key := d.key(dialogue)
+	d[key] = append(d[key], dialogue)
+}
+
+func (d dialogueIndex) contains(dialogue *graphql.AiDialogueInput) bool {
+	for _, candidate := range d[d.key(dialogue)] {
+		if candidate.IsDuplicate(dialogue) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove takes the first duplicate of dialogue out of the index and returns it; nil if there is none
+func (d dialogueIndex) remove(dialogue *graphql.AiDialogueInput) *graphql.AiDialogueInput {
+	key := d.key(dialogue)
+	for i, candidate := range d[key] {
+		if candidate.IsDuplicate(dialogue) {
+			d[key] = append(d[key][:i], d[key][i+1:]...)
+			return candidate
+		}
+	}
+	return nil
+}
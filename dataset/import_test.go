@@ -0,0 +1,194 @@
+// Package dataset
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dataset
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+)
+
+// fakeRemote keeps a single trainer group in memory
+type fakeRemote struct {
+	trainerGroup graphql.AITrainerGroup
+	lines        []graphql.DatasetLine
+	addCalls     int
+	updateCalls  int
+	deleteCalls  int
+}
+
+func (f *fakeRemote) GetAITrainerGroup(ctx context.Context, aiTrainerGroupID string) (graphql.AITrainerGroup, error) {
+	return f.trainerGroup, nil
+}
+
+func (f *fakeRemote) GetDatasetLines(ctx context.Context, aiTrainerGroupID, searchQuery string, limit, offset int) ([]graphql.DatasetLine, error) {
+	page := make([]graphql.DatasetLine, 0)
+	for i := offset; i < len(f.lines) && i < offset+limit; i++ {
+		page = append(page, f.lines[i])
+	}
+	return page, nil
+}
+
+func (f *fakeRemote) AddToDataset(ctx context.Context, aiTrainerGroupID string, dialogues []*graphql.AiDialogueInput) (graphql.AIEditorResult, error) {
+	f.addCalls++
+	result := graphql.AIEditorResult{AITrainerGroupID: gql.String(aiTrainerGroupID)}
+	for _, dialogue := range dialogues {
+		line := fakeLine(aiTrainerGroupID, dialogue)
+		line.ID = gql.String(fmt.Sprintf("l%d", len(f.lines)+1))
+		f.lines = append(f.lines, line)
+		result.Added = append(result.Added, line)
+	}
+	result.Count = gql.Int(len(f.lines))
+	return result, nil
+}
+
+func (f *fakeRemote) UpdateDatasetLine(ctx context.Context, aiTrainerGroupID, datasetLineID string, dialogue *graphql.AiDialogueInput) (graphql.AIEditorResult, error) {
+	f.updateCalls++
+	for i := range f.lines {
+		if string(f.lines[i].ID) == datasetLineID {
+			line := fakeLine(aiTrainerGroupID, dialogue)
+			line.ID = f.lines[i].ID
+			f.lines[i] = line
+			return graphql.AIEditorResult{Added: []graphql.DatasetLine{line}}, nil
+		}
+	}
+	return graphql.AIEditorResult{}, fmt.Errorf("line %v not found", datasetLineID)
+}
+
+func (f *fakeRemote) DeleteDatasetLines(ctx context.Context, aiTrainerGroupID string, datasetLineIDs []string) (graphql.AIEditorResult, error) {
+	f.deleteCalls++
+	result := graphql.AIEditorResult{}
+	for _, datasetLineID := range datasetLineIDs {
+		for i := range f.lines {
+			if string(f.lines[i].ID) == datasetLineID {
+				f.lines[i].Deleted = true
+				result.DeletedIDs = append(result.DeletedIDs, f.lines[i].ID)
+			}
+		}
+	}
+	return result, nil
+}
+
+func fakeLine(aiTrainerGroupID string, dialogue *graphql.AiDialogueInput) graphql.DatasetLine {
+	line := graphql.DatasetLine{
+		UserMessage:      dialogue.UserMessage,
+		Message:          dialogue.Message,
+		History:          dialogue.History,
+		AITrainerGroupID: gql.String(aiTrainerGroupID),
+	}
+	if dialogue.Conditions.ASM != nil {
+		line.ASM = gql.String(*dialogue.Conditions.ASM)
+	}
+	if dialogue.Conditions.Endearment != nil {
+		line.Endearment = gql.String(*dialogue.Conditions.Endearment)
+	}
+	if dialogue.Conditions.Recent != nil {
+		line.Recent = gql.String(*dialogue.Conditions.Recent)
+	}
+	if dialogue.Conditions.Time != nil {
+		line.Time = gql.String(*dialogue.Conditions.Time)
+	}
+	return line
+}
+
+type ImportTestSuite struct {
+	suite.Suite
+}
+
+func TestImportTestSuite(t *testing.T) {
+	suite.Run(t, new(ImportTestSuite))
+}
+
+func (s *ImportTestSuite) TestReadExportedJSONL() {
+	ds := helperTestDataset()
+	buffer := &bytes.Buffer{}
+	assert.Nil(s.T(), WriteJSONL(buffer, ds))
+
+	lines, errRead := ReadLinesJSONL(buffer)
+	assert.Nil(s.T(), errRead)
+	assert.Len(s.T(), lines, 2)
+	assert.Equal(s.T(), LineFromDatasetLine(ds.Lines[1]), lines[1])
+}
+
+func (s *ImportTestSuite) TestReadPlainJSONL() {
+	data := `{"userMessage":"hi","message":"hey there"}
+
+{"userMessage":"bye","message":"see you","time":"NIGHT","history":["hi"]}`
+	lines, errRead := ReadLinesJSONL(strings.NewReader(data))
+	assert.Nil(s.T(), errRead)
+	assert.Equal(s.T(), []Line{
+		{UserMessage: "hi", Message: "hey there"},
+		{UserMessage: "bye", Message: "see you", Time: "NIGHT", History: []string{"hi"}},
+	}, lines)
+}
+
+func (s *ImportTestSuite) TestReadExportedCSV() {
+	ds := helperTestDataset()
+	buffer := &bytes.Buffer{}
+	assert.Nil(s.T(), WriteLinesCSV(buffer, ds.Lines))
+
+	lines, errRead := ReadLinesCSV(buffer)
+	assert.Nil(s.T(), errRead)
+	assert.Len(s.T(), lines, 2)
+	assert.Equal(s.T(), LineFromDatasetLine(ds.Lines[1]), lines[1])
+}
+
+func (s *ImportTestSuite) TestReadCSVMissingColumn() {
+	_, errRead := ReadLinesCSV(strings.NewReader("userMessage,asm\nhi,happy\n"))
+	assert.NotNil(s.T(), errRead)
+}
+
+func (s *ImportTestSuite) TestImport() {
+	remote := &fakeRemote{lines: helperTestDataset().Lines}
+	lines := []Line{
+		// Duplicates of remote lines
+		{UserMessage: "hi", Message: "Hey my sweet *smiles*"},
+		{UserMessage: "good night", Message: "sweet dreams", ASM: "sad", Endearment: "high", Recent: "hug", Time: "NIGHT", History: []string{"hi", "hey"}},
+		// Differs from remote line in conditions
+		{UserMessage: "good night", Message: "sweet dreams", Time: "NIGHT"},
+		// Invalid
+		{UserMessage: "", Message: "nobody asked"},
+		// Duplicate within file
+		{UserMessage: "how are you?", Message: "great!"},
+		{UserMessage: "how are you?", Message: "great!"},
+		// Deleted
+		{UserMessage: "deleted", Message: "line", Deleted: true},
+		{UserMessage: "what's up?", Message: "not much"},
+	}
+
+	report, errImport := Import(context.Background(), remote, "g1", lines, ImportOptions{BatchSize: 2})
+	assert.Nil(s.T(), errImport)
+	assert.Equal(s.T(), []Line{lines[2], lines[4], lines[7]}, report.Added)
+	assert.Equal(s.T(), []Line{lines[0], lines[1], lines[5]}, report.Skipped)
+	assert.Len(s.T(), report.Rejected, 1)
+	assert.Equal(s.T(), 3, report.Rejected[0].Index)
+	assert.ErrorIs(s.T(), report.Rejected[0].Err, ErrEmptyUserMessage)
+	assert.Equal(s.T(), 2, remote.addCalls)
+	assert.Len(s.T(), remote.lines, 5)
+
+	// Importing again adds nothing
+	report, errImport = Import(context.Background(), remote, "g1", lines, ImportOptions{})
+	assert.Nil(s.T(), errImport)
+	assert.Empty(s.T(), report.Added)
+	assert.Equal(s.T(), 2, remote.addCalls)
+}
@@ -0,0 +1,335 @@
+// Package dataset
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dataset
+
+import (
+	"context"
+	"fmt"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// SyncDirection defines which side of a sync is the source of truth
+type SyncDirection int
+
+const (
+	// SyncPush applies the local file to the remote trainer group
+	SyncPush SyncDirection = iota
+	// SyncPull rewrites the local file from the remote trainer group
+	SyncPull
+)
+
+func (d SyncDirection) String() string {
+	switch d {
+	case SyncPush:
+		return "push"
+	case SyncPull:
+		return "pull"
+	default:
+		return fmt.Sprintf("SyncDirection(%d)", int(d))
+	}
+}
+
+// LineChange is a line which exists on both sides with the same ID, but different content
+type LineChange struct {
+	Local  Line
+	Remote graphql.DatasetLine
+}
+
+// Diff is the difference between a local dataset file and a remote trainer group.
+// Deleted lines are ignored on both sides.
+type Diff struct {
+	Added   []Line                // Lines only present locally
+	Missing []graphql.DatasetLine // Lines only present remotely
+	Changed []LineChange          // Lines present on both sides with different content
+}
+
+// SyncOptions configures a sync
+type SyncOptions struct {
+	Direction SyncDirection
+	// DryRun only computes and prints the plan, nothing is changed
+	DryRun bool
+	// Plan receives a human readable plan before anything is applied; may be nil
+	Plan io.Writer
+	// BatchSize is the number of dialogues uploaded per request; DefaultImportBatchSize if < 1
+	BatchSize int
+	// ApplyChanges makes a push also update changed lines and delete remote-only lines.
+	// The remote has to implement RemoteEditor; by default a push only adds lines.
+	ApplyChanges bool
+}
+
+// SyncResult describes the outcome of a sync
+type SyncResult struct {
+	Diff    *Diff
+	Applied bool
+	// Unapplied lists remote changes which a push did not apply, since ApplyChanges was not set
+	Unapplied int
+}
+
+// IsEmpty checks whether both sides are in sync
+func (d *Diff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Missing) == 0 && len(d.Changed) == 0
+}
+
+// ComputeDiff compares local lines against remote dataset lines.
+// Lines are matched by ID first; local lines without a matching ID are matched by content using IsDuplicate.
+func ComputeDiff(local []Line, remote []graphql.DatasetLine) *Diff {
+	diff := &Diff{
+		Added:   make([]Line, 0),
+		Missing: make([]graphql.DatasetLine, 0),
+		Changed: make([]LineChange, 0),
+	}
+
+	remoteByID := make(map[string]int, len(remote))
+	matched := make([]bool, len(remote))
+	for i, remoteLine := range remote {
+		if !remoteLine.Deleted {
+			remoteByID[string(remoteLine.ID)] = i
+		}
+	}
+
+	// Match by ID
+	unmatchedLocal := make([]Line, 0)
+	for _, localLine := range local {
+		if localLine.Deleted {
+			continue
+		}
+		i, ok := remoteByID[localLine.ID]
+		if localLine.ID == "" || !ok || matched[i] {
+			unmatchedLocal = append(unmatchedLocal, localLine)
+			continue
+		}
+		matched[i] = true
+		if !localLine.ToDialogueInput().IsDuplicate(LineFromDatasetLine(remote[i]).ToDialogueInput()) {
+			diff.Changed = append(diff.Changed, LineChange{Local: localLine, Remote: remote[i]})
+		}
+	}
+
+	// Match remaining lines by content
+	remoteByContent := newDialogueIndex()
+	remoteIndex := make(map[*graphql.AiDialogueInput]int)
+	for i, remoteLine := range remote {
+		if bool(remoteLine.Deleted) || matched[i] {
+			continue
+		}
+		dialogue := LineFromDatasetLine(remoteLine).ToDialogueInput()
+		remoteByContent.add(dialogue)
+		remoteIndex[dialogue] = i
+	}
+	for _, localLine := range unmatchedLocal {
+		remoteDialogue := remoteByContent.remove(localLine.ToDialogueInput())
+		if remoteDialogue == nil {
+			diff.Added = append(diff.Added, localLine)
+			continue
+		}
+		matched[remoteIndex[remoteDialogue]] = true
+	}
+
+	for i, remoteLine := range remote {
+		if !bool(remoteLine.Deleted) && !matched[i] {
+			diff.Missing = append(diff.Missing, remoteLine)
+		}
+	}
+	return diff
+}
+
+// WritePlan writes a human readable description of what applying the diff in the given direction does
+func (d *Diff) WritePlan(w io.Writer, direction SyncDirection) error {
+	var b strings.Builder
+	if d.IsEmpty() {
+		b.WriteString("Dataset is in sync, nothing to do.\n")
+		_, errWrite := io.WriteString(w, b.String())
+		return errWrite
+	}
+
+	switch direction {
+	case SyncPush:
+		fmt.Fprintf(&b, "Push plan: %v to add, %v changed, %v only in remote\n", len(d.Added), len(d.Changed), len(d.Missing))
+		for _, line := range d.Added {
+			fmt.Fprintf(&b, "  + add      %q => %q\n", line.UserMessage, line.Message)
+		}
+		for _, change := range d.Changed {
+			fmt.Fprintf(&b, "  ~ change   [%v] %q => %q (remote: %q => %q)\n", change.Local.ID, change.Local.UserMessage, change.Local.Message, change.Remote.UserMessage, change.Remote.Message)
+		}
+		for _, line := range d.Missing {
+			fmt.Fprintf(&b, "  - remove   [%v] %q => %q\n", line.ID, line.UserMessage, line.Message)
+		}
+	case SyncPull:
+		fmt.Fprintf(&b, "Pull plan: %v to add, %v changed, %v only in local file\n", len(d.Missing), len(d.Changed), len(d.Added))
+		for _, line := range d.Missing {
+			fmt.Fprintf(&b, "  + add      [%v] %q => %q\n", line.ID, line.UserMessage, line.Message)
+		}
+		for _, change := range d.Changed {
+			fmt.Fprintf(&b, "  ~ change   [%v] %q => %q (local: %q => %q)\n", change.Remote.ID, change.Remote.UserMessage, change.Remote.Message, change.Local.UserMessage, change.Local.Message)
+		}
+		for _, line := range d.Added {
+			fmt.Fprintf(&b, "  - remove   %q => %q\n", line.UserMessage, line.Message)
+		}
+	default:
+		return fmt.Errorf("unknown sync direction: %v", direction)
+	}
+	_, errWrite := io.WriteString(w, b.String())
+	return errWrite
+}
+
+// Sync compares the dataset file at path with the remote trainer group and applies the difference.
+// With SyncPush the remote trainer group is updated to match the file; with SyncPull the file is rewritten from the remote lines.
+func Sync(ctx context.Context, remote Remote, aiTrainerGroupID, path string, options SyncOptions) (*SyncResult, error) {
+	localLines, errRead := ReadLinesFile(path)
+	if errRead != nil {
+		return nil, errRead
+	}
+	ds, errFetch := Fetch(ctx, remote, aiTrainerGroupID)
+	if errFetch != nil {
+		return nil, errFetch
+	}
+
+	result := &SyncResult{
+		Diff: ComputeDiff(localLines, ds.Lines),
+	}
+	if options.Plan != nil {
+		if errPlan := result.Diff.WritePlan(options.Plan, options.Direction); errPlan != nil {
+			return result, errPlan
+		}
+	}
+	if options.DryRun || result.Diff.IsEmpty() {
+		return result, nil
+	}
+
+	switch options.Direction {
+	case SyncPush:
+		unapplied, errPush := push(ctx, remote, aiTrainerGroupID, result.Diff, options)
+		if errPush != nil {
+			return result, errPush
+		}
+		result.Unapplied = unapplied
+	case SyncPull:
+		if errWrite := writeLinesFile(path, ds); errWrite != nil {
+			return result, errWrite
+		}
+	default:
+		return result, fmt.Errorf("unknown sync direction: %v", options.Direction)
+	}
+	result.Applied = true
+	return result, nil
+}
+
+// push adds local-only lines to the remote trainer group and returns the number of changes left untouched.
+// With ApplyChanges set, changed lines are updated and remote-only lines deleted as well.
+func push(ctx context.Context, remote Remote, aiTrainerGroupID string, diff *Diff, options SyncOptions) (int, error) {
+	var editor RemoteEditor
+	if options.ApplyChanges {
+		var okEditor bool
+		if editor, okEditor = remote.(RemoteEditor); !okEditor {
+			return 0, fmt.Errorf("remote does not support editing dataset lines, unable to apply changes")
+		}
+	}
+
+	// Validate first, so an invalid file doesn't leave the remote half-synced
+	for _, line := range diff.Added {
+		if errValidate := line.Validate(); errValidate != nil {
+			return 0, fmt.Errorf("invalid line %q => %q: %w", line.UserMessage, line.Message, errValidate)
+		}
+	}
+	if editor != nil {
+		for _, change := range diff.Changed {
+			if errValidate := change.Local.Validate(); errValidate != nil {
+				return 0, fmt.Errorf("invalid line %v: %w", change.Local.ID, errValidate)
+			}
+		}
+	}
+	batchSize := options.BatchSize
+	if batchSize < 1 {
+		batchSize = DefaultImportBatchSize
+	}
+
+	if editor != nil {
+		if errApply := applyChanges(ctx, editor, aiTrainerGroupID, diff, batchSize); errApply != nil {
+			return 0, errApply
+		}
+	}
+	if _, _, errUpload := uploadLines(ctx, remote, aiTrainerGroupID, diff.Added, batchSize); errUpload != nil {
+		return 0, errUpload
+	}
+	if editor != nil {
+		log.Debugf("Pushed %v added, %v changed and %v removed lines to trainer group '%v'", len(diff.Added), len(diff.Changed), len(diff.Missing), aiTrainerGroupID)
+		return 0, nil
+	}
+
+	unapplied := len(diff.Changed) + len(diff.Missing)
+	if unapplied > 0 {
+		log.Warnf("Push left %v changed or remote-only lines untouched; set ApplyChanges to edit and delete lines", unapplied)
+	}
+	return unapplied, nil
+}
+
+// applyChanges updates changed lines and deletes remote-only lines in batches
+func applyChanges(ctx context.Context, editor RemoteEditor, aiTrainerGroupID string, diff *Diff, batchSize int) error {
+	for _, change := range diff.Changed {
+		if _, errUpdate := editor.UpdateDatasetLine(ctx, aiTrainerGroupID, change.Local.ID, change.Local.ToDialogueInput()); errUpdate != nil {
+			return fmt.Errorf("unable to update line %v: %w", change.Local.ID, errUpdate)
+		}
+	}
+	for start := 0; start < len(diff.Missing); start += batchSize {
+		end := start + batchSize
+		if end > len(diff.Missing) {
+			end = len(diff.Missing)
+		}
+		datasetLineIDs := make([]string, 0, end-start)
+		for _, line := range diff.Missing[start:end] {
+			datasetLineIDs = append(datasetLineIDs, string(line.ID))
+		}
+		if _, errDelete := editor.DeleteDatasetLines(ctx, aiTrainerGroupID, datasetLineIDs); errDelete != nil {
+			return fmt.Errorf("unable to delete lines: %w", errDelete)
+		}
+	}
+	return nil
+}
+
+// writeLinesFile writes the dataset to a .jsonl or .csv file
+func writeLinesFile(path string, ds *Dataset) error {
+	active := &Dataset{
+		TrainerGroup: ds.TrainerGroup,
+		Lines:        activeLines(ds.Lines),
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jsonl":
+		return writeFile(path, func(w io.Writer) error {
+			return WriteJSONL(w, active)
+		})
+	case ".csv":
+		return writeFile(path, func(w io.Writer) error {
+			return WriteLinesCSV(w, active.Lines)
+		})
+	default:
+		return fmt.Errorf("unsupported dataset file type: %v", path)
+	}
+}
+
+// activeLines filters deleted lines
+func activeLines(datasetLines []graphql.DatasetLine) []graphql.DatasetLine {
+	active := make([]graphql.DatasetLine, 0, len(datasetLines))
+	for _, datasetLine := range datasetLines {
+		if !datasetLine.Deleted {
+			active = append(active, datasetLine)
+		}
+	}
+	return active
+}
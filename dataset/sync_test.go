@@ -0,0 +1,216 @@
+// Package dataset
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package dataset
+
+import (
+	"bytes"
+	"context"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type SyncTestSuite struct {
+	suite.Suite
+}
+
+func TestSyncTestSuite(t *testing.T) {
+	suite.Run(t, new(SyncTestSuite))
+}
+
+func (s *SyncTestSuite) TestComputeDiff() {
+	remote := helperTestDataset().Lines
+	remote = append(remote,
+		graphql.DatasetLine{ID: "l3", UserMessage: "bye", Message: "see you"},
+		graphql.DatasetLine{ID: "l4", UserMessage: "gone", Message: "forever", Deleted: gql.Boolean(true)},
+	)
+	local := []Line{
+		// Matched by ID, unchanged
+		LineFromDatasetLine(remote[0]),
+		// Matched by ID, changed
+		{ID: "l2", UserMessage: "good night", Message: "nighty night"},
+		// Matched by content, without ID
+		{UserMessage: "bye", Message: "see you"},
+		// Only local
+		{UserMessage: "what's up?", Message: "not much"},
+		// Deleted locally, ignored
+		{UserMessage: "deleted", Message: "line", Deleted: true},
+	}
+
+	diff := ComputeDiff(local, remote)
+	assert.Equal(s.T(), []Line{local[3]}, diff.Added)
+	assert.Empty(s.T(), diff.Missing)
+	assert.Equal(s.T(), []LineChange{{Local: local[1], Remote: remote[1]}}, diff.Changed)
+
+	diff = ComputeDiff(local[:1], remote)
+	assert.Empty(s.T(), diff.Added)
+	assert.Equal(s.T(), []graphql.DatasetLine{remote[1], remote[2]}, diff.Missing)
+	assert.Empty(s.T(), diff.Changed)
+
+	assert.True(s.T(), ComputeDiff(nil, nil).IsEmpty())
+}
+
+func (s *SyncTestSuite) TestWritePlan() {
+	diff := ComputeDiff([]Line{{UserMessage: "what's up?", Message: "not much"}}, helperTestDataset().Lines[:1])
+	plan := &bytes.Buffer{}
+	assert.Nil(s.T(), diff.WritePlan(plan, SyncPush))
+	assert.Contains(s.T(), plan.String(), "Push plan: 1 to add, 0 changed, 1 only in remote")
+	assert.Contains(s.T(), plan.String(), `+ add      "what's up?" => "not much"`)
+	assert.Contains(s.T(), plan.String(), `- remove   [l1] "hi" => "Hey my sweet *smiles*"`)
+
+	plan.Reset()
+	assert.Nil(s.T(), (&Diff{}).WritePlan(plan, SyncPull))
+	assert.Equal(s.T(), "Dataset is in sync, nothing to do.\n", plan.String())
+}
+
+func (s *SyncTestSuite) TestSyncDryRun() {
+	remote := &fakeRemote{lines: helperTestDataset().Lines}
+	path := helperWriteLines(s.T(), "dataset.jsonl", []Line{{UserMessage: "what's up?", Message: "not much"}})
+
+	plan := &bytes.Buffer{}
+	result, errSync := Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPush, DryRun: true, Plan: plan})
+	assert.Nil(s.T(), errSync)
+	assert.False(s.T(), result.Applied)
+	assert.Len(s.T(), result.Diff.Added, 1)
+	assert.Zero(s.T(), remote.addCalls)
+	assert.True(s.T(), strings.HasPrefix(plan.String(), "Push plan:"))
+}
+
+func (s *SyncTestSuite) TestSyncPush() {
+	remote := &fakeRemote{lines: helperTestDataset().Lines}
+	changed := LineFromDatasetLine(remote.lines[0])
+	changed.Message = "Hey there *waves*"
+	lines := []Line{
+		changed,
+		{UserMessage: "what's up?", Message: "not much"},
+		{UserMessage: "bye", Message: "see you"},
+	}
+	path := helperWriteLines(s.T(), "dataset.jsonl", lines)
+
+	result, errSync := Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPush, BatchSize: 1})
+	assert.Nil(s.T(), errSync)
+	assert.True(s.T(), result.Applied)
+	assert.Len(s.T(), result.Diff.Changed, 1)
+	assert.Len(s.T(), result.Diff.Missing, 1)
+	assert.Equal(s.T(), 2, result.Unapplied)
+	assert.Zero(s.T(), remote.updateCalls)
+	assert.Zero(s.T(), remote.deleteCalls)
+	assert.Equal(s.T(), 2, remote.addCalls)
+	assert.Len(s.T(), remote.lines, 4)
+	assert.Equal(s.T(), "Hey my sweet *smiles*", string(remote.lines[0].Message))
+	assert.False(s.T(), bool(remote.lines[1].Deleted))
+
+	// Nothing left to add
+	result, errSync = Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPush})
+	assert.Nil(s.T(), errSync)
+	assert.Empty(s.T(), result.Diff.Added)
+	assert.Equal(s.T(), 2, result.Unapplied)
+	assert.Equal(s.T(), 2, remote.addCalls)
+}
+
+func (s *SyncTestSuite) TestSyncPushApplyChanges() {
+	remote := &fakeRemote{lines: helperTestDataset().Lines}
+	changed := LineFromDatasetLine(remote.lines[0])
+	changed.Message = "Hey there *waves*"
+	lines := []Line{
+		changed,
+		{UserMessage: "what's up?", Message: "not much"},
+		{UserMessage: "bye", Message: "see you"},
+	}
+	path := helperWriteLines(s.T(), "dataset.jsonl", lines)
+
+	result, errSync := Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPush, BatchSize: 1, ApplyChanges: true})
+	assert.Nil(s.T(), errSync)
+	assert.True(s.T(), result.Applied)
+	assert.Zero(s.T(), result.Unapplied)
+	assert.Equal(s.T(), 1, remote.updateCalls)
+	assert.Equal(s.T(), 1, remote.deleteCalls)
+	assert.Equal(s.T(), 2, remote.addCalls)
+	assert.Len(s.T(), remote.lines, 4)
+	assert.Equal(s.T(), "Hey there *waves*", string(remote.lines[0].Message))
+	assert.True(s.T(), bool(remote.lines[1].Deleted))
+
+	// Nothing left to apply
+	result, errSync = Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPush, ApplyChanges: true})
+	assert.Nil(s.T(), errSync)
+	assert.True(s.T(), result.Diff.IsEmpty())
+	assert.Equal(s.T(), 1, remote.updateCalls)
+	assert.Equal(s.T(), 1, remote.deleteCalls)
+	assert.Equal(s.T(), 2, remote.addCalls)
+}
+
+func (s *SyncTestSuite) TestSyncPushApplyChangesUnsupported() {
+	editor := &fakeRemote{lines: helperTestDataset().Lines}
+	remote := struct{ Remote }{editor}
+	path := helperWriteLines(s.T(), "dataset.jsonl", []Line{{UserMessage: "bye", Message: "see you"}})
+
+	_, errSync := Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPush, ApplyChanges: true})
+	assert.NotNil(s.T(), errSync)
+	assert.Zero(s.T(), editor.addCalls)
+	assert.Zero(s.T(), editor.deleteCalls)
+}
+
+func (s *SyncTestSuite) TestSyncPushInvalid() {
+	remote := &fakeRemote{}
+	path := helperWriteLines(s.T(), "dataset.jsonl", []Line{{UserMessage: "hi", Message: "hey"}, {UserMessage: "", Message: "nobody asked"}})
+
+	_, errSync := Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPush})
+	assert.ErrorIs(s.T(), errSync, ErrEmptyUserMessage)
+	assert.Zero(s.T(), remote.addCalls)
+}
+
+func (s *SyncTestSuite) TestSyncPull() {
+	for _, name := range []string{"dataset.jsonl", "dataset.csv"} {
+		ds := helperTestDataset()
+		remote := &fakeRemote{lines: append(ds.Lines, graphql.DatasetLine{ID: "l3", UserMessage: "gone", Message: "forever", Deleted: gql.Boolean(true)})}
+		path := helperWriteLines(s.T(), name, []Line{{UserMessage: "what's up?", Message: "not much"}})
+
+		result, errSync := Sync(context.Background(), remote, "g1", path, SyncOptions{Direction: SyncPull})
+		assert.Nil(s.T(), errSync, name)
+		assert.True(s.T(), result.Applied, name)
+
+		lines, errRead := ReadLinesFile(path)
+		assert.Nil(s.T(), errRead, name)
+		assert.Equal(s.T(), []Line{LineFromDatasetLine(ds.Lines[0]), LineFromDatasetLine(ds.Lines[1])}, lines, name)
+		assert.True(s.T(), ComputeDiff(lines, remote.lines).IsEmpty(), name)
+	}
+}
+
+func helperWriteLines(t *testing.T, name string, lines []Line) string {
+	path := filepath.Join(t.TempDir(), name)
+	datasetLines := make([]graphql.DatasetLine, 0, len(lines))
+	for _, line := range lines {
+		datasetLine := graphql.DatasetLine{
+			ID:          gql.String(line.ID),
+			UserMessage: gql.String(line.UserMessage),
+			Message:     gql.String(line.Message),
+		}
+		datasetLines = append(datasetLines, datasetLine)
+	}
+	if errWrite := writeLinesFile(path, &Dataset{Lines: datasetLines}); errWrite != nil {
+		t.Fatal(errWrite)
+	}
+	if _, errStat := os.Stat(path); errStat != nil {
+		t.Fatal(errStat)
+	}
+	return path
+}
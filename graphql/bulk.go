@@ -0,0 +1,313 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	// DefaultBulkChunkSize is the number of dialogues sent per addToDataset mutation if not configured otherwise
+	DefaultBulkChunkSize = 50
+	// DefaultBulkMaxRetries is the number of retries per chunk if not configured otherwise
+	DefaultBulkMaxRetries = 3
+	// DefaultBulkRetryDelay is the delay before the first retry of a chunk; it doubles with every further retry
+	DefaultBulkRetryDelay = time.Second
+	// DefaultBulkMaxRetryDelay caps the delay between retries
+	DefaultBulkMaxRetryDelay = 30 * time.Second
+)
+
+// AddToDatasetFunc adds a single chunk of dialogues to a dataset.
+// Only failures returned as *Error are classified, so other errors are never retried.
+type AddToDatasetFunc func(ctx context.Context, dialogues []*AiDialogueInput) (AIEditorResult, error)
+
+// DatasetLinesFunc fetches all current lines of a dataset
+type DatasetLinesFunc func(ctx context.Context) ([]DatasetLine, error)
+
+// BulkProgress is the state of a bulk upload. It can be stored and passed as BulkOptions.Resume
+// to continue an interrupted upload of the same dialogues from the last successful chunk.
+type BulkProgress struct {
+	Total    int            // Number of dialogues of the upload
+	Uploaded int            // Number of dialogues uploaded successfully, in order
+	Chunks   int            // Number of chunks uploaded successfully
+	Result   AIEditorResult // Aggregated result of all uploaded chunks
+}
+
+// IsComplete checks whether all dialogues have been uploaded
+func (p *BulkProgress) IsComplete() bool {
+	return p.Uploaded >= p.Total
+}
+
+// BulkOptions configures a bulk upload
+type BulkOptions struct {
+	// ChunkSize is the number of dialogues per mutation; DefaultBulkChunkSize if < 1
+	ChunkSize int
+	// MaxRetries is the number of retries per chunk on transient errors; DefaultBulkMaxRetries if 0, no retries if < 0.
+	// Retries require Lines.
+	MaxRetries int
+	// RetryDelay is the delay before the first retry; DefaultBulkRetryDelay if 0
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the delay between retries; DefaultBulkMaxRetryDelay if 0
+	MaxRetryDelay time.Duration
+	// Lines fetches the current lines of the dataset. It is called once before the upload and again before each retry:
+	// a failed chunk may have been applied nonetheless, so the dialogues of the chunk which were added since the
+	// previous check are not sent again. Failed chunks are not retried if Lines is nil.
+	// BulkAddToDataset of KajiwotoGraphQLClient and Session set it if nil.
+	Lines DatasetLinesFunc
+	// Resume continues a previous upload of the same dialogues; may be nil
+	Resume *BulkProgress
+	// OnChunk is called after each successful chunk with the updated progress and the chunk's own result; may be nil
+	OnChunk func(progress BulkProgress, chunkResult AIEditorResult)
+}
+
+// BulkAddToDataset adds any number of dialogues to the dataset of an AI trainer group, split into chunks.
+// See BulkAddToDatasetFunc for details.
+func (c *KajiwotoGraphQLClient) BulkAddToDataset(ctx context.Context, aiTrainerGroupID, authToken string, dialogues []*AiDialogueInput, options BulkOptions) (*BulkProgress, error) {
+	if options.Lines == nil {
+		options.Lines = func(ctx context.Context) ([]DatasetLine, error) {
+			return c.IterateDatasetLines(aiTrainerGroupID, "", authToken, 0).Collect(ctx)
+		}
+	}
+	return BulkAddToDatasetFunc(ctx, func(ctx context.Context, chunk []*AiDialogueInput) (AIEditorResult, error) {
+		return c.AddToDatasetWithContext(ctx, aiTrainerGroupID, authToken, chunk)
+	}, dialogues, options)
+}
+
+// BulkAddToDataset adds any number of dialogues to the dataset of an AI trainer group, split into chunks.
+// See BulkAddToDatasetFunc for details.
+func (s *Session) BulkAddToDataset(ctx context.Context, aiTrainerGroupID string, dialogues []*AiDialogueInput, options BulkOptions) (*BulkProgress, error) {
+	if options.Lines == nil {
+		options.Lines = func(ctx context.Context) ([]DatasetLine, error) {
+			return s.IterateDatasetLines(aiTrainerGroupID, "", 0).Collect(ctx)
+		}
+	}
+	return BulkAddToDatasetFunc(ctx, func(ctx context.Context, chunk []*AiDialogueInput) (AIEditorResult, error) {
+		return s.AddToDataset(ctx, aiTrainerGroupID, chunk)
+	}, dialogues, options)
+}
+
+// BulkAddToDatasetFunc uploads dialogues in chunks using add. Chunks failing with a transient error are retried
+// with exponential backoff if BulkOptions.Lines is set; the result of a retried chunk only covers the dialogues
+// which were sent again. The returned progress is never nil; if the upload fails it can be passed as
+// BulkOptions.Resume to continue with the first chunk which was not uploaded.
+func BulkAddToDatasetFunc(ctx context.Context, add AddToDatasetFunc, dialogues []*AiDialogueInput, options BulkOptions) (*BulkProgress, error) {
+	chunkSize := options.ChunkSize
+	if chunkSize < 1 {
+		chunkSize = DefaultBulkChunkSize
+	}
+
+	progress := &BulkProgress{Total: len(dialogues)}
+	if options.Resume != nil {
+		if options.Resume.Total != len(dialogues) || options.Resume.Uploaded > len(dialogues) {
+			return progress, fmt.Errorf("resume progress covers %v dialogues, got %v", options.Resume.Total, len(dialogues))
+		}
+		*progress = *options.Resume
+	}
+
+	// The snapshot holds the dialogues expected in the dataset, so a retry can tell which lines the failed attempt added
+	var snapshot *[]*AiDialogueInput
+	if options.Lines != nil && options.MaxRetries >= 0 && progress.Uploaded < len(dialogues) {
+		existing, errLines := fetchDialogues(ctx, options.Lines)
+		if errLines != nil {
+			return progress, fmt.Errorf("unable to check dataset before upload: %w", errLines)
+		}
+		snapshot = &existing
+	}
+
+	for progress.Uploaded < len(dialogues) {
+		end := progress.Uploaded + chunkSize
+		if end > len(dialogues) {
+			end = len(dialogues)
+		}
+		chunkResult, errChunk := addChunkWithRetry(ctx, add, dialogues[progress.Uploaded:end], options, snapshot)
+		if errChunk != nil {
+			return progress, fmt.Errorf("unable to add dialogues %v to %v: %w", progress.Uploaded, end, errChunk)
+		}
+		progress.Uploaded = end
+		progress.Chunks++
+		progress.Result.merge(chunkResult)
+		log.Debugf("Uploaded %v of %v dialogues", progress.Uploaded, progress.Total)
+		if options.OnChunk != nil {
+			options.OnChunk(*progress, chunkResult)
+		}
+	}
+	return progress, nil
+}
+
+// addChunkWithRetry sends a single chunk, retrying transient errors if snapshot is set.
+// The dialogues sent successfully are appended to the snapshot.
+func addChunkWithRetry(ctx context.Context, add AddToDatasetFunc, chunk []*AiDialogueInput, options BulkOptions, snapshot *[]*AiDialogueInput) (AIEditorResult, error) {
+	maxRetries := options.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = DefaultBulkMaxRetries
+	}
+	delay := options.RetryDelay
+	if delay == 0 {
+		delay = DefaultBulkRetryDelay
+	}
+	maxDelay := options.MaxRetryDelay
+	if maxDelay == 0 {
+		maxDelay = DefaultBulkMaxRetryDelay
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			current, errLines := fetchDialogues(ctx, options.Lines)
+			if errLines != nil {
+				return AIEditorResult{}, fmt.Errorf("unable to check dataset before retrying: %w", errLines)
+			}
+			pending := dropAddedDialogues(chunk, *snapshot, current)
+			*snapshot = current
+			if len(pending) == 0 {
+				log.Debugf("All %v dialogues of the failed chunk were added", len(chunk))
+				return AIEditorResult{}, nil
+			}
+			chunk = pending
+		}
+		result, errAdd := add(ctx, chunk)
+		if errAdd == nil {
+			if snapshot != nil {
+				for _, dialogue := range chunk {
+					*snapshot = append(*snapshot, normalizeDialogue(dialogue))
+				}
+			}
+			return result, nil
+		}
+		if attempt >= maxRetries || snapshot == nil || ctx.Err() != nil || !isTransientError(errAdd) {
+			return result, errAdd
+		}
+
+		log.Warnf("Adding dialogues failed, retrying in %v. Error: %v", delay, errAdd)
+		if errWait := sleep(ctx, delay); errWait != nil {
+			return result, errWait
+		}
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// merge adds the result of another chunk of the same upload
+func (r *AIEditorResult) merge(chunkResult AIEditorResult) {
+	r.Added = append(r.Added, chunkResult.Added...)
+	r.DeletedIDs = append(r.DeletedIDs, chunkResult.DeletedIDs...)
+	r.Generated = append(r.Generated, chunkResult.Generated...)
+	if chunkResult.AITrainerGroupID != "" {
+		r.AITrainerGroupID = chunkResult.AITrainerGroupID
+	}
+	// Count and messages describe the dataset after the latest chunk
+	r.Count = chunkResult.Count
+	r.Message = chunkResult.Message
+	r.MessageType = chunkResult.MessageType
+}
+
+// isTransientError checks whether a failed request is worth retrying
+func isTransientError(err error) bool {
+	var graphQLErr *Error
+	return errors.As(err, &graphQLErr) && graphQLErr.IsTransient()
+}
+
+// fetchDialogues returns the normalized dialogues of all lines of the dataset which are not deleted
+func fetchDialogues(ctx context.Context, lines DatasetLinesFunc) ([]*AiDialogueInput, error) {
+	datasetLines, errLines := lines(ctx)
+	if errLines != nil {
+		return nil, errLines
+	}
+	dialogues := make([]*AiDialogueInput, 0, len(datasetLines))
+	for _, line := range datasetLines {
+		if !line.Deleted {
+			dialogues = append(dialogues, datasetLineDialogue(line))
+		}
+	}
+	return dialogues, nil
+}
+
+// dropAddedDialogues returns the dialogues of chunk which were not added between the snapshot and current state of the dataset.
+// Every line matches a single dialogue only, so repeated dialogues are kept as often as they were not added.
+func dropAddedDialogues(chunk, snapshot, current []*AiDialogueInput) []*AiDialogueInput {
+	added := make(map[string]int, len(current))
+	for _, dialogue := range current {
+		added[dialogueKey(dialogue)]++
+	}
+	for _, dialogue := range snapshot {
+		added[dialogueKey(dialogue)]--
+	}
+
+	pending := make([]*AiDialogueInput, 0, len(chunk))
+	for _, dialogue := range chunk {
+		key := dialogueKey(normalizeDialogue(dialogue))
+		if added[key] > 0 {
+			added[key]--
+			continue
+		}
+		pending = append(pending, dialogue)
+	}
+	return pending
+}
+
+// dialogueKey identifies a normalized dialogue; dialogues with the same key are duplicates
+func dialogueKey(dialogue *AiDialogueInput) string {
+	condition := func(condition *string) string {
+		if condition == nil {
+			return ""
+		}
+		return *condition
+	}
+	return fmt.Sprintf("%q %q %q %q %q %q %q", dialogue.UserMessage, dialogue.Message, dialogue.History,
+		condition(dialogue.Conditions.ASM), condition(dialogue.Conditions.Endearment), condition(dialogue.Conditions.Recent), condition(dialogue.Conditions.Time))
+}
+
+// datasetLineDialogue converts a dataset line into the normalized dialogue it was trained with
+func datasetLineDialogue(line DatasetLine) *AiDialogueInput {
+	return normalizeDialogue(&AiDialogueInput{
+		Message:     line.Message,
+		UserMessage: line.UserMessage,
+		History:     line.History,
+		Conditions: AITrainingCondition{
+			ASM:        (*string)(&line.ASM),
+			Endearment: (*string)(&line.Endearment),
+			Recent:     (*string)(&line.Recent),
+			Time:       (*string)(&line.Time),
+		},
+	})
+}
+
+// normalizeDialogue returns a copy of dialogue without empty conditions and with a non-nil history, for comparisons
+func normalizeDialogue(dialogue *AiDialogueInput) *AiDialogueInput {
+	optional := func(condition *string) *string {
+		if condition == nil || *condition == "" {
+			return nil
+		}
+		return condition
+	}
+	normalized := *dialogue
+	normalized.Conditions = AITrainingCondition{
+		ASM:        optional(dialogue.Conditions.ASM),
+		Endearment: optional(dialogue.Conditions.Endearment),
+		Recent:     optional(dialogue.Conditions.Recent),
+		Time:       optional(dialogue.Conditions.Time),
+	}
+	if normalized.History == nil {
+		normalized.History = make([]gql.String, 0)
+	}
+	return &normalized
+}
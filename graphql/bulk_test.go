@@ -0,0 +1,278 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type BulkAddToDatasetTestSuite struct {
+	suite.Suite
+	dialogues []*AiDialogueInput
+	// fake backend state
+	lines    []DatasetLine
+	calls    int
+	failures map[int]error // errors returned by the call with the given number, starting at 1
+	applied  map[int]bool  // calls which add their dialogues although they fail
+}
+
+func TestBulkAddToDatasetTestSuite(t *testing.T) {
+	suite.Run(t, new(BulkAddToDatasetTestSuite))
+}
+
+func (s *BulkAddToDatasetTestSuite) SetupTest() {
+	s.dialogues = make([]*AiDialogueInput, 0)
+	for i := 0; i < 25; i++ {
+		s.dialogues = append(s.dialogues, &AiDialogueInput{
+			UserMessage: gql.String(fmt.Sprintf("user message %d", i)),
+			Message:     gql.String(fmt.Sprintf("message %d", i)),
+			History:     []gql.String{},
+		})
+	}
+	s.lines = nil
+	s.calls = 0
+	s.failures = make(map[int]error)
+	s.applied = make(map[int]bool)
+}
+
+// add emulates the addToDataset mutation
+func (s *BulkAddToDatasetTestSuite) add(ctx context.Context, dialogues []*AiDialogueInput) (AIEditorResult, error) {
+	s.calls++
+	errFailure, failed := s.failures[s.calls]
+	if failed && !s.applied[s.calls] {
+		return AIEditorResult{}, errFailure
+	}
+	result := AIEditorResult{AITrainerGroupID: "g1"}
+	for _, dialogue := range dialogues {
+		line := DatasetLine{ID: gql.String(fmt.Sprintf("line-%d", len(s.lines)+1)), UserMessage: dialogue.UserMessage, Message: dialogue.Message}
+		s.lines = append(s.lines, line)
+		result.Added = append(result.Added, line)
+		result.DeletedIDs = append(result.DeletedIDs, gql.String(fmt.Sprintf("old-%d", len(s.lines))))
+	}
+	result.Count = gql.Int(len(s.lines))
+	if failed {
+		return AIEditorResult{}, errFailure
+	}
+	return result, nil
+}
+
+// datasetLines emulates fetching all dataset lines
+func (s *BulkAddToDatasetTestSuite) datasetLines(ctx context.Context) ([]DatasetLine, error) {
+	return append([]DatasetLine{}, s.lines...), nil
+}
+
+func transientError(message string) error {
+	return &Error{Op: "train dataset", Kind: ErrTransport, Err: errors.New(message)}
+}
+
+func (s *BulkAddToDatasetTestSuite) TestChunksAndAggregation() {
+	chunkSizes := make([]int, 0)
+	progress, errBulk := BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{
+		ChunkSize: 10,
+		OnChunk: func(progress BulkProgress, chunkResult AIEditorResult) {
+			chunkSizes = append(chunkSizes, len(chunkResult.Added))
+		},
+	})
+	assert.Nil(s.T(), errBulk)
+	assert.True(s.T(), progress.IsComplete())
+	assert.Equal(s.T(), []int{10, 10, 5}, chunkSizes)
+	assert.Equal(s.T(), 3, progress.Chunks)
+	assert.Len(s.T(), progress.Result.Added, 25)
+	assert.Len(s.T(), progress.Result.DeletedIDs, 25)
+	assert.Equal(s.T(), gql.Int(25), progress.Result.Count)
+	assert.Equal(s.T(), gql.String("g1"), progress.Result.AITrainerGroupID)
+	assert.Equal(s.T(), gql.String("line-25"), progress.Result.Added[24].ID)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestRetryTransientError() {
+	s.failures[2] = newError("train dataset", errors.New(`non-200 OK status code: 503 Service Unavailable body: ""`))
+	s.failures[3] = transientError("read tcp: connection reset by peer")
+	progress, errBulk := BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{ChunkSize: 10, RetryDelay: time.Millisecond, Lines: s.datasetLines})
+	assert.Nil(s.T(), errBulk)
+	assert.True(s.T(), progress.IsComplete())
+	assert.Equal(s.T(), 5, s.calls)
+	assert.Len(s.T(), s.lines, 25)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestRetryAppliedChunk() {
+	// The second chunk is added, but its response gets lost
+	s.failures[2] = transientError("read tcp: connection reset by peer")
+	s.applied[2] = true
+	progress, errBulk := BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{ChunkSize: 10, RetryDelay: time.Millisecond, Lines: s.datasetLines})
+	assert.Nil(s.T(), errBulk)
+	assert.True(s.T(), progress.IsComplete())
+	// The chunk isn't sent again
+	assert.Equal(s.T(), 3, s.calls)
+	assert.Len(s.T(), s.lines, 25)
+	assert.Equal(s.T(), gql.String("user message 24"), s.lines[24].UserMessage)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestNoRetryWithoutLines() {
+	s.failures[1] = transientError("read tcp: connection reset by peer")
+	_, errBulk := BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{RetryDelay: time.Millisecond})
+	assert.NotNil(s.T(), errBulk)
+	assert.Equal(s.T(), 1, s.calls)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestRetryKeepsExistingDuplicates() {
+	// The dataset already has a dialogue of the second chunk, which must not be mistaken for a partial upload
+	s.lines = append(s.lines, DatasetLine{ID: "line-0", UserMessage: s.dialogues[12].UserMessage, Message: s.dialogues[12].Message})
+	s.failures[2] = transientError("read tcp: connection reset by peer")
+	progress, errBulk := BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{ChunkSize: 10, RetryDelay: time.Millisecond, Lines: s.datasetLines})
+	assert.Nil(s.T(), errBulk)
+	assert.True(s.T(), progress.IsComplete())
+	assert.Equal(s.T(), 4, s.calls)
+	assert.Len(s.T(), s.lines, 26)
+	assert.Len(s.T(), progress.Result.Added, 25)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestDropAddedDialogues() {
+	asm := "happy"
+	empty := ""
+	chunk := []*AiDialogueInput{
+		{UserMessage: "hi", Message: "hello"},
+		{UserMessage: "hi", Message: "hello"},
+		{UserMessage: "hi", Message: "hello", Conditions: AITrainingCondition{ASM: &asm}},
+		{UserMessage: "bye", Message: "see you", Conditions: AITrainingCondition{Time: &empty}},
+	}
+	existing := func(ctx context.Context) ([]DatasetLine, error) {
+		return []DatasetLine{
+			{ID: "l1", UserMessage: "hi", Message: "hello"},
+			{ID: "l2", UserMessage: "hi", Message: "hello", ASM: "happy"},
+		}, nil
+	}
+	afterAttempt := func(ctx context.Context) ([]DatasetLine, error) {
+		return []DatasetLine{
+			{ID: "l1", UserMessage: "hi", Message: "hello"},
+			{ID: "l2", UserMessage: "hi", Message: "hello", ASM: "happy", Deleted: true},
+			{ID: "l3", UserMessage: "hi", Message: "hello"},
+			{ID: "l4", UserMessage: "bye", Message: "see you"},
+		}, nil
+	}
+	snapshot, errSnapshot := fetchDialogues(context.Background(), existing)
+	assert.Nil(s.T(), errSnapshot)
+	current, errCurrent := fetchDialogues(context.Background(), afterAttempt)
+	assert.Nil(s.T(), errCurrent)
+	// Each added line matches a single dialogue; lines of the snapshot and deleted lines don't match
+	assert.Equal(s.T(), []*AiDialogueInput{chunk[1], chunk[2]}, dropAddedDialogues(chunk, snapshot, current))
+}
+
+func (s *BulkAddToDatasetTestSuite) TestOnlyTypedErrorsAreTransient() {
+	assert.True(s.T(), isTransientError(transientError("unexpected EOF")))
+	assert.True(s.T(), isTransientError(fmt.Errorf("chunk failed: %w", newError("train dataset", errors.New("non-200 OK status code: 429 Too Many Requests")))))
+	assert.False(s.T(), isTransientError(newError("train dataset", errors.New("non-200 OK status code: 400 Bad Request"))))
+	assert.False(s.T(), isTransientError(errors.New("unexpected EOF")))
+	assert.False(s.T(), isTransientError(errors.New("i/o timeout")))
+}
+
+func (s *BulkAddToDatasetTestSuite) TestResume() {
+	errRejected := errors.New(`unable to train dataset, response: "Invalid dialogue"`)
+	s.failures[2] = errRejected
+	progress, errBulk := BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{ChunkSize: 10, RetryDelay: time.Millisecond, Lines: s.datasetLines})
+	assert.ErrorIs(s.T(), errBulk, errRejected)
+	// Non-transient errors are not retried
+	assert.Equal(s.T(), 2, s.calls)
+	assert.False(s.T(), progress.IsComplete())
+	assert.Equal(s.T(), 10, progress.Uploaded)
+
+	// Progress survives being persisted
+	data, errMarshal := json.Marshal(progress)
+	assert.Nil(s.T(), errMarshal)
+	stored := &BulkProgress{}
+	assert.Nil(s.T(), json.Unmarshal(data, stored))
+
+	progress, errBulk = BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{ChunkSize: 10, Resume: stored})
+	assert.Nil(s.T(), errBulk)
+	assert.True(s.T(), progress.IsComplete())
+	assert.Len(s.T(), s.lines, 25)
+	assert.Len(s.T(), progress.Result.Added, 25)
+	assert.Equal(s.T(), gql.String("user message 10"), progress.Result.Added[10].UserMessage)
+
+	// Resuming with other dialogues is refused
+	_, errBulk = BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues[:5], BulkOptions{Resume: stored})
+	assert.NotNil(s.T(), errBulk)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestRetriesExhausted() {
+	for i := 1; i <= 3; i++ {
+		s.failures[i] = newError("train dataset", errors.New("non-200 OK status code: 429 Too Many Requests"))
+	}
+	progress, errBulk := BulkAddToDatasetFunc(context.Background(), s.add, s.dialogues, BulkOptions{MaxRetries: 2, RetryDelay: time.Millisecond, Lines: s.datasetLines})
+	assert.NotNil(s.T(), errBulk)
+	assert.Equal(s.T(), 3, s.calls)
+	assert.Zero(s.T(), progress.Uploaded)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestCancelDuringBackoff() {
+	s.failures[1] = newError("train dataset", errors.New("non-200 OK status code: 502 Bad Gateway"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, errBulk := BulkAddToDatasetFunc(ctx, s.add, s.dialogues, BulkOptions{RetryDelay: time.Minute, Lines: s.datasetLines})
+	assert.ErrorIs(s.T(), errBulk, context.DeadlineExceeded)
+	assert.Equal(s.T(), 1, s.calls)
+}
+
+func (s *BulkAddToDatasetTestSuite) TestClient() {
+	requests, adds := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		in := struct {
+			Query     string `json:"query"`
+			Variables struct {
+				Dialogues []AiDialogueInput `json:"dialogues"`
+			} `json:"variables"`
+		}{}
+		_ = json.NewDecoder(r.Body).Decode(&in)
+		if strings.Contains(in.Query, "datasetLines") {
+			// The failed chunk wasn't added
+			_, _ = fmt.Fprint(w, `{"data":{"datasetLines":[]}}`)
+			return
+		}
+		if adds++; adds == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		added := make([]map[string]interface{}, 0)
+		for _, dialogue := range in.Variables.Dialogues {
+			added = append(added, map[string]interface{}{"userMessage": dialogue.UserMessage, "message": dialogue.Message})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"addToDataset": map[string]interface{}{"added": added, "count": len(added)},
+			},
+		})
+	}))
+	defer server.Close()
+
+	client := GetKajiwotoGraphQLClient(server.URL)
+	progress, errBulk := client.BulkAddToDataset(context.Background(), "g1", "token", s.dialogues, BulkOptions{ChunkSize: 20, RetryDelay: time.Millisecond})
+	assert.Nil(s.T(), errBulk)
+	// The dataset is checked before the upload and before the retry
+	assert.Equal(s.T(), 5, requests)
+	assert.Len(s.T(), progress.Result.Added, 25)
+}
@@ -0,0 +1,328 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"container/list"
+	"context"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCacheTTL is the time entries stay valid if not configured otherwise
+	DefaultCacheTTL = time.Minute
+	// DefaultCacheMaxEntries is the number of entries per cached query if not configured otherwise
+	DefaultCacheMaxEntries = 1000
+)
+
+// CacheOptions configures CachedClient
+type CacheOptions struct {
+	// TTL is the time entries stay valid; DefaultCacheTTL if 0
+	TTL time.Duration
+	// MaxEntries limits the entries per cached query; the least recently used entry is evicted first.
+	// DefaultCacheMaxEntries if 0, no limit if < 0.
+	MaxEntries int
+}
+
+// CachedClient is a KajiwotoGraphQLClient with a read-through cache in front of GetRoom and GetAITrainerGroup.
+// All other methods are passed through to the client.
+//
+// Entries are keyed by the query parameters and the auth token, so accounts sharing a CachedClient never see
+// each other's results. Results are shared between callers and must not be modified. Mutations of trainer groups,
+// their documents and datasets made through the CachedClient evict the changed group for all accounts.
+// Operations sent using Exec or ExecWithContext bypass this, as do changes made elsewhere; use InvalidateRoom or
+// InvalidateAITrainerGroup after changing an object that way, or evict rooms on websocket events using
+// websocket.NewKajiwotoWebSocketRoomChangeHandler:
+//
+//	wsClient.AddMessageHandler(websocket.NewKajiwotoWebSocketRoomChangeHandler(cachedClient.InvalidateRoom), false)
+type CachedClient struct {
+	*KajiwotoGraphQLClient
+	rooms         *ttlCache[roomCacheKey, Room]
+	trainerGroups *ttlCache[trainerGroupCacheKey, AITrainerGroup]
+}
+
+type roomCacheKey struct {
+	chatRoomID string
+	kajiID     string
+	authToken  string
+}
+
+type trainerGroupCacheKey struct {
+	aiTrainerGroupID string
+	authToken        string
+}
+
+// NewCachedClient wraps client with a cache
+func NewCachedClient(client *KajiwotoGraphQLClient, options CacheOptions) *CachedClient {
+	if options.TTL == 0 {
+		options.TTL = DefaultCacheTTL
+	}
+	if options.MaxEntries == 0 {
+		options.MaxEntries = DefaultCacheMaxEntries
+	}
+	return &CachedClient{
+		KajiwotoGraphQLClient: client,
+		rooms:                 newTTLCache[roomCacheKey, Room](options),
+		trainerGroups:         newTTLCache[trainerGroupCacheKey, AITrainerGroup](options),
+	}
+}
+
+// GetRoom calls GetRoomWithContext using a background context
+func (c *CachedClient) GetRoom(chatRoomID, kajiID, authToken string) (result Room, err error) {
+	return c.GetRoomWithContext(context.Background(), chatRoomID, kajiID, authToken)
+}
+
+// GetRoomWithContext returns the cached room data for the given chat room, fetching it if required
+func (c *CachedClient) GetRoomWithContext(ctx context.Context, chatRoomID, kajiID, authToken string) (result Room, err error) {
+	return c.rooms.get(roomCacheKey{chatRoomID: chatRoomID, kajiID: kajiID, authToken: authToken}, func() (Room, error) {
+		return c.KajiwotoGraphQLClient.GetRoomWithContext(ctx, chatRoomID, kajiID, authToken)
+	})
+}
+
+// GetAITrainerGroup calls GetAITrainerGroupWithContext using a background context
+func (c *CachedClient) GetAITrainerGroup(aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
+	return c.GetAITrainerGroupWithContext(context.Background(), aiTrainerGroupID, authToken)
+}
+
+// GetAITrainerGroupWithContext returns the cached AI trainer group with the given ID, fetching it if required
+func (c *CachedClient) GetAITrainerGroupWithContext(ctx context.Context, aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
+	return c.trainerGroups.get(trainerGroupCacheKey{aiTrainerGroupID: aiTrainerGroupID, authToken: authToken}, func() (AITrainerGroup, error) {
+		return c.KajiwotoGraphQLClient.GetAITrainerGroupWithContext(ctx, aiTrainerGroupID, authToken)
+	})
+}
+
+// UpdateAITrainerGroup calls UpdateAITrainerGroupWithContext using a background context
+func (c *CachedClient) UpdateAITrainerGroup(aiTrainerGroupID, authToken string, input *AITrainerGroupInput) (result AITrainerGroup, err error) {
+	return c.UpdateAITrainerGroupWithContext(context.Background(), aiTrainerGroupID, authToken, input)
+}
+
+// UpdateAITrainerGroupWithContext changes the metadata of an AI trainer group and evicts its cached entries
+func (c *CachedClient) UpdateAITrainerGroupWithContext(ctx context.Context, aiTrainerGroupID, authToken string, input *AITrainerGroupInput) (result AITrainerGroup, err error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.UpdateAITrainerGroupWithContext(ctx, aiTrainerGroupID, authToken, input)
+}
+
+// AddToDataset calls AddToDatasetWithContext using a background context
+func (c *CachedClient) AddToDataset(aiTrainerGroupID, authToken string, dialogues []*AiDialogueInput) (result AIEditorResult, err error) {
+	return c.AddToDatasetWithContext(context.Background(), aiTrainerGroupID, authToken, dialogues)
+}
+
+// AddToDatasetWithContext adds dialogues to the dataset of an AI trainer group and evicts its cached entries
+func (c *CachedClient) AddToDatasetWithContext(ctx context.Context, aiTrainerGroupID, authToken string, dialogues []*AiDialogueInput) (result AIEditorResult, err error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.AddToDatasetWithContext(ctx, aiTrainerGroupID, authToken, dialogues)
+}
+
+// BulkAddToDataset uploads dialogues to the dataset of an AI trainer group in chunks and evicts its cached entries.
+// See KajiwotoGraphQLClient.BulkAddToDataset for details.
+func (c *CachedClient) BulkAddToDataset(ctx context.Context, aiTrainerGroupID, authToken string, dialogues []*AiDialogueInput, options BulkOptions) (*BulkProgress, error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.BulkAddToDataset(ctx, aiTrainerGroupID, authToken, dialogues, options)
+}
+
+// CreateAIDocument calls CreateAIDocumentWithContext using a background context
+func (c *CachedClient) CreateAIDocument(aiTrainerGroupID, authToken, title, content string) (result AIDocument, err error) {
+	return c.CreateAIDocumentWithContext(context.Background(), aiTrainerGroupID, authToken, title, content)
+}
+
+// CreateAIDocumentWithContext adds a document to an AI trainer group and evicts its cached entries
+func (c *CachedClient) CreateAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, authToken, title, content string) (result AIDocument, err error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.CreateAIDocumentWithContext(ctx, aiTrainerGroupID, authToken, title, content)
+}
+
+// UpdateAIDocument calls UpdateAIDocumentWithContext using a background context
+func (c *CachedClient) UpdateAIDocument(aiTrainerGroupID, aiDocumentID, authToken, title, content string) (result AIDocument, err error) {
+	return c.UpdateAIDocumentWithContext(context.Background(), aiTrainerGroupID, aiDocumentID, authToken, title, content)
+}
+
+// UpdateAIDocumentWithContext replaces title and content of a document and evicts the cached entries of its trainer group
+func (c *CachedClient) UpdateAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken, title, content string) (result AIDocument, err error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.UpdateAIDocumentWithContext(ctx, aiTrainerGroupID, aiDocumentID, authToken, title, content)
+}
+
+// ReorderAIDocuments calls ReorderAIDocumentsWithContext using a background context
+func (c *CachedClient) ReorderAIDocuments(aiTrainerGroupID, authToken string, aiDocumentIDs []string) (result []AIDocument, err error) {
+	return c.ReorderAIDocumentsWithContext(context.Background(), aiTrainerGroupID, authToken, aiDocumentIDs)
+}
+
+// ReorderAIDocumentsWithContext sets the order of the documents of an AI trainer group and evicts its cached entries
+func (c *CachedClient) ReorderAIDocumentsWithContext(ctx context.Context, aiTrainerGroupID, authToken string, aiDocumentIDs []string) (result []AIDocument, err error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.ReorderAIDocumentsWithContext(ctx, aiTrainerGroupID, authToken, aiDocumentIDs)
+}
+
+// DeleteAIDocument calls DeleteAIDocumentWithContext using a background context
+func (c *CachedClient) DeleteAIDocument(aiTrainerGroupID, aiDocumentID, authToken string) (result []AIDocument, err error) {
+	return c.DeleteAIDocumentWithContext(context.Background(), aiTrainerGroupID, aiDocumentID, authToken)
+}
+
+// DeleteAIDocumentWithContext removes a document from an AI trainer group and evicts its cached entries
+func (c *CachedClient) DeleteAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken string) (result []AIDocument, err error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.DeleteAIDocumentWithContext(ctx, aiTrainerGroupID, aiDocumentID, authToken)
+}
+
+// RebuildAIDocument calls RebuildAIDocumentWithContext using a background context
+func (c *CachedClient) RebuildAIDocument(aiTrainerGroupID, aiDocumentID, authToken string) (result AIDocument, err error) {
+	return c.RebuildAIDocumentWithContext(context.Background(), aiTrainerGroupID, aiDocumentID, authToken)
+}
+
+// RebuildAIDocumentWithContext queues a document for building again and evicts the cached entries of its trainer group
+func (c *CachedClient) RebuildAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken string) (result AIDocument, err error) {
+	defer c.InvalidateAITrainerGroup(aiTrainerGroupID)
+	return c.KajiwotoGraphQLClient.RebuildAIDocumentWithContext(ctx, aiTrainerGroupID, aiDocumentID, authToken)
+}
+
+// InvalidateRoom evicts all cached entries of a chat room
+func (c *CachedClient) InvalidateRoom(chatRoomID string) {
+	removed := c.rooms.removeFunc(func(key roomCacheKey) bool {
+		return key.chatRoomID == chatRoomID
+	})
+	if removed > 0 {
+		log.Debugf("Evicted %v cached entries of room %v", removed, chatRoomID)
+	}
+}
+
+// InvalidateAITrainerGroup evicts all cached entries of an AI trainer group
+func (c *CachedClient) InvalidateAITrainerGroup(aiTrainerGroupID string) {
+	removed := c.trainerGroups.removeFunc(func(key trainerGroupCacheKey) bool {
+		return key.aiTrainerGroupID == aiTrainerGroupID
+	})
+	if removed > 0 {
+		log.Debugf("Evicted %v cached entries of AI trainer group %v", removed, aiTrainerGroupID)
+	}
+}
+
+// Purge evicts all cached entries
+func (c *CachedClient) Purge() {
+	c.rooms.removeFunc(func(key roomCacheKey) bool {
+		return true
+	})
+	c.trainerGroups.removeFunc(func(key trainerGroupCacheKey) bool {
+		return true
+	})
+}
+
+// ttlCache is a size limited LRU cache whose entries expire after a fixed time
+type ttlCache[K comparable, V any] struct {
+	ttl        time.Duration
+	maxEntries int
+	entries    map[K]*list.Element
+	lru        *list.List // Most recently used first
+	generation uint64     // Incremented by every invalidation, so fetches started before it aren't cached
+	now        func() time.Time
+	cacheMtx   sync.Mutex
+}
+
+type ttlCacheEntry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+func newTTLCache[K comparable, V any](options CacheOptions) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		ttl:        options.TTL,
+		maxEntries: options.MaxEntries,
+		entries:    make(map[K]*list.Element),
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// get returns the cached value for key, or calls fetch and caches its result. Errors are not cached.
+func (c *ttlCache[K, V]) get(key K, fetch func() (V, error)) (V, error) {
+	c.cacheMtx.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*ttlCacheEntry[K, V])
+		if c.now().Before(entry.expiresAt) {
+			c.lru.MoveToFront(element)
+			c.cacheMtx.Unlock()
+			return entry.value, nil
+		}
+		c.removeElement(element)
+	}
+	generation := c.generation
+	c.cacheMtx.Unlock()
+
+	value, errFetch := fetch()
+	if errFetch != nil {
+		return value, errFetch
+	}
+	c.set(key, value, generation)
+	return value, nil
+}
+
+// set caches value, unless the cache was invalidated since generation
+func (c *ttlCache[K, V]) set(key K, value V, generation uint64) {
+	c.cacheMtx.Lock()
+	defer c.cacheMtx.Unlock()
+	if c.generation != generation {
+		return
+	}
+	entry := &ttlCacheEntry[K, V]{key: key, value: value, expiresAt: c.now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// remove invalidates the entry of key, including a fetch which is still running
+func (c *ttlCache[K, V]) remove(key K) {
+	c.cacheMtx.Lock()
+	defer c.cacheMtx.Unlock()
+	c.generation++
+	if element, ok := c.entries[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+// removeFunc invalidates all entries whose key matches, including running fetches, and returns the number of removed entries
+func (c *ttlCache[K, V]) removeFunc(match func(key K) bool) int {
+	c.cacheMtx.Lock()
+	defer c.cacheMtx.Unlock()
+	c.generation++
+	removed := 0
+	for key, element := range c.entries {
+		if match(key) {
+			c.removeElement(element)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (c *ttlCache[K, V]) len() int {
+	c.cacheMtx.Lock()
+	defer c.cacheMtx.Unlock()
+	return c.lru.Len()
+}
+
+// removeElement removes an entry; cacheMtx must be held
+func (c *ttlCache[K, V]) removeElement(element *list.Element) {
+	entry := c.lru.Remove(element).(*ttlCacheEntry[K, V])
+	delete(c.entries, entry.key)
+}
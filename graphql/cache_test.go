@@ -0,0 +1,245 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type CacheTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	requests atomic.Int32
+}
+
+func TestCacheTestSuite(t *testing.T) {
+	suite.Run(t, new(CacheTestSuite))
+}
+
+func (s *CacheTestSuite) SetupTest() {
+	s.requests.Store(0)
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := s.requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Header.Get("auth_token") == "":
+			_, _ = fmt.Fprint(w, `{"errors":[{"message":"Unauthorized"}]}`)
+		case strings.Contains(string(body), "updateAITrainerGroup"):
+			_, _ = fmt.Fprint(w, `{"data":{"updateAITrainerGroup":{"id":"g1"}}}`)
+		case strings.Contains(string(body), "datasetLines"):
+			_, _ = fmt.Fprint(w, `{"data":{"datasetLines":[]}}`)
+		case strings.Contains(string(body), "addToDataset"):
+			_, _ = fmt.Fprint(w, `{"data":{"addToDataset":{"aiTrainerGroupId":"g1"}}}`)
+		case strings.Contains(string(body), "createAIDocument"):
+			_, _ = fmt.Fprint(w, `{"data":{"createAIDocument":{"id":"d1"}}}`)
+		case strings.Contains(string(body), "$aiTrainerGroupId"):
+			_, _ = fmt.Fprintf(w, `{"data":{"aiTrainerGroup":{"id":"g1","count":%d}}}`, count)
+		case strings.Contains(string(body), "loginWithToken"):
+			_, _ = fmt.Fprint(w, `{"data":{"loginWithToken":{"authToken":"token","settings":{"personalRoomOrder":[]}}}}`)
+		default:
+			_, _ = fmt.Fprintf(w, `{"data":{"room":{"chatRoomId":"c3d4","weight":%d}}}`, count)
+		}
+	}))
+}
+
+func (s *CacheTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *CacheTestSuite) TestCachedClient() {
+	client := NewCachedClient(GetKajiwotoGraphQLClient(s.server.URL), CacheOptions{})
+
+	room, errRoom := client.GetRoom("c3d4", "", "token")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), 1, int(room.Weight))
+	room, errRoom = client.GetRoom("c3d4", "", "token")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), 1, int(room.Weight))
+	assert.Equal(s.T(), int32(1), s.requests.Load())
+
+	// Different parameters are cached separately
+	room, errRoom = client.GetRoom("c3d4", "k1", "token")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), 2, int(room.Weight))
+
+	trainerGroup, errGroup := client.GetAITrainerGroup("g1", "token")
+	assert.Nil(s.T(), errGroup)
+	assert.Equal(s.T(), 3, int(trainerGroup.Count))
+	_, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), int32(3), s.requests.Load())
+
+	// Invalidating a room evicts it for all kajis
+	client.InvalidateRoom("c3d4")
+	room, _ = client.GetRoom("c3d4", "", "token")
+	assert.Equal(s.T(), 4, int(room.Weight))
+	room, _ = client.GetRoom("c3d4", "k1", "token")
+	assert.Equal(s.T(), 5, int(room.Weight))
+	trainerGroup, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 3, int(trainerGroup.Count))
+
+	client.InvalidateAITrainerGroup("g1")
+	trainerGroup, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 6, int(trainerGroup.Count))
+
+	client.Purge()
+	_, _ = client.GetRoom("c3d4", "", "token")
+	_, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), int32(8), s.requests.Load())
+
+	// Uncached methods are passed through
+	_, errRooms := client.GetRooms("token", 10, 0)
+	assert.Nil(s.T(), errRooms)
+	assert.Equal(s.T(), int32(9), s.requests.Load())
+}
+
+func (s *CacheTestSuite) TestAccountsAreCachedSeparately() {
+	client := NewCachedClient(GetKajiwotoGraphQLClient(s.server.URL), CacheOptions{})
+
+	room, _ := client.GetRoom("c3d4", "", "token-a")
+	assert.Equal(s.T(), 1, int(room.Weight))
+	room, _ = client.GetRoom("c3d4", "", "token-b")
+	assert.Equal(s.T(), 2, int(room.Weight))
+	trainerGroup, _ := client.GetAITrainerGroup("g1", "token-a")
+	assert.Equal(s.T(), 3, int(trainerGroup.Count))
+	trainerGroup, _ = client.GetAITrainerGroup("g1", "token-b")
+	assert.Equal(s.T(), 4, int(trainerGroup.Count))
+	assert.Equal(s.T(), int32(4), s.requests.Load())
+
+	// Invalidation affects all accounts
+	client.InvalidateAITrainerGroup("g1")
+	_, _ = client.GetAITrainerGroup("g1", "token-a")
+	_, _ = client.GetAITrainerGroup("g1", "token-b")
+	assert.Equal(s.T(), int32(6), s.requests.Load())
+}
+
+func (s *CacheTestSuite) TestMutationsEvictTrainerGroup() {
+	client := NewCachedClient(GetKajiwotoGraphQLClient(s.server.URL), CacheOptions{})
+
+	trainerGroup, _ := client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 1, int(trainerGroup.Count))
+	name := "Renamed"
+	_, errUpdate := client.UpdateAITrainerGroup("g1", "token", &AITrainerGroupInput{Name: &name})
+	assert.Nil(s.T(), errUpdate)
+	trainerGroup, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 3, int(trainerGroup.Count))
+
+	_, errCreate := client.CreateAIDocument("g1", "token", "Title", "Content")
+	assert.Nil(s.T(), errCreate)
+	trainerGroup, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 5, int(trainerGroup.Count))
+
+	// Failed mutations evict the entry as well, since they may have been applied partially
+	_, errCreate = client.CreateAIDocument("g1", "", "Title", "Content")
+	assert.NotNil(s.T(), errCreate)
+	trainerGroup, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 6, int(trainerGroup.Count))
+}
+
+func (s *CacheTestSuite) TestBulkUploadEvictsTrainerGroup() {
+	client := NewCachedClient(GetKajiwotoGraphQLClient(s.server.URL), CacheOptions{})
+
+	trainerGroup, _ := client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 1, int(trainerGroup.Count))
+	dialogues := []*AiDialogueInput{
+		{UserMessage: "hi", Message: "hey"},
+		{UserMessage: "bye", Message: "see you"},
+	}
+	progress, errUpload := client.BulkAddToDataset(context.Background(), "g1", "token", dialogues, BulkOptions{ChunkSize: 1})
+	assert.Nil(s.T(), errUpload)
+	assert.True(s.T(), progress.IsComplete())
+	// The upload checks the dataset lines first, then sends two chunks
+	trainerGroup, _ = client.GetAITrainerGroup("g1", "token")
+	assert.Equal(s.T(), 5, int(trainerGroup.Count))
+}
+
+func (s *CacheTestSuite) TestErrorsAreNotCached() {
+	client := NewCachedClient(GetKajiwotoGraphQLClient(s.server.URL), CacheOptions{})
+	_, errRoom := client.GetRoom("c3d4", "", "")
+	assert.NotNil(s.T(), errRoom)
+	_, errRoom = client.GetRoom("c3d4", "", "token")
+	assert.Nil(s.T(), errRoom)
+}
+
+func (s *CacheTestSuite) TestTTL() {
+	cache := newTTLCache[string, int](CacheOptions{TTL: time.Minute, MaxEntries: 10})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	fetches := 0
+	fetch := func() (int, error) {
+		fetches++
+		return fetches, nil
+	}
+
+	value, _ := cache.get("a", fetch)
+	assert.Equal(s.T(), 1, value)
+	now = now.Add(59 * time.Second)
+	value, _ = cache.get("a", fetch)
+	assert.Equal(s.T(), 1, value)
+	now = now.Add(time.Second)
+	value, _ = cache.get("a", fetch)
+	assert.Equal(s.T(), 2, value)
+}
+
+func (s *CacheTestSuite) TestMaxEntries() {
+	cache := newTTLCache[string, string](CacheOptions{TTL: time.Minute, MaxEntries: 2})
+	fetches := make([]string, 0)
+	fetch := func(key string) func() (string, error) {
+		return func() (string, error) {
+			fetches = append(fetches, key)
+			return strings.ToUpper(key), nil
+		}
+	}
+
+	_, _ = cache.get("a", fetch("a"))
+	_, _ = cache.get("b", fetch("b"))
+	// Using "a" makes "b" the least recently used entry
+	_, _ = cache.get("a", fetch("a"))
+	_, _ = cache.get("c", fetch("c"))
+	assert.Equal(s.T(), 2, cache.len())
+	_, _ = cache.get("a", fetch("a"))
+	_, _ = cache.get("b", fetch("b"))
+	assert.Equal(s.T(), []string{"a", "b", "c", "b"}, fetches)
+}
+
+func (s *CacheTestSuite) TestInvalidateDuringFetch() {
+	cache := newTTLCache[string, int](CacheOptions{TTL: time.Minute, MaxEntries: 10})
+	value, _ := cache.get("a", func() (int, error) {
+		// The fetched value may be stale already
+		cache.remove("a")
+		return 1, nil
+	})
+	assert.Equal(s.T(), 1, value)
+	assert.Equal(s.T(), 0, cache.len())
+
+	_, errFetch := cache.get("a", func() (int, error) {
+		return 0, errors.New("connection refused")
+	})
+	assert.NotNil(s.T(), errFetch)
+	assert.Equal(s.T(), 0, cache.len())
+}
@@ -18,47 +18,87 @@ package graphql
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	gql "github.com/runtimeracer/go-graphql-client"
 	"net/http"
+	"sync"
 )
 
+const (
+	headerAuthToken = "auth_token"
+	// ListMaxPageSize is the highest limit accepted by GetRooms
+	ListMaxPageSize = 100
+)
+
+// authTokenContextKey is used to carry the auth token of a single request through its context
+type authTokenContextKey struct{}
+
+// withAuthToken returns a copy of ctx which makes the headerTransport send authToken with the request
+func withAuthToken(ctx context.Context, authToken string) context.Context {
+	return context.WithValue(ctx, authTokenContextKey{}, authToken)
+}
+
 // headerTransport is used to add custom headers to the request
 // shootout to tgwizard; https://github.com/shurcooL/graphql/issues/28
 type headerTransport struct {
-	base    http.RoundTripper
-	headers map[string]string
+	base       http.RoundTripper
+	headers    map[string]string
+	headersMtx sync.RWMutex
 }
 
 func (h *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 	req2 := cloneRequest(req)
+	h.headersMtx.RLock()
 	for key, val := range h.headers {
 		req2.Header.Set(key, val)
 	}
+	h.headersMtx.RUnlock()
+	// Per-request auth token takes precedence over shared headers
+	if authToken, ok := req.Context().Value(authTokenContextKey{}).(string); ok && authToken != "" {
+		req2.Header.Set(headerAuthToken, authToken)
+	}
 	return h.base.RoundTrip(req2)
 }
 
+// GetHeaders returns a copy of the headers sent with every request
 func (h *headerTransport) GetHeaders() map[string]string {
-	return h.headers
+	h.headersMtx.RLock()
+	defer h.headersMtx.RUnlock()
+	headers := make(map[string]string, len(h.headers))
+	for k, v := range h.headers {
+		headers[k] = v
+	}
+	return headers
 }
 
 func (h *headerTransport) AddHeaders(newHeaders map[string]string) {
+	h.headersMtx.Lock()
 	for k, v := range newHeaders {
 		h.headers[k] = v
 	}
+	h.headersMtx.Unlock()
 }
 
 // KajiwotoGraphQLClient is a custom graphql client for kajiwoto reqeusts using the graphql API
 type KajiwotoGraphQLClient struct {
 	client          *gql.Client
 	transportClient *http.Client
+	endpoint        string
 }
 
-func GetKajiwotoGraphQLClient(endpoint string) *KajiwotoGraphQLClient {
+// GetKajiwotoGraphQLClient creates a client for the given endpoint.
+// Options allow adding transport middlewares, e.g. WithMiddleware(RetryMiddleware(RetryOptions{}), RateLimitMiddleware(5, 10)).
+func GetKajiwotoGraphQLClient(endpoint string, options ...ClientOption) *KajiwotoGraphQLClient {
+	config := &clientConfig{}
+	for _, option := range options {
+		option(config)
+	}
+
 	// Init HTTP Client
 	transportClient := &http.Client{
 		Transport: &headerTransport{
-			base: http.DefaultTransport,
+			base: config.buildTransport(),
 			headers: map[string]string{
 				// Default Headers
 				"Content-Type": "application/json",
@@ -69,6 +109,7 @@ func GetKajiwotoGraphQLClient(endpoint string) *KajiwotoGraphQLClient {
 	return &KajiwotoGraphQLClient{
 		client:          gql.NewClient(endpoint, transportClient),
 		transportClient: transportClient,
+		endpoint:        endpoint,
 	}
 }
 
@@ -76,12 +117,19 @@ func (c *KajiwotoGraphQLClient) GetHeaders() map[string]string {
 	return c.transportClient.Transport.(*headerTransport).GetHeaders()
 }
 
+// AddHeaders adds headers which are sent with every request of this client.
+// Auth tokens should not be set this way; they are passed per call instead, so a client can be shared between users.
 func (c *KajiwotoGraphQLClient) AddHeaders(newHeaders map[string]string) {
 	c.transportClient.Transport.(*headerTransport).AddHeaders(newHeaders)
 }
 
-// DoLoginUserPW performs login via user / pw combination
+// DoLoginUserPW calls DoLoginUserPWWithContext using a background context
 func (c *KajiwotoGraphQLClient) DoLoginUserPW(username, password string) (result LoginResult, err error) {
+	return c.DoLoginUserPWWithContext(context.Background(), username, password)
+}
+
+// DoLoginUserPWWithContext performs login via user / pw combination
+func (c *KajiwotoGraphQLClient) DoLoginUserPWWithContext(ctx context.Context, username, password string) (result LoginResult, err error) {
 	// Sanity check
 	if username == "" || password == "" {
 		return result, fmt.Errorf("invalid login credentials")
@@ -93,8 +141,8 @@ func (c *KajiwotoGraphQLClient) DoLoginUserPW(username, password string) (result
 	}
 
 	loginResult := kajiwotoLoginUserPWMutation{}
-	if errLogin := c.performGraphMutation(vars, &loginResult); errLogin != nil {
-		return result, errLogin
+	if errLogin := c.performGraphMutation(ctx, vars, &loginResult); errLogin != nil {
+		return result, newError("login", errLogin)
 	}
 
 	// Build generic Result object
@@ -106,8 +154,13 @@ func (c *KajiwotoGraphQLClient) DoLoginUserPW(username, password string) (result
 	return result, nil
 }
 
-// DoLoginAuthToken performs login via session key if available
+// DoLoginAuthToken calls DoLoginAuthTokenWithContext using a background context
 func (c *KajiwotoGraphQLClient) DoLoginAuthToken(authToken string) (result LoginResult, err error) {
+	return c.DoLoginAuthTokenWithContext(context.Background(), authToken)
+}
+
+// DoLoginAuthTokenWithContext performs login via session key if available
+func (c *KajiwotoGraphQLClient) DoLoginAuthTokenWithContext(ctx context.Context, authToken string) (result LoginResult, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid login credentials")
@@ -118,15 +171,12 @@ func (c *KajiwotoGraphQLClient) DoLoginAuthToken(authToken string) (result Login
 		"action":    gql.String(""),
 	}
 
-	// Add Auth-Token header
-	headers := map[string]string{
-		"auth_token": authToken,
-	}
-	c.AddHeaders(headers)
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
 
 	loginResult := kajiwotoLoginAuthTokenMutation{}
-	if errLogin := c.performGraphMutation(vars, &loginResult); errLogin != nil {
-		return result, fmt.Errorf("unable to login, response: %q", errLogin)
+	if errLogin := c.performGraphMutation(ctx, vars, &loginResult); errLogin != nil {
+		return result, newError("login", errLogin)
 	}
 
 	// Build generic Result object
@@ -138,7 +188,13 @@ func (c *KajiwotoGraphQLClient) DoLoginAuthToken(authToken string) (result Login
 	return result, nil
 }
 
+// GetAITrainerGroup calls GetAITrainerGroupWithContext using a background context
 func (c *KajiwotoGraphQLClient) GetAITrainerGroup(aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
+	return c.GetAITrainerGroupWithContext(context.Background(), aiTrainerGroupID, authToken)
+}
+
+// GetAITrainerGroupWithContext fetches the AI trainer group with the given ID
+func (c *KajiwotoGraphQLClient) GetAITrainerGroupWithContext(ctx context.Context, aiTrainerGroupID, authToken string) (result AITrainerGroup, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid auth token")
@@ -151,16 +207,13 @@ func (c *KajiwotoGraphQLClient) GetAITrainerGroup(aiTrainerGroupID, authToken st
 		"aiTrainerGroupId": gql.String(aiTrainerGroupID),
 	}
 
-	// Add Auth-Token header
-	headers := map[string]string{
-		"auth_token": authToken,
-	}
-	c.AddHeaders(headers)
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
 
 	// Execute Query
 	aiTrainerGroupResult := kajiwotoDatasetAITrainerGroupQuery{}
-	if errLogin := c.performGraphQuery(vars, &aiTrainerGroupResult); errLogin != nil {
-		return result, fmt.Errorf("unable to fetch AI trainer group, response: %q", errLogin)
+	if errLogin := c.performGraphQuery(ctx, vars, &aiTrainerGroupResult); errLogin != nil {
+		return result, newError("fetch AI trainer group", errLogin)
 	}
 
 	// Build generic Result object
@@ -168,7 +221,13 @@ func (c *KajiwotoGraphQLClient) GetAITrainerGroup(aiTrainerGroupID, authToken st
 	return result, nil
 }
 
+// GetDatasetLines calls GetDatasetLinesWithContext using a background context
 func (c *KajiwotoGraphQLClient) GetDatasetLines(aiTrainerGroupID, searchQuery, authToken string, limit, offset int) (result []DatasetLine, err error) {
+	return c.GetDatasetLinesWithContext(context.Background(), aiTrainerGroupID, searchQuery, authToken, limit, offset)
+}
+
+// GetDatasetLinesWithContext fetches a page of dataset lines of an AI trainer group
+func (c *KajiwotoGraphQLClient) GetDatasetLinesWithContext(ctx context.Context, aiTrainerGroupID, searchQuery, authToken string, limit, offset int) (result []DatasetLine, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid auth token")
@@ -176,7 +235,7 @@ func (c *KajiwotoGraphQLClient) GetDatasetLines(aiTrainerGroupID, searchQuery, a
 	if aiTrainerGroupID == "" {
 		return result, fmt.Errorf("invalid trainer group ID")
 	}
-	if limit < 1 || limit > 100 {
+	if limit < 1 || limit > DatasetLinesMaxPageSize {
 		return result, fmt.Errorf("limit exceeds allowed range")
 	}
 	if offset < 0 {
@@ -190,16 +249,13 @@ func (c *KajiwotoGraphQLClient) GetDatasetLines(aiTrainerGroupID, searchQuery, a
 		"offset":           gql.Int(offset),
 	}
 
-	// Add Auth-Token header
-	headers := map[string]string{
-		"auth_token": authToken,
-	}
-	c.AddHeaders(headers)
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
 
 	// Execute Query
 	datasetLinesResult := kajiwotoDatasetLinesQuery{}
-	if errQuery := c.performGraphQuery(vars, &datasetLinesResult); errQuery != nil {
-		return result, fmt.Errorf("unable to fetch dataset lines, response: %q", errQuery)
+	if errQuery := c.performGraphQuery(ctx, vars, &datasetLinesResult); errQuery != nil {
+		return result, newError("fetch dataset lines", errQuery)
 	}
 
 	// Build generic Result object
@@ -207,7 +263,13 @@ func (c *KajiwotoGraphQLClient) GetDatasetLines(aiTrainerGroupID, searchQuery, a
 	return result, nil
 }
 
+// AddToDataset calls AddToDatasetWithContext using a background context
 func (c *KajiwotoGraphQLClient) AddToDataset(aiTrainerGroupID, authToken string, dialogues []*AiDialogueInput) (result AIEditorResult, err error) {
+	return c.AddToDatasetWithContext(context.Background(), aiTrainerGroupID, authToken, dialogues)
+}
+
+// AddToDatasetWithContext adds the given dialogues to the dataset of an AI trainer group
+func (c *KajiwotoGraphQLClient) AddToDatasetWithContext(ctx context.Context, aiTrainerGroupID, authToken string, dialogues []*AiDialogueInput) (result AIEditorResult, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid login credentials")
@@ -220,15 +282,12 @@ func (c *KajiwotoGraphQLClient) AddToDataset(aiTrainerGroupID, authToken string,
 		"generateResults":  gql.Boolean(false),
 	}
 
-	// Add Auth-Token header
-	headers := map[string]string{
-		"auth_token": authToken,
-	}
-	c.AddHeaders(headers)
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
 
 	trainingResult := kajiwotoAddToDatasetMutation{}
-	if errTrain := c.performGraphMutation(vars, &trainingResult); errTrain != nil {
-		return result, fmt.Errorf("unable to train dataset, response: %q", errTrain)
+	if errTrain := c.performGraphMutation(ctx, vars, &trainingResult); errTrain != nil {
+		return result, newError("train dataset", errTrain)
 	}
 
 	// Build generic Result object
@@ -236,7 +295,13 @@ func (c *KajiwotoGraphQLClient) AddToDataset(aiTrainerGroupID, authToken string,
 	return result, nil
 }
 
+// GetRoom calls GetRoomWithContext using a background context
 func (c *KajiwotoGraphQLClient) GetRoom(chatRoomID, kajiID, authToken string) (result Room, err error) {
+	return c.GetRoomWithContext(context.Background(), chatRoomID, kajiID, authToken)
+}
+
+// GetRoomWithContext fetches the room data for the given chat room
+func (c *KajiwotoGraphQLClient) GetRoomWithContext(ctx context.Context, chatRoomID, kajiID, authToken string) (result Room, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid auth token")
@@ -250,16 +315,13 @@ func (c *KajiwotoGraphQLClient) GetRoom(chatRoomID, kajiID, authToken string) (r
 		"kajiId":     gql.String(kajiID),
 	}
 
-	// Add Auth-Token header
-	headers := map[string]string{
-		"auth_token": authToken,
-	}
-	c.AddHeaders(headers)
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
 
 	// Execute Query
 	roomResult := kajiwotoRoomQuery{}
-	if errLogin := c.performGraphQuery(vars, &roomResult); errLogin != nil {
-		return result, fmt.Errorf("unable to fetch room, response: %q", errLogin)
+	if errLogin := c.performGraphQuery(ctx, vars, &roomResult); errLogin != nil {
+		return result, newError("fetch room", errLogin)
 	}
 
 	// Build generic Result object
@@ -267,7 +329,13 @@ func (c *KajiwotoGraphQLClient) GetRoom(chatRoomID, kajiID, authToken string) (r
 	return result, nil
 }
 
+// GetRoomHistory calls GetRoomHistoryWithContext using a background context
 func (c *KajiwotoGraphQLClient) GetRoomHistory(chatRoomID, kajiID, authToken string) (result RoomHistory, err error) {
+	return c.GetRoomHistoryWithContext(context.Background(), chatRoomID, kajiID, authToken)
+}
+
+// GetRoomHistoryWithContext fetches the message history for the given chat room
+func (c *KajiwotoGraphQLClient) GetRoomHistoryWithContext(ctx context.Context, chatRoomID, kajiID, authToken string) (result RoomHistory, err error) {
 	// Sanity check
 	if authToken == "" {
 		return result, fmt.Errorf("invalid auth token")
@@ -281,16 +349,13 @@ func (c *KajiwotoGraphQLClient) GetRoomHistory(chatRoomID, kajiID, authToken str
 		"kajiId":     gql.String(kajiID),
 	}
 
-	// Add Auth-Token header
-	headers := map[string]string{
-		"auth_token": authToken,
-	}
-	c.AddHeaders(headers)
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
 
 	// Execute Query
 	roomResult := kajiwotoRoomHistoryQuery{}
-	if errLogin := c.performGraphQuery(vars, &roomResult); errLogin != nil {
-		return result, fmt.Errorf("unable to fetch room, response: %q", errLogin)
+	if errLogin := c.performGraphQuery(ctx, vars, &roomResult); errLogin != nil {
+		return result, newError("fetch room", errLogin)
 	}
 
 	// Build generic Result object
@@ -298,12 +363,54 @@ func (c *KajiwotoGraphQLClient) GetRoomHistory(chatRoomID, kajiID, authToken str
 	return result, nil
 }
 
-func (c *KajiwotoGraphQLClient) performGraphMutation(vars map[string]interface{}, mutation interface{}) error {
-	return c.client.Mutate(context.Background(), mutation, vars)
+// GetRooms calls GetRoomsWithContext using a background context
+func (c *KajiwotoGraphQLClient) GetRooms(authToken string, limit, offset int) (result []Room, err error) {
+	return c.GetRoomsWithContext(context.Background(), authToken, limit, offset)
+}
+
+// GetRoomsWithContext fetches a page of the personal rooms of the logged-in user.
+// The chat room IDs are taken from Settings.PersonalRoomOrder of the login, and each room of the page is fetched via GetRoom.
+func (c *KajiwotoGraphQLClient) GetRoomsWithContext(ctx context.Context, authToken string, limit, offset int) (result []Room, err error) {
+	// Sanity check
+	if authToken == "" {
+		return result, fmt.Errorf("invalid auth token")
+	}
+	if limit < 1 || limit > ListMaxPageSize {
+		return result, fmt.Errorf("limit exceeds allowed range")
+	}
+	if offset < 0 {
+		return result, fmt.Errorf("offset cannot be negative")
+	}
+
+	loginResult, errLogin := c.DoLoginAuthTokenWithContext(ctx, authToken)
+	if errLogin != nil {
+		return result, errLogin
+	}
+	if loginResult.Login.AuthToken == "" {
+		// The backend answers outdated tokens with an empty login
+		return result, &Error{Op: "fetch rooms", Kind: ErrUnauthorized, Err: errors.New("auth token not accepted")}
+	}
+
+	chatRoomIDs := loginResult.Login.Settings.PersonalRoomOrder
+	result = make([]Room, 0)
+	for i := offset; i < len(chatRoomIDs) && i < offset+limit; i++ {
+		room, errRoom := c.GetRoomWithContext(ctx, string(chatRoomIDs[i]), "", authToken)
+		if errRoom != nil {
+			return nil, errRoom
+		}
+		result = append(result, room)
+	}
+	return result, nil
+}
+
+// performGraphMutation executes the mutation; ctx is passed on to the underlying HTTP request,
+// so cancelling it or hitting its deadline aborts the call.
+func (c *KajiwotoGraphQLClient) performGraphMutation(ctx context.Context, vars map[string]interface{}, mutation interface{}) error {
+	return c.client.Mutate(ctx, mutation, vars)
 }
 
-func (c *KajiwotoGraphQLClient) performGraphQuery(vars map[string]interface{}, query interface{}) error {
-	return c.client.Query(context.Background(), query, vars)
+func (c *KajiwotoGraphQLClient) performGraphQuery(ctx context.Context, vars map[string]interface{}, query interface{}) error {
+	return c.client.Query(ctx, query, vars)
 }
 
 // cloneRequest creates a shallow copy of the request along with a deep copy of the Headers.
@@ -17,15 +17,20 @@ limitations under the License.
 package graphql
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/runtimeracer/kajiwoto-clientsdk-golang/constants"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 type GraphQLClientTestSuite struct {
@@ -128,3 +133,62 @@ func (s *GraphQLClientTestSuite) TestGraphQLGetRoomHistory() {
 	assert.Equal(s.T(), strings.ToLower(string(roomHistory.ChatRoomID)), strings.ToLower(roomID))
 	assert.NotNil(s.T(), roomHistory.Messages)
 }
+
+// TestGraphQLContextCancellation runs offline, so it is not part of GraphQLClientTestSuite
+func TestGraphQLContextCancellation(t *testing.T) {
+	// Server which never answers until the test is done
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// Init Client
+	client := GetKajiwotoGraphQLClient(server.URL)
+
+	// Deadline should abort the pending request
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, errRoom := client.GetRoomWithContext(ctx, "c3d4", "", "token")
+	assert.NotNil(t, errRoom)
+	assert.Contains(t, errRoom.Error(), context.DeadlineExceeded.Error())
+	assert.ErrorIs(t, errRoom, context.DeadlineExceeded)
+	assert.ErrorIs(t, errRoom, ErrTransport)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+// TestGraphQLConcurrentAuthTokens runs offline, so it is not part of GraphQLClientTestSuite
+func TestGraphQLConcurrentAuthTokens(t *testing.T) {
+	// Server echoes the auth token of each request back as room ID
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"data":{"room":{"id":%q,"chatRoomId":"c3d4"}}}`, r.Header.Get("auth_token"))
+	}))
+	defer server.Close()
+
+	// Init Client shared by all users
+	client := GetKajiwotoGraphQLClient(server.URL)
+
+	// Run with -race to verify the client does not share state between calls
+	wg := sync.WaitGroup{}
+	for user := 0; user < 20; user++ {
+		wg.Add(1)
+		go func(authToken string) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				room, errRoom := client.GetRoom("c3d4", "", authToken)
+				assert.Nil(t, errRoom)
+				assert.Equal(t, authToken, string(room.ID))
+			}
+		}(fmt.Sprintf("token-%d", user))
+	}
+	wg.Wait()
+
+	// Auth tokens must never leak into the shared headers
+	assert.NotContains(t, client.GetHeaders(), "auth_token")
+}
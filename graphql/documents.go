@@ -0,0 +1,369 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// Queue states of an AIDocument.
+// Experimental: the values are not verified against the live backend yet, which affects WaitForAIDocumentBuild.
+const (
+	AIDocumentStatusQueued   = "QUEUED"
+	AIDocumentStatusBuilding = "BUILDING"
+	AIDocumentStatusBuilt    = "BUILT"
+	AIDocumentStatusFailed   = "FAILED"
+)
+
+const (
+	// DefaultAIDocumentPollInterval is the delay between two status checks of WaitForAIDocumentBuild if not configured otherwise
+	DefaultAIDocumentPollInterval = 2 * time.Second
+	// DefaultAIDocumentBuildTimeout is the maximum time WaitForAIDocumentBuild waits if not configured otherwise
+	DefaultAIDocumentBuildTimeout = 5 * time.Minute
+)
+
+var (
+	// ErrAIDocumentBuildFailed means the backend reported a failed build of an AI document
+	ErrAIDocumentBuildFailed = errors.New("AI document build failed")
+	// ErrUnknownAIDocumentStatus means the backend reported a queue status WaitForAIDocumentBuild doesn't know
+	ErrUnknownAIDocumentStatus = errors.New("unknown AI document queue status")
+)
+
+// WaitOptions configures WaitForAIDocumentBuild
+type WaitOptions struct {
+	// PollInterval is the delay between two status checks; DefaultAIDocumentPollInterval if 0
+	PollInterval time.Duration
+	// Timeout is the maximum time to wait; DefaultAIDocumentBuildTimeout if 0, no timeout besides ctx if < 0
+	Timeout time.Duration
+}
+
+// IsPending checks whether the document is queued or being built
+func (d *AIDocument) IsPending() bool {
+	return d.QueueStatus == AIDocumentStatusQueued || d.QueueStatus == AIDocumentStatusBuilding
+}
+
+// CreateAIDocument calls CreateAIDocumentWithContext using a background context
+func (c *KajiwotoGraphQLClient) CreateAIDocument(aiTrainerGroupID, authToken, title, content string) (result AIDocument, err error) {
+	return c.CreateAIDocumentWithContext(context.Background(), aiTrainerGroupID, authToken, title, content)
+}
+
+// CreateAIDocumentWithContext adds a document to an AI trainer group. The new document is queued for building.
+//
+// Experimental: the createAIDocument mutation is not verified against the live backend yet, see README.md.
+func (c *KajiwotoGraphQLClient) CreateAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, authToken, title, content string) (result AIDocument, err error) {
+	// Sanity check
+	if authToken == "" {
+		return result, fmt.Errorf("invalid auth token")
+	}
+	if aiTrainerGroupID == "" {
+		return result, fmt.Errorf("invalid trainer group ID")
+	}
+	if title == "" {
+		return result, fmt.Errorf("invalid document title")
+	}
+
+	vars := map[string]interface{}{
+		"aiTrainerGroupId": gql.String(aiTrainerGroupID),
+		"title":            gql.String(title),
+		"content":          gql.String(content),
+	}
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	createResult := kajiwotoCreateAIDocumentMutation{}
+	if errCreate := c.performGraphMutation(ctx, vars, &createResult); errCreate != nil {
+		return result, newError("create AI document", errCreate)
+	}
+
+	// Build generic Result object
+	result = createResult.AIDocument
+	return result, nil
+}
+
+// UpdateAIDocument calls UpdateAIDocumentWithContext using a background context
+func (c *KajiwotoGraphQLClient) UpdateAIDocument(aiTrainerGroupID, aiDocumentID, authToken, title, content string) (result AIDocument, err error) {
+	return c.UpdateAIDocumentWithContext(context.Background(), aiTrainerGroupID, aiDocumentID, authToken, title, content)
+}
+
+// UpdateAIDocumentWithContext replaces title and content of a document. The document is queued for building again.
+//
+// Experimental: the updateAIDocument mutation is not verified against the live backend yet, see README.md.
+func (c *KajiwotoGraphQLClient) UpdateAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken, title, content string) (result AIDocument, err error) {
+	vars, errVars := aiDocumentVars(aiTrainerGroupID, aiDocumentID, authToken)
+	if errVars != nil {
+		return result, errVars
+	}
+	if title == "" {
+		return result, fmt.Errorf("invalid document title")
+	}
+	vars["title"] = gql.String(title)
+	vars["content"] = gql.String(content)
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	updateResult := kajiwotoUpdateAIDocumentMutation{}
+	if errUpdate := c.performGraphMutation(ctx, vars, &updateResult); errUpdate != nil {
+		return result, newError("update AI document", errUpdate)
+	}
+
+	// Build generic Result object
+	result = updateResult.AIDocument
+	return result, nil
+}
+
+// ReorderAIDocuments calls ReorderAIDocumentsWithContext using a background context
+func (c *KajiwotoGraphQLClient) ReorderAIDocuments(aiTrainerGroupID, authToken string, aiDocumentIDs []string) (result []AIDocument, err error) {
+	return c.ReorderAIDocumentsWithContext(context.Background(), aiTrainerGroupID, authToken, aiDocumentIDs)
+}
+
+// ReorderAIDocumentsWithContext sets the order of the documents of an AI trainer group.
+// aiDocumentIDs must contain the IDs of all documents of the group; the reordered documents are returned.
+//
+// Experimental: the reorderAIDocuments mutation is not verified against the live backend yet, see README.md.
+func (c *KajiwotoGraphQLClient) ReorderAIDocumentsWithContext(ctx context.Context, aiTrainerGroupID, authToken string, aiDocumentIDs []string) (result []AIDocument, err error) {
+	// Sanity check
+	if authToken == "" {
+		return result, fmt.Errorf("invalid auth token")
+	}
+	if aiTrainerGroupID == "" {
+		return result, fmt.Errorf("invalid trainer group ID")
+	}
+	if len(aiDocumentIDs) == 0 {
+		return result, fmt.Errorf("no document IDs given")
+	}
+
+	ids := make([]gql.String, 0, len(aiDocumentIDs))
+	for _, aiDocumentID := range aiDocumentIDs {
+		if aiDocumentID == "" {
+			return result, fmt.Errorf("invalid document ID")
+		}
+		ids = append(ids, gql.String(aiDocumentID))
+	}
+	vars := map[string]interface{}{
+		"aiTrainerGroupId": gql.String(aiTrainerGroupID),
+		"aiDocumentIds":    ids,
+	}
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	reorderResult := kajiwotoReorderAIDocumentsMutation{}
+	if errReorder := c.performGraphMutation(ctx, vars, &reorderResult); errReorder != nil {
+		return result, newError("reorder AI documents", errReorder)
+	}
+
+	// Build generic Result object
+	result = reorderResult.AIDocuments
+	return result, nil
+}
+
+// DeleteAIDocument calls DeleteAIDocumentWithContext using a background context
+func (c *KajiwotoGraphQLClient) DeleteAIDocument(aiTrainerGroupID, aiDocumentID, authToken string) (result []AIDocument, err error) {
+	return c.DeleteAIDocumentWithContext(context.Background(), aiTrainerGroupID, aiDocumentID, authToken)
+}
+
+// DeleteAIDocumentWithContext removes a document from an AI trainer group; the remaining documents are returned
+//
+// Experimental: the deleteAIDocument mutation is not verified against the live backend yet, see README.md.
+func (c *KajiwotoGraphQLClient) DeleteAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken string) (result []AIDocument, err error) {
+	vars, errVars := aiDocumentVars(aiTrainerGroupID, aiDocumentID, authToken)
+	if errVars != nil {
+		return result, errVars
+	}
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	deleteResult := kajiwotoDeleteAIDocumentMutation{}
+	if errDelete := c.performGraphMutation(ctx, vars, &deleteResult); errDelete != nil {
+		return result, newError("delete AI document", errDelete)
+	}
+
+	// Build generic Result object
+	result = deleteResult.AIDocuments
+	return result, nil
+}
+
+// RebuildAIDocument calls RebuildAIDocumentWithContext using a background context
+func (c *KajiwotoGraphQLClient) RebuildAIDocument(aiTrainerGroupID, aiDocumentID, authToken string) (result AIDocument, err error) {
+	return c.RebuildAIDocumentWithContext(context.Background(), aiTrainerGroupID, aiDocumentID, authToken)
+}
+
+// RebuildAIDocumentWithContext queues a document for building again, e.g. after a failed build
+//
+// Experimental: the rebuildAIDocument mutation is not verified against the live backend yet, see README.md.
+func (c *KajiwotoGraphQLClient) RebuildAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken string) (result AIDocument, err error) {
+	vars, errVars := aiDocumentVars(aiTrainerGroupID, aiDocumentID, authToken)
+	if errVars != nil {
+		return result, errVars
+	}
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	rebuildResult := kajiwotoRebuildAIDocumentMutation{}
+	if errRebuild := c.performGraphMutation(ctx, vars, &rebuildResult); errRebuild != nil {
+		return result, newError("rebuild AI document", errRebuild)
+	}
+
+	// Build generic Result object
+	result = rebuildResult.AIDocument
+	return result, nil
+}
+
+// GetAIDocument calls GetAIDocumentWithContext using a background context
+func (c *KajiwotoGraphQLClient) GetAIDocument(aiTrainerGroupID, aiDocumentID, authToken string) (result AIDocument, err error) {
+	return c.GetAIDocumentWithContext(context.Background(), aiTrainerGroupID, aiDocumentID, authToken)
+}
+
+// GetAIDocumentWithContext fetches a single document of an AI trainer group
+func (c *KajiwotoGraphQLClient) GetAIDocumentWithContext(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken string) (result AIDocument, err error) {
+	trainerGroup, errGroup := c.GetAITrainerGroupWithContext(ctx, aiTrainerGroupID, authToken)
+	if errGroup != nil {
+		return result, errGroup
+	}
+	return findAIDocument(trainerGroup, aiDocumentID)
+}
+
+// WaitForAIDocumentBuild polls the queue status of a document until it is no longer queued or building.
+// It returns ErrAIDocumentBuildFailed if the build failed, ErrUnknownAIDocumentStatus for a status other than the
+// AIDocumentStatus constants, and the context error if the timeout is exceeded;
+// in all cases the last fetched state of the document is returned as well.
+func (c *KajiwotoGraphQLClient) WaitForAIDocumentBuild(ctx context.Context, aiTrainerGroupID, aiDocumentID, authToken string, options WaitOptions) (AIDocument, error) {
+	return waitForAIDocumentBuild(ctx, func(ctx context.Context) (AITrainerGroup, error) {
+		return c.GetAITrainerGroupWithContext(ctx, aiTrainerGroupID, authToken)
+	}, aiDocumentID, options)
+}
+
+// CreateAIDocument adds a document to an AI trainer group
+func (s *Session) CreateAIDocument(ctx context.Context, aiTrainerGroupID, title, content string) (AIDocument, error) {
+	return withSession(ctx, s, func(authToken string) (AIDocument, error) {
+		return s.client.CreateAIDocumentWithContext(ctx, aiTrainerGroupID, authToken, title, content)
+	})
+}
+
+// UpdateAIDocument replaces title and content of a document
+func (s *Session) UpdateAIDocument(ctx context.Context, aiTrainerGroupID, aiDocumentID, title, content string) (AIDocument, error) {
+	return withSession(ctx, s, func(authToken string) (AIDocument, error) {
+		return s.client.UpdateAIDocumentWithContext(ctx, aiTrainerGroupID, aiDocumentID, authToken, title, content)
+	})
+}
+
+// ReorderAIDocuments sets the order of the documents of an AI trainer group
+func (s *Session) ReorderAIDocuments(ctx context.Context, aiTrainerGroupID string, aiDocumentIDs []string) ([]AIDocument, error) {
+	return withSession(ctx, s, func(authToken string) ([]AIDocument, error) {
+		return s.client.ReorderAIDocumentsWithContext(ctx, aiTrainerGroupID, authToken, aiDocumentIDs)
+	})
+}
+
+// DeleteAIDocument removes a document from an AI trainer group
+func (s *Session) DeleteAIDocument(ctx context.Context, aiTrainerGroupID, aiDocumentID string) ([]AIDocument, error) {
+	return withSession(ctx, s, func(authToken string) ([]AIDocument, error) {
+		return s.client.DeleteAIDocumentWithContext(ctx, aiTrainerGroupID, aiDocumentID, authToken)
+	})
+}
+
+// RebuildAIDocument queues a document for building again
+func (s *Session) RebuildAIDocument(ctx context.Context, aiTrainerGroupID, aiDocumentID string) (AIDocument, error) {
+	return withSession(ctx, s, func(authToken string) (AIDocument, error) {
+		return s.client.RebuildAIDocumentWithContext(ctx, aiTrainerGroupID, aiDocumentID, authToken)
+	})
+}
+
+// WaitForAIDocumentBuild polls the queue status of a document until it is no longer queued or building.
+// See KajiwotoGraphQLClient.WaitForAIDocumentBuild for details.
+func (s *Session) WaitForAIDocumentBuild(ctx context.Context, aiTrainerGroupID, aiDocumentID string, options WaitOptions) (AIDocument, error) {
+	return waitForAIDocumentBuild(ctx, func(ctx context.Context) (AITrainerGroup, error) {
+		return s.GetAITrainerGroup(ctx, aiTrainerGroupID)
+	}, aiDocumentID, options)
+}
+
+func waitForAIDocumentBuild(ctx context.Context, fetch func(ctx context.Context) (AITrainerGroup, error), aiDocumentID string, options WaitOptions) (AIDocument, error) {
+	pollInterval := options.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultAIDocumentPollInterval
+	}
+	timeout := options.Timeout
+	if timeout == 0 {
+		timeout = DefaultAIDocumentBuildTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	for {
+		trainerGroup, errFetch := fetch(ctx)
+		if errFetch != nil {
+			return AIDocument{}, errFetch
+		}
+		document, errFind := findAIDocument(trainerGroup, aiDocumentID)
+		if errFind != nil {
+			return document, errFind
+		}
+		switch document.QueueStatus {
+		case AIDocumentStatusBuilt:
+			return document, nil
+		case AIDocumentStatusFailed:
+			return document, fmt.Errorf("%w: %v", ErrAIDocumentBuildFailed, document.ID)
+		case AIDocumentStatusQueued, AIDocumentStatusBuilding:
+		default:
+			return document, fmt.Errorf("%w: AI document %v is %q", ErrUnknownAIDocumentStatus, document.ID, document.QueueStatus)
+		}
+
+		log.Debugf("AI document %v is %v, checking again in %v", document.ID, document.QueueStatus, pollInterval)
+		if errWait := sleep(ctx, pollInterval); errWait != nil {
+			return document, fmt.Errorf("AI document %v still %v: %w", document.ID, document.QueueStatus, errWait)
+		}
+	}
+}
+
+// findAIDocument looks up a document of a trainer group by its ID
+func findAIDocument(trainerGroup AITrainerGroup, aiDocumentID string) (AIDocument, error) {
+	for _, document := range trainerGroup.Documents {
+		if string(document.ID) == aiDocumentID {
+			return document, nil
+		}
+	}
+	return AIDocument{}, fmt.Errorf("%w: AI document %v in trainer group %v", ErrNotFound, aiDocumentID, trainerGroup.ID)
+}
+
+// aiDocumentVars validates the parameters of mutations on a single document
+func aiDocumentVars(aiTrainerGroupID, aiDocumentID, authToken string) (map[string]interface{}, error) {
+	// Sanity check
+	if authToken == "" {
+		return nil, fmt.Errorf("invalid auth token")
+	}
+	if aiTrainerGroupID == "" {
+		return nil, fmt.Errorf("invalid trainer group ID")
+	}
+	if aiDocumentID == "" {
+		return nil, fmt.Errorf("invalid document ID")
+	}
+	return map[string]interface{}{
+		"aiTrainerGroupId": gql.String(aiTrainerGroupID),
+		"aiDocumentId":     gql.String(aiDocumentID),
+	}, nil
+}
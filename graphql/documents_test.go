@@ -0,0 +1,107 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type WaitForAIDocumentBuildTestSuite struct {
+	suite.Suite
+	// fake backend state
+	statuses []string // queue status returned by each fetch; the last one repeats
+	fetches  int
+}
+
+func TestWaitForAIDocumentBuildTestSuite(t *testing.T) {
+	suite.Run(t, new(WaitForAIDocumentBuildTestSuite))
+}
+
+func (s *WaitForAIDocumentBuildTestSuite) SetupTest() {
+	s.statuses = nil
+	s.fetches = 0
+}
+
+// fetch emulates the aiTrainerGroup query
+func (s *WaitForAIDocumentBuildTestSuite) fetch(ctx context.Context) (AITrainerGroup, error) {
+	status := s.statuses[len(s.statuses)-1]
+	if s.fetches < len(s.statuses) {
+		status = s.statuses[s.fetches]
+	}
+	s.fetches++
+	return AITrainerGroup{
+		ID: "g1",
+		Documents: []AIDocument{
+			{ID: "d0", QueueStatus: AIDocumentStatusBuilt},
+			{ID: "d1", QueueStatus: gql.String(status)},
+		},
+	}, nil
+}
+
+func (s *WaitForAIDocumentBuildTestSuite) TestBuilt() {
+	s.statuses = []string{AIDocumentStatusQueued, AIDocumentStatusBuilding, AIDocumentStatusBuilt}
+	document, errWait := waitForAIDocumentBuild(context.Background(), s.fetch, "d1", WaitOptions{PollInterval: time.Millisecond})
+	assert.Nil(s.T(), errWait)
+	assert.Equal(s.T(), 3, s.fetches)
+	assert.False(s.T(), document.IsPending())
+}
+
+func (s *WaitForAIDocumentBuildTestSuite) TestFailed() {
+	s.statuses = []string{AIDocumentStatusQueued, AIDocumentStatusFailed}
+	document, errWait := waitForAIDocumentBuild(context.Background(), s.fetch, "d1", WaitOptions{PollInterval: time.Millisecond})
+	assert.ErrorIs(s.T(), errWait, ErrAIDocumentBuildFailed)
+	assert.Equal(s.T(), AIDocumentStatusFailed, string(document.QueueStatus))
+}
+
+func (s *WaitForAIDocumentBuildTestSuite) TestUnknownStatus() {
+	for _, status := range []string{"", "DONE"} {
+		s.SetupTest()
+		s.statuses = []string{AIDocumentStatusQueued, status}
+		document, errWait := waitForAIDocumentBuild(context.Background(), s.fetch, "d1", WaitOptions{PollInterval: time.Millisecond})
+		assert.ErrorIs(s.T(), errWait, ErrUnknownAIDocumentStatus)
+		assert.Equal(s.T(), status, string(document.QueueStatus))
+		assert.Equal(s.T(), 2, s.fetches)
+	}
+}
+
+func (s *WaitForAIDocumentBuildTestSuite) TestTimeout() {
+	s.statuses = []string{AIDocumentStatusQueued}
+	document, errWait := waitForAIDocumentBuild(context.Background(), s.fetch, "d1", WaitOptions{PollInterval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond})
+	assert.ErrorIs(s.T(), errWait, context.DeadlineExceeded)
+	assert.True(s.T(), document.IsPending())
+	assert.Greater(s.T(), s.fetches, 1)
+}
+
+func (s *WaitForAIDocumentBuildTestSuite) TestNotFound() {
+	s.statuses = []string{AIDocumentStatusQueued}
+	_, errWait := waitForAIDocumentBuild(context.Background(), s.fetch, "d2", WaitOptions{PollInterval: time.Millisecond})
+	assert.ErrorIs(s.T(), errWait, ErrNotFound)
+}
+
+func (s *WaitForAIDocumentBuildTestSuite) TestFetchError() {
+	errFetch := errors.New("connection refused")
+	_, errWait := waitForAIDocumentBuild(context.Background(), func(ctx context.Context) (AITrainerGroup, error) {
+		return AITrainerGroup{}, errFetch
+	}, "d1", WaitOptions{})
+	assert.ErrorIs(s.T(), errWait, errFetch)
+}
@@ -0,0 +1,159 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnauthorized means the backend rejected the credentials or auth token
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound means the requested object does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrRateLimited means the backend refused the request because too many requests were sent
+	ErrRateLimited = errors.New("rate limited")
+	// ErrValidation means the backend rejected the request or its variables as invalid
+	ErrValidation = errors.New("validation failed")
+	// ErrTransport means no response was received, e.g. due to network failure or a cancelled context
+	ErrTransport = errors.New("transport error")
+)
+
+// statusCodePattern extracts the HTTP status from the error returned by the graphql library for non-200 responses
+var statusCodePattern = regexp.MustCompile(`^non-200 OK status code: (\d{3})`)
+
+// Error is returned by all requests of KajiwotoGraphQLClient which reached the request stage.
+// Use errors.Is with ErrUnauthorized, ErrNotFound, ErrRateLimited, ErrValidation or ErrTransport to check the kind of failure,
+// or errors.As to access the details. Errors of the underlying HTTP request, like context.DeadlineExceeded, are unwrapped as well.
+type Error struct {
+	Op         string   // Operation which failed, e.g. "fetch room"
+	StatusCode int      // HTTP status code of a non-200 response; 0 otherwise
+	Messages   []string // Messages of the GraphQL errors array
+	Kind       error    // One of the sentinel errors of this package; nil if the kind is unknown
+	Err        error    // Underlying error
+}
+
+// newError classifies err, as returned by the graphql library, into an *Error
+func newError(op string, err error) *Error {
+	e := &Error{
+		Op:  op,
+		Err: err,
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) {
+		e.Kind = ErrTransport
+		return e
+	}
+	if match := statusCodePattern.FindStringSubmatch(err.Error()); match != nil {
+		e.StatusCode, _ = strconv.Atoi(match[1])
+		e.Kind = kindFromStatusCode(e.StatusCode)
+		return e
+	}
+	e.Messages = graphQLErrorMessages(err)
+	for _, message := range e.Messages {
+		if e.Kind = kindFromMessage(message); e.Kind != nil {
+			break
+		}
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("unable to %v, response: %q", e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is match the sentinel error describing the kind of failure
+func (e *Error) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// IsTransient checks whether repeating the request might succeed
+func (e *Error) IsTransient() bool {
+	if e.Kind == ErrRateLimited {
+		return true
+	}
+	if e.Kind == ErrTransport {
+		return !errors.Is(e.Err, context.Canceled) && !errors.Is(e.Err, context.DeadlineExceeded)
+	}
+	return e.StatusCode >= http.StatusInternalServerError
+}
+
+// graphQLErrorMessages returns the messages of the errors array the graphql library returns as error.
+// The library does not export its error type, so the messages are read using reflection.
+func graphQLErrorMessages(err error) []string {
+	value := reflect.ValueOf(err)
+	if value.Kind() != reflect.Slice || value.Type().Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	messages := make([]string, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		if message := value.Index(i).FieldByName("Message"); message.IsValid() && message.Kind() == reflect.String {
+			messages = append(messages, message.String())
+		}
+	}
+	return messages
+}
+
+func kindFromStatusCode(statusCode int) error {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return ErrValidation
+	default:
+		return nil
+	}
+}
+
+// kindFromMessage guesses the kind of a GraphQL error, since the backend does not send error codes
+func kindFromMessage(message string) error {
+	message = strings.ToLower(message)
+	hints := []struct {
+		kind  error
+		hints []string
+	}{
+		{ErrUnauthorized, []string{"unauthorized", "unauthenticated", "not authorized", "not logged in", "invalid token", "token expired", "forbidden", "invalid username", "invalid password", "wrong password"}},
+		{ErrRateLimited, []string{"rate limit", "too many requests"}},
+		{ErrNotFound, []string{"not found", "does not exist", "no such"}},
+		{ErrValidation, []string{"invalid", "validation", "required", "must be", "cannot query field", "expected type", "syntax error"}},
+	}
+	for _, kind := range hints {
+		for _, hint := range kind.hints {
+			if strings.Contains(message, hint) {
+				return kind.kind
+			}
+		}
+	}
+	return nil
+}
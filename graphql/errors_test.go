@@ -0,0 +1,121 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type ErrorTestSuite struct {
+	suite.Suite
+}
+
+func TestErrorTestSuite(t *testing.T) {
+	suite.Run(t, new(ErrorTestSuite))
+}
+
+// helperGetRoomResponse returns the error of GetRoom against a server sending the given response
+func (s *ErrorTestSuite) helperGetRoomResponse(statusCode int, body string) error {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	_, errRoom := GetKajiwotoGraphQLClient(server.URL).GetRoomWithContext(context.Background(), "c3d4", "", "token")
+	return errRoom
+}
+
+func (s *ErrorTestSuite) TestGraphQLErrors() {
+	cases := []struct {
+		message string
+		kind    error
+	}{
+		{"Unauthorized", ErrUnauthorized},
+		{"Room not found", ErrNotFound},
+		{"Rate limit exceeded, try again later", ErrRateLimited},
+		{`Variable "$chatRoomId" got invalid value`, ErrValidation},
+	}
+	for _, c := range cases {
+		errRoom := s.helperGetRoomResponse(http.StatusOK, fmt.Sprintf(`{"data":null,"errors":[{"message":%q}]}`, c.message))
+		assert.ErrorIs(s.T(), errRoom, c.kind, c.message)
+
+		var graphQLErr *Error
+		assert.True(s.T(), errors.As(errRoom, &graphQLErr), c.message)
+		assert.Equal(s.T(), "fetch room", graphQLErr.Op)
+		assert.Equal(s.T(), []string{c.message}, graphQLErr.Messages)
+		assert.Zero(s.T(), graphQLErr.StatusCode)
+	}
+
+	// Unknown messages keep their text, but have no kind
+	errRoom := s.helperGetRoomResponse(http.StatusOK, `{"data":null,"errors":[{"message":"Something odd happened"}]}`)
+	for _, kind := range []error{ErrUnauthorized, ErrNotFound, ErrRateLimited, ErrValidation, ErrTransport} {
+		assert.False(s.T(), errors.Is(errRoom, kind))
+	}
+	assert.Equal(s.T(), `unable to fetch room, response: "Something odd happened"`, errRoom.Error())
+}
+
+func (s *ErrorTestSuite) TestHTTPStatus() {
+	cases := map[int]error{
+		http.StatusUnauthorized:    ErrUnauthorized,
+		http.StatusForbidden:       ErrUnauthorized,
+		http.StatusNotFound:        ErrNotFound,
+		http.StatusTooManyRequests: ErrRateLimited,
+		http.StatusBadRequest:      ErrValidation,
+	}
+	for statusCode, kind := range cases {
+		errRoom := s.helperGetRoomResponse(statusCode, "")
+		assert.ErrorIs(s.T(), errRoom, kind, statusCode)
+		var graphQLErr *Error
+		assert.True(s.T(), errors.As(errRoom, &graphQLErr))
+		assert.Equal(s.T(), statusCode, graphQLErr.StatusCode)
+		assert.Equal(s.T(), statusCode == http.StatusTooManyRequests, graphQLErr.IsTransient())
+	}
+
+	errRoom := s.helperGetRoomResponse(http.StatusBadGateway, "")
+	var graphQLErr *Error
+	assert.True(s.T(), errors.As(errRoom, &graphQLErr))
+	assert.Nil(s.T(), graphQLErr.Kind)
+	assert.True(s.T(), graphQLErr.IsTransient())
+}
+
+func (s *ErrorTestSuite) TestTransport() {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, errRoom := GetKajiwotoGraphQLClient(server.URL).GetRoomWithContext(context.Background(), "c3d4", "", "token")
+	assert.ErrorIs(s.T(), errRoom, ErrTransport)
+	var graphQLErr *Error
+	assert.True(s.T(), errors.As(errRoom, &graphQLErr))
+	assert.True(s.T(), graphQLErr.IsTransient())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, errRoom = GetKajiwotoGraphQLClient(server.URL).GetRoomWithContext(ctx, "c3d4", "", "token")
+	assert.ErrorIs(s.T(), errRoom, ErrTransport)
+	assert.ErrorIs(s.T(), errRoom, context.Canceled)
+	assert.True(s.T(), errors.As(errRoom, &graphQLErr))
+	assert.False(s.T(), graphQLErr.IsTransient())
+}
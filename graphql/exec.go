@@ -0,0 +1,136 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// rawRequest is the payload of a GraphQL request
+type rawRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// rawResponse is the payload of a GraphQL response
+type rawResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors responseErrors  `json:"errors"`
+}
+
+// responseErrors is the errors array of a GraphQL response. Like the errors of the graphql library,
+// it is a slice of structs with a Message field, so newError can read the messages.
+type responseErrors []responseError
+
+type responseError struct {
+	Message string `json:"message"`
+}
+
+func (e responseErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Message)
+	}
+	return strings.Join(messages, ",")
+}
+
+// Exec calls ExecWithContext using a background context
+func (c *KajiwotoGraphQLClient) Exec(query string, variables map[string]interface{}, authToken string, result interface{}) error {
+	return c.ExecWithContext(context.Background(), query, variables, authToken, result)
+}
+
+// ExecWithContext runs an arbitrary GraphQL document, for operations the SDK has no wrapper for yet:
+//
+//	var result struct {
+//		Room struct {
+//			KajiDisplayName string `json:"kajiDisplayName"`
+//		} `json:"room"`
+//	}
+//	err := client.ExecWithContext(ctx, `query ($chatRoomId: String!) { room (chatRoomId: $chatRoomId) { kajiDisplayName } }`,
+//		map[string]interface{}{"chatRoomId": "abcd"}, authToken, &result)
+//
+// The data of the response is decoded into result using encoding/json; pass a pointer to a struct or to a
+// map[string]interface{}, or nil to ignore the data. authToken may be empty for public operations.
+// Failures are returned as *Error, like for all other requests. If the response holds both data and errors,
+// the data is decoded before the error is returned.
+func (c *KajiwotoGraphQLClient) ExecWithContext(ctx context.Context, query string, variables map[string]interface{}, authToken string, result interface{}) error {
+	// Sanity check
+	if strings.TrimSpace(query) == "" {
+		return fmt.Errorf("invalid query")
+	}
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	if errExec := c.performRawRequest(ctx, rawRequest{Query: query, Variables: variables}, result); errExec != nil {
+		return newError("execute query", errExec)
+	}
+	return nil
+}
+
+// performRawRequest sends the request through the client's transport. Its errors match those of the graphql library,
+// so they are classified the same way.
+func (c *KajiwotoGraphQLClient) performRawRequest(ctx context.Context, request rawRequest, result interface{}) error {
+	body, errMarshal := json.Marshal(request)
+	if errMarshal != nil {
+		return errMarshal
+	}
+	req, errRequest := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if errRequest != nil {
+		return errRequest
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, errDo := c.transportClient.Do(req)
+	if errDo != nil {
+		return errDo
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("non-200 OK status code: %v body: %q", resp.Status, respBody)
+	}
+
+	response := rawResponse{}
+	if errDecode := json.NewDecoder(resp.Body).Decode(&response); errDecode != nil {
+		return fmt.Errorf("unable to decode response: %w", errDecode)
+	}
+	if result != nil && len(response.Data) > 0 && string(response.Data) != "null" {
+		if errUnmarshal := json.Unmarshal(response.Data, result); errUnmarshal != nil {
+			return fmt.Errorf("unable to decode response data: %w", errUnmarshal)
+		}
+	}
+	if len(response.Errors) > 0 {
+		return response.Errors
+	}
+	return nil
+}
+
+// Exec runs an arbitrary GraphQL document with the session's auth token.
+// See KajiwotoGraphQLClient.ExecWithContext for details.
+func (s *Session) Exec(ctx context.Context, query string, variables map[string]interface{}, result interface{}) error {
+	_, errExec := withSession(ctx, s, func(authToken string) (struct{}, error) {
+		return struct{}{}, s.client.ExecWithContext(ctx, query, variables, authToken, result)
+	})
+	return errExec
+}
@@ -0,0 +1,120 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type ExecTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+	client *KajiwotoGraphQLClient
+	// Backend state
+	request   rawRequest
+	authToken string
+	respond   func(w http.ResponseWriter)
+}
+
+func TestExecTestSuite(t *testing.T) {
+	suite.Run(t, new(ExecTestSuite))
+}
+
+func (s *ExecTestSuite) SetupTest() {
+	s.request = rawRequest{}
+	s.authToken = ""
+	s.respond = func(w http.ResponseWriter) {
+		_, _ = w.Write([]byte(`{"data":{"room":{"kajiDisplayName":"Wanda","weight":3}}}`))
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&s.request)
+		s.authToken = r.Header.Get(headerAuthToken)
+		w.Header().Set("Content-Type", "application/json")
+		s.respond(w)
+	}))
+	s.client = GetKajiwotoGraphQLClient(s.server.URL)
+}
+
+func (s *ExecTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *ExecTestSuite) TestExecStruct() {
+	query := `query ($chatRoomId: String!) { room (chatRoomId: $chatRoomId) { kajiDisplayName, weight } }`
+	var result struct {
+		Room struct {
+			KajiDisplayName string `json:"kajiDisplayName"`
+			Weight          int    `json:"weight"`
+		} `json:"room"`
+	}
+	errExec := s.client.Exec(query, map[string]interface{}{"chatRoomId": "c3d4"}, "token", &result)
+	assert.Nil(s.T(), errExec)
+	assert.Equal(s.T(), "Wanda", result.Room.KajiDisplayName)
+	assert.Equal(s.T(), 3, result.Room.Weight)
+	assert.Equal(s.T(), query, s.request.Query)
+	assert.Equal(s.T(), map[string]interface{}{"chatRoomId": "c3d4"}, s.request.Variables)
+	assert.Equal(s.T(), "token", s.authToken)
+}
+
+func (s *ExecTestSuite) TestExecMap() {
+	result := map[string]interface{}{}
+	errExec := s.client.Exec(`{ room { kajiDisplayName } }`, nil, "", &result)
+	assert.Nil(s.T(), errExec)
+	assert.Equal(s.T(), "Wanda", result["room"].(map[string]interface{})["kajiDisplayName"])
+	assert.Empty(s.T(), s.authToken)
+
+	assert.Nil(s.T(), s.client.Exec(`{ room { kajiDisplayName } }`, nil, "", nil))
+	assert.NotNil(s.T(), s.client.Exec(" ", nil, "", nil))
+}
+
+func (s *ExecTestSuite) TestExecErrors() {
+	// Partial data is decoded along with the error
+	s.respond = func(w http.ResponseWriter) {
+		_, _ = w.Write([]byte(`{"data":{"room":{"kajiDisplayName":"Wanda"}},"errors":[{"message":"Room not found"}]}`))
+	}
+	result := map[string]interface{}{}
+	errExec := s.client.Exec(`{ room { kajiDisplayName } }`, nil, "token", &result)
+	assert.ErrorIs(s.T(), errExec, ErrNotFound)
+	var graphQLErr *Error
+	if assert.ErrorAs(s.T(), errExec, &graphQLErr) {
+		assert.Equal(s.T(), "execute query", graphQLErr.Op)
+		assert.Equal(s.T(), []string{"Room not found"}, graphQLErr.Messages)
+	}
+	assert.NotEmpty(s.T(), result)
+
+	s.respond = func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	errExec = s.client.Exec(`{ room { kajiDisplayName } }`, nil, "token", nil)
+	assert.ErrorIs(s.T(), errExec, ErrRateLimited)
+
+	s.respond = func(w http.ResponseWriter) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	errExec = s.client.ExecWithContext(ctx, `{ room { kajiDisplayName } }`, nil, "token", nil)
+	assert.ErrorIs(s.T(), errExec, ErrTransport)
+	assert.ErrorIs(s.T(), errExec, context.DeadlineExceeded)
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package graphql
 
 import (
+	"encoding/json"
 	gql "github.com/runtimeracer/go-graphql-client"
 )
 
@@ -116,6 +117,38 @@ type AITrainerGroup struct {
 	User            User
 }
 
+// AITrainerGroupInput holds the metadata of an AI trainer group to create or update.
+// Fields left nil are omitted from the request, so an update doesn't change them; set an empty slice to clear
+// Tags or Personalities.
+//
+// Experimental: the input type is not verified against the live backend yet, see README.md.
+type AITrainerGroupInput struct {
+	Name            *string    `json:"name,omitempty"`
+	Description     *string    `json:"description,omitempty"`
+	Tags            []string   `json:"tags"`
+	NSFW            *bool      `json:"nsfw,omitempty"`
+	Personalities   [][]string `json:"personalities"`
+	Price           *int       `json:"price,omitempty"`
+	ProfilePhotoUri *string    `json:"profilePhotoUri,omitempty"`
+}
+
+// MarshalJSON omits nil slices, while empty slices are sent to clear the field
+func (i AITrainerGroupInput) MarshalJSON() ([]byte, error) {
+	type plainInput AITrainerGroupInput
+	input := struct {
+		plainInput
+		Tags          *[]string   `json:"tags,omitempty"`
+		Personalities *[][]string `json:"personalities,omitempty"`
+	}{plainInput: plainInput(i)}
+	if i.Tags != nil {
+		input.Tags = &i.Tags
+	}
+	if i.Personalities != nil {
+		input.Personalities = &i.Personalities
+	}
+	return json.Marshal(input)
+}
+
 type Kudos struct {
 	ID       gql.String
 	Upvoted  gql.Boolean
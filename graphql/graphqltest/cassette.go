@@ -0,0 +1,365 @@
+// Package graphqltest
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphqltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+const (
+	// Redacted replaces secrets in recorded interactions
+	Redacted = "REDACTED"
+)
+
+var (
+	ErrInteractionNotFound = errors.New("no matching interaction in cassette")
+)
+
+// DefaultRedactedKeys are the JSON keys whose values are removed from recorded variables and responses
+var DefaultRedactedKeys = []string{"password", "authToken", "auth_token"}
+
+// Interaction is a single recorded request / response pair
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the GraphQL payload of a recorded request. Headers are not recorded.
+type RecordedRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables,omitempty"`
+}
+
+// RecordedResponse is a recorded response; Body is used for JSON responses, RawBody for anything else
+type RecordedResponse struct {
+	StatusCode  int             `json:"statusCode"`
+	ContentType string          `json:"contentType,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	RawBody     string          `json:"rawBody,omitempty"`
+}
+
+// Cassette is a list of recorded interactions which can be stored as JSON file
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// LoadCassette reads a cassette written by Cassette.Save
+func LoadCassette(path string) (*Cassette, error) {
+	data, errRead := os.ReadFile(path)
+	if errRead != nil {
+		return nil, errRead
+	}
+	cassette := &Cassette{}
+	if errUnmarshal := json.Unmarshal(data, cassette); errUnmarshal != nil {
+		return nil, fmt.Errorf("unable to parse cassette %v: %w", path, errUnmarshal)
+	}
+	return cassette, nil
+}
+
+// Save writes the cassette to path
+func (c *Cassette) Save(path string) error {
+	data, errMarshal := json.MarshalIndent(c, "", "  ")
+	if errMarshal != nil {
+		return errMarshal
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Recorder records all requests passing through it. Secrets are redacted before an interaction is stored,
+// so cassettes can be committed. Use its Wrap method as middleware:
+//
+//	recorder := graphqltest.NewRecorder()
+//	client := graphql.GetKajiwotoGraphQLClient(constants.KWGraphQLEndpoint, graphql.WithMiddleware(recorder.Wrap))
+//	...
+//	err := recorder.Cassette().Save("testdata/session.json")
+type Recorder struct {
+	// RedactedKeys are the JSON keys whose values are redacted; DefaultRedactedKeys if empty
+	RedactedKeys []string
+	cassette     Cassette
+	cassetteMtx  sync.Mutex
+}
+
+// NewRecorder creates a recorder with an empty cassette
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Wrap returns a transport recording all interactions of next; it satisfies graphql.Middleware
+func (r *Recorder) Wrap(next http.RoundTripper) http.RoundTripper {
+	return graphql.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		recorded, body, errRead := readRequest(req)
+		if errRead != nil {
+			return nil, errRead
+		}
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		resp, errRoundTrip := next.RoundTrip(req)
+		if errRoundTrip != nil {
+			return resp, errRoundTrip
+		}
+		respBody, errReadBody := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if errReadBody != nil {
+			return nil, errReadBody
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+		redactedKeys := r.redactedKeys()
+		interaction := Interaction{
+			Request: RecordedRequest{
+				Query:     recorded.Query,
+				Variables: redact(recorded.Variables, redactedKeys).(map[string]interface{}),
+			},
+			Response: RecordedResponse{
+				StatusCode:  resp.StatusCode,
+				ContentType: resp.Header.Get("Content-Type"),
+			},
+		}
+		var decoded interface{}
+		if json.Unmarshal(respBody, &decoded) == nil {
+			interaction.Response.Body, _ = json.Marshal(redact(decoded, redactedKeys))
+		} else {
+			interaction.Response.RawBody = string(respBody)
+		}
+
+		r.cassetteMtx.Lock()
+		r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+		r.cassetteMtx.Unlock()
+		return resp, nil
+	})
+}
+
+// Cassette returns a copy of the interactions recorded so far
+func (r *Recorder) Cassette() *Cassette {
+	r.cassetteMtx.Lock()
+	defer r.cassetteMtx.Unlock()
+	return &Cassette{Interactions: append([]Interaction{}, r.cassette.Interactions...)}
+}
+
+func (r *Recorder) redactedKeys() []string {
+	if len(r.RedactedKeys) == 0 {
+		return DefaultRedactedKeys
+	}
+	return r.RedactedKeys
+}
+
+// ReplayMode defines how a Replayer picks the response for a request
+type ReplayMode int
+
+const (
+	// ReplayInOrder expects requests in exactly the recorded order
+	ReplayInOrder ReplayMode = iota
+	// ReplayMatchQuery answers each request with the first unused interaction with the same query and variables,
+	// or with the same query if the variables match no interaction
+	ReplayMatchQuery
+)
+
+// Replayer answers requests with the responses of a cassette, without network access.
+// Use it as base transport:
+//
+//	client := graphql.GetKajiwotoGraphQLClient("http://replay", graphql.WithBaseTransport(graphqltest.NewReplayer(cassette, graphqltest.ReplayInOrder)))
+type Replayer struct {
+	interactions []Interaction
+	used         []bool
+	mode         ReplayMode
+	redactedKeys []string
+	replayMtx    sync.Mutex
+}
+
+// NewReplayer creates a replayer for the interactions of cassette.
+// Variables of incoming requests are redacted with DefaultRedactedKeys before they are compared.
+func NewReplayer(cassette *Cassette, mode ReplayMode) *Replayer {
+	return &Replayer{
+		interactions: cassette.Interactions,
+		used:         make([]bool, len(cassette.Interactions)),
+		mode:         mode,
+		redactedKeys: DefaultRedactedKeys,
+	}
+}
+
+// SetRedactedKeys sets the keys used when recording the cassette, if they differ from DefaultRedactedKeys
+func (r *Replayer) SetRedactedKeys(keys []string) {
+	r.replayMtx.Lock()
+	r.redactedKeys = keys
+	r.replayMtx.Unlock()
+}
+
+// Remaining returns the number of interactions which have not been replayed yet
+func (r *Replayer) Remaining() int {
+	r.replayMtx.Lock()
+	defer r.replayMtx.Unlock()
+	remaining := 0
+	for _, used := range r.used {
+		if !used {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded, _, errRead := readRequest(req)
+	if errRead != nil {
+		return nil, errRead
+	}
+
+	r.replayMtx.Lock()
+	variables := redact(recorded.Variables, r.redactedKeys).(map[string]interface{})
+	i := r.find(recorded.Query, variables)
+	if i >= 0 {
+		r.used[i] = true
+	}
+	r.replayMtx.Unlock()
+	if i < 0 {
+		return nil, fmt.Errorf("%w: %v", ErrInteractionNotFound, summarizeQuery(recorded.Query))
+	}
+
+	recordedResponse := r.interactions[i].Response
+	body := []byte(recordedResponse.RawBody)
+	if len(recordedResponse.Body) > 0 {
+		body = recordedResponse.Body
+	}
+	header := http.Header{}
+	if recordedResponse.ContentType != "" {
+		header.Set("Content-Type", recordedResponse.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", recordedResponse.StatusCode, http.StatusText(recordedResponse.StatusCode)),
+		StatusCode:    recordedResponse.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// find returns the index of the interaction answering the request, or -1; replayMtx must be held
+func (r *Replayer) find(query string, variables map[string]interface{}) int {
+	if r.mode == ReplayInOrder {
+		for i, used := range r.used {
+			if used {
+				continue
+			}
+			if r.interactions[i].Request.Query == query && variablesEqual(r.interactions[i].Request.Variables, variables) {
+				return i
+			}
+			return -1
+		}
+		return -1
+	}
+
+	queryMatch := -1
+	for i, interaction := range r.interactions {
+		if r.used[i] || interaction.Request.Query != query {
+			continue
+		}
+		if variablesEqual(interaction.Request.Variables, variables) {
+			return i
+		}
+		if queryMatch < 0 {
+			queryMatch = i
+		}
+	}
+	return queryMatch
+}
+
+// readRequest decodes the GraphQL payload of req without consuming its body
+func readRequest(req *http.Request) (RecordedRequest, []byte, error) {
+	recorded := RecordedRequest{}
+	if req.Body == nil {
+		return recorded, nil, nil
+	}
+	body, errRead := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if errRead != nil {
+		return recorded, nil, errRead
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if errUnmarshal := json.Unmarshal(body, &recorded); errUnmarshal != nil {
+		return recorded, body, fmt.Errorf("unable to parse GraphQL request: %w", errUnmarshal)
+	}
+	if recorded.Variables == nil {
+		recorded.Variables = make(map[string]interface{})
+	}
+	return recorded, body, nil
+}
+
+// redact returns a copy of value with the values of all keys in keys replaced
+func redact(value interface{}, keys []string) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(typed))
+		for key, child := range typed {
+			if isRedactedKey(key, keys) && child != nil && child != "" {
+				redacted[key] = Redacted
+			} else {
+				redacted[key] = redact(child, keys)
+			}
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(typed))
+		for i, child := range typed {
+			redacted[i] = redact(child, keys)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+func isRedactedKey(key string, keys []string) bool {
+	for _, redactedKey := range keys {
+		if strings.EqualFold(key, redactedKey) {
+			return true
+		}
+	}
+	return false
+}
+
+// variablesEqual compares variables after normalizing them through JSON, so numbers compare equally
+func variablesEqual(a, b map[string]interface{}) bool {
+	normalize := func(variables map[string]interface{}) interface{} {
+		var normalized interface{}
+		data, _ := json.Marshal(variables)
+		_ = json.Unmarshal(data, &normalized)
+		if m, ok := normalized.(map[string]interface{}); ok && len(m) == 0 {
+			return nil
+		}
+		return normalized
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+// summarizeQuery returns the root fields of a query for error messages
+func summarizeQuery(query string) string {
+	return strings.Join(rootFields(query), ", ")
+}
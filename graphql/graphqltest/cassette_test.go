@@ -0,0 +1,138 @@
+// Package graphqltest
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphqltest
+
+import (
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type CassetteTestSuite struct {
+	suite.Suite
+	cassettePath string
+	authToken    string
+}
+
+func TestCassetteTestSuite(t *testing.T) {
+	suite.Run(t, new(CassetteTestSuite))
+}
+
+// SetupTest records a short session against the mock server
+func (s *CassetteTestSuite) SetupTest() {
+	mock := NewServer()
+	mock.AddUser(graphql.User{Username: "wanda", DisplayName: "Wanda"}, "secret")
+	mock.AddRoom(graphql.Room{ChatRoomID: "c3d4", KajiDisplayName: "Wanda"})
+	mock.AddRoom(graphql.Room{ChatRoomID: "e5f6", KajiDisplayName: "Vision"})
+	server := httptest.NewServer(mock)
+	defer server.Close()
+
+	recorder := NewRecorder()
+	client := graphql.GetKajiwotoGraphQLClient(server.URL, graphql.WithMiddleware(recorder.Wrap))
+	loginResult, errLogin := client.DoLoginUserPW("wanda", "secret")
+	assert.Nil(s.T(), errLogin)
+	s.authToken = loginResult.Login.AuthToken
+	_, errRoom := client.GetRoom("c3d4", "", s.authToken)
+	assert.Nil(s.T(), errRoom)
+	_, errRoom = client.GetRoom("e5f6", "", s.authToken)
+	assert.Nil(s.T(), errRoom)
+	_, errRoom = client.GetRoom("unknown", "", s.authToken)
+	assert.ErrorIs(s.T(), errRoom, graphql.ErrNotFound)
+
+	s.cassettePath = filepath.Join(s.T().TempDir(), "session.json")
+	assert.Nil(s.T(), recorder.Cassette().Save(s.cassettePath))
+}
+
+func (s *CassetteTestSuite) helperReplayClient(mode ReplayMode) (*graphql.KajiwotoGraphQLClient, *Replayer) {
+	cassette, errLoad := LoadCassette(s.cassettePath)
+	if !assert.Nil(s.T(), errLoad) {
+		s.T().FailNow()
+	}
+	replayer := NewReplayer(cassette, mode)
+	return graphql.GetKajiwotoGraphQLClient("http://replay.invalid", graphql.WithBaseTransport(replayer)), replayer
+}
+
+func (s *CassetteTestSuite) TestRedaction() {
+	data, errRead := os.ReadFile(s.cassettePath)
+	assert.Nil(s.T(), errRead)
+	assert.NotContains(s.T(), string(data), "secret")
+	assert.NotContains(s.T(), string(data), s.authToken)
+	assert.Contains(s.T(), string(data), Redacted)
+
+	cassette, errLoad := LoadCassette(s.cassettePath)
+	assert.Nil(s.T(), errLoad)
+	assert.Len(s.T(), cassette.Interactions, 4)
+	assert.Equal(s.T(), Redacted, cassette.Interactions[0].Request.Variables["password"])
+	assert.Equal(s.T(), "wanda", cassette.Interactions[0].Request.Variables["usernameOrEmail"])
+}
+
+func (s *CassetteTestSuite) TestReplayInOrder() {
+	client, replayer := s.helperReplayClient(ReplayInOrder)
+
+	// Credentials don't have to match, since they are redacted on both sides
+	loginResult, errLogin := client.DoLoginUserPW("wanda", "other secret")
+	assert.Nil(s.T(), errLogin)
+	assert.Equal(s.T(), Redacted, loginResult.Login.AuthToken)
+	assert.Equal(s.T(), gql.String("Wanda"), loginResult.Login.User.DisplayName)
+
+	room, errRoom := client.GetRoom("c3d4", "", loginResult.Login.AuthToken)
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), gql.String("Wanda"), room.KajiDisplayName)
+
+	// Out of order requests are rejected
+	_, errRoom = client.GetRoom("unknown", "", loginResult.Login.AuthToken)
+	assert.ErrorIs(s.T(), errRoom, ErrInteractionNotFound)
+	assert.ErrorIs(s.T(), errRoom, graphql.ErrTransport)
+
+	room, errRoom = client.GetRoom("e5f6", "", loginResult.Login.AuthToken)
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), gql.String("Vision"), room.KajiDisplayName)
+
+	// Recorded errors are replayed as well
+	_, errRoom = client.GetRoom("unknown", "", loginResult.Login.AuthToken)
+	assert.ErrorIs(s.T(), errRoom, graphql.ErrNotFound)
+	assert.Zero(s.T(), replayer.Remaining())
+
+	_, errRoom = client.GetRoom("c3d4", "", loginResult.Login.AuthToken)
+	assert.ErrorIs(s.T(), errRoom, ErrInteractionNotFound)
+}
+
+func (s *CassetteTestSuite) TestReplayMatchQuery() {
+	client, replayer := s.helperReplayClient(ReplayMatchQuery)
+
+	room, errRoom := client.GetRoom("e5f6", "", "token")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), gql.String("Vision"), room.KajiDisplayName)
+	room, errRoom = client.GetRoom("c3d4", "", "token")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), gql.String("Wanda"), room.KajiDisplayName)
+
+	// Unknown variables fall back to the next interaction with the same query
+	_, errRoom = client.GetRoom("other", "", "token")
+	assert.ErrorIs(s.T(), errRoom, graphql.ErrNotFound)
+	assert.Equal(s.T(), 1, replayer.Remaining())
+
+	_, errRoom = client.GetRoom("c3d4", "", "token")
+	assert.True(s.T(), strings.Contains(errRoom.Error(), "room"))
+	assert.ErrorIs(s.T(), errRoom, ErrInteractionNotFound)
+}
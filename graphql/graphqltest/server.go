@@ -0,0 +1,777 @@
+// Package graphqltest
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphqltest
+
+import (
+	"encoding/json"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	headerAuthToken = "auth_token"
+)
+
+// Server is an in-memory fake of the Kajiwoto GraphQL backend, to be used with httptest:
+//
+//	mock := graphqltest.NewServer()
+//	mock.AddUser(graphql.User{ID: "u1", Username: "user"}, "pw")
+//	server := httptest.NewServer(mock)
+//	defer server.Close()
+//	client := graphql.GetKajiwotoGraphQLClient(server.URL)
+//
+// It answers the operations used by KajiwotoGraphQLClient with data which was added via its Add* methods.
+// Operations other than login and loginWithToken require a valid auth token, as issued by login.
+type Server struct {
+	users         map[string]*mockUser // By user ID
+	authTokens    map[string]string    // User ID by auth token
+	trainerGroups map[string]*graphql.AITrainerGroup
+	datasetLines  map[string][]graphql.DatasetLine // By AI trainer group ID
+	rooms         map[string]*graphql.Room         // By chat room ID
+	chatMessages  map[string][]graphql.ChatMessage // By chat room ID
+	operations    []string
+	idCounter     int
+	stateMtx      sync.Mutex
+}
+
+type mockUser struct {
+	user     graphql.User
+	password string
+	settings graphql.Settings
+}
+
+// request is a decoded GraphQL request
+type request struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+	authToken string
+	userID    string
+}
+
+// responseError is an entry of the errors array of a response
+type responseError struct {
+	Message string `json:"message"`
+}
+
+// resolver answers a single root field of an operation; stateMtx is held while it runs
+type resolver func(s *Server, req *request) (interface{}, error)
+
+// resolvers by root field name; authenticated resolvers require a valid auth token
+var (
+	publicResolvers = map[string]resolver{
+		"login":          (*Server).resolveLogin,
+		"loginWithToken": (*Server).resolveLoginWithToken,
+		"welcome":        (*Server).resolveWelcome,
+	}
+	authenticatedResolvers = map[string]resolver{
+		"aiTrainerGroup":       (*Server).resolveAITrainerGroup,
+		"createAITrainerGroup": (*Server).resolveCreateAITrainerGroup,
+		"updateAITrainerGroup": (*Server).resolveUpdateAITrainerGroup,
+		"datasetLines":         (*Server).resolveDatasetLines,
+		"addToDataset":         (*Server).resolveAddToDataset,
+		"createAIDocument":     (*Server).resolveCreateAIDocument,
+		"updateAIDocument":     (*Server).resolveUpdateAIDocument,
+		"reorderAIDocuments":   (*Server).resolveReorderAIDocuments,
+		"deleteAIDocument":     (*Server).resolveDeleteAIDocument,
+		"rebuildAIDocument":    (*Server).resolveRebuildAIDocument,
+		"room":                 (*Server).resolveRoom,
+		"roomHistory":          (*Server).resolveRoomHistory,
+	}
+)
+
+// NewServer creates an empty fake backend
+func NewServer() *Server {
+	return &Server{
+		users:         make(map[string]*mockUser),
+		authTokens:    make(map[string]string),
+		trainerGroups: make(map[string]*graphql.AITrainerGroup),
+		datasetLines:  make(map[string][]graphql.DatasetLine),
+		rooms:         make(map[string]*graphql.Room),
+		chatMessages:  make(map[string][]graphql.ChatMessage),
+	}
+}
+
+// AddUser adds an account which can log in with its username or email address and password.
+// An empty user ID is generated.
+func (s *Server) AddUser(user graphql.User, password string) graphql.User {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	if user.ID == "" {
+		user.ID = gql.String(s.nextID("user"))
+	}
+	s.users[string(user.ID)] = &mockUser{user: user, password: password}
+	return user
+}
+
+// SetSettings replaces the settings returned by login and loginWithToken for the given user
+func (s *Server) SetSettings(userID string, settings graphql.Settings) error {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	user, ok := s.users[userID]
+	if !ok {
+		return fmt.Errorf("unknown user %v", userID)
+	}
+	user.settings = settings
+	return nil
+}
+
+// IssueAuthToken returns a new valid auth token for the given user, as if the user logged in
+func (s *Server) IssueAuthToken(userID string) string {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	return s.issueAuthToken(userID)
+}
+
+// RevokeAuthTokens invalidates all issued auth tokens, as if they expired
+func (s *Server) RevokeAuthTokens() {
+	s.stateMtx.Lock()
+	s.authTokens = make(map[string]string)
+	s.stateMtx.Unlock()
+}
+
+// AddAITrainerGroup adds an AI trainer group; an empty ID is generated
+func (s *Server) AddAITrainerGroup(trainerGroup graphql.AITrainerGroup) graphql.AITrainerGroup {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	if trainerGroup.ID == "" {
+		trainerGroup.ID = gql.String(s.nextID("group"))
+	}
+	s.trainerGroups[string(trainerGroup.ID)] = &trainerGroup
+	s.updateCount(string(trainerGroup.ID))
+	return trainerGroup
+}
+
+// AddDatasetLines adds lines to the dataset of an AI trainer group; empty IDs are generated
+func (s *Server) AddDatasetLines(aiTrainerGroupID string, lines ...graphql.DatasetLine) []graphql.DatasetLine {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	added := make([]graphql.DatasetLine, 0, len(lines))
+	for _, line := range lines {
+		added = append(added, s.addDatasetLine(aiTrainerGroupID, line))
+	}
+	s.updateCount(aiTrainerGroupID)
+	return added
+}
+
+// DatasetLines returns all dataset lines of an AI trainer group, including deleted ones
+func (s *Server) DatasetLines(aiTrainerGroupID string) []graphql.DatasetLine {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	return append([]graphql.DatasetLine{}, s.datasetLines[aiTrainerGroupID]...)
+}
+
+// SetAIDocumentQueueStatus changes the queue status of a document, e.g. to simulate a finished or failed build
+func (s *Server) SetAIDocumentQueueStatus(aiTrainerGroupID, aiDocumentID, status string) error {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	document, errDocument := s.findAIDocument(aiTrainerGroupID, aiDocumentID)
+	if errDocument != nil {
+		return errDocument
+	}
+	document.QueueStatus = gql.String(status)
+	if status == graphql.AIDocumentStatusBuilt {
+		document.BuiltAt = now()
+	}
+	return nil
+}
+
+// AddRoom adds a room; it is looked up by its ChatRoomID, which is generated if empty
+func (s *Server) AddRoom(room graphql.Room) graphql.Room {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	if room.ID == "" {
+		room.ID = gql.String(s.nextID("room"))
+	}
+	if room.ChatRoomID == "" {
+		room.ChatRoomID = gql.String(s.nextID("chatroom"))
+	}
+	s.rooms[string(room.ChatRoomID)] = &room
+	return room
+}
+
+// AddChatMessages appends messages to the history of a chat room; empty IDs are generated
+func (s *Server) AddChatMessages(chatRoomID string, messages ...graphql.ChatMessage) {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	for _, message := range messages {
+		if message.ID == "" {
+			message.ID = gql.String(s.nextID("message"))
+		}
+		message.ChatRoomID = gql.String(chatRoomID)
+		s.chatMessages[chatRoomID] = append(s.chatMessages[chatRoomID], message)
+	}
+}
+
+// Operations returns the root fields of all requests received so far, e.g. "login" or "room"
+func (s *Server) Operations() []string {
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	return append([]string{}, s.operations...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req := &request{}
+	if errDecode := json.NewDecoder(r.Body).Decode(req); errDecode != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", errDecode), http.StatusBadRequest)
+		return
+	}
+	req.authToken = r.Header.Get(headerAuthToken)
+
+	data, errs := s.execute(req)
+	response := map[string]interface{}{
+		"data": data,
+	}
+	if len(errs) > 0 {
+		response["errors"] = errs
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// execute resolves all root fields of the request
+func (s *Server) execute(req *request) (map[string]interface{}, []responseError) {
+	fields := rootFields(req.Query)
+	if len(fields) == 0 {
+		return nil, []responseError{{Message: "Syntax Error: query contains no fields"}}
+	}
+
+	s.stateMtx.Lock()
+	defer s.stateMtx.Unlock()
+	s.operations = append(s.operations, fields...)
+
+	data := make(map[string]interface{}, len(fields))
+	errs := make([]responseError, 0)
+	for _, field := range fields {
+		resolve, public := publicResolvers[field]
+		if !public {
+			var ok bool
+			if resolve, ok = authenticatedResolvers[field]; !ok {
+				errs = append(errs, responseError{Message: fmt.Sprintf("Cannot query field %q on type \"Query\"", field)})
+				continue
+			}
+			userID, validToken := s.authTokens[req.authToken]
+			if !validToken {
+				errs = append(errs, responseError{Message: "Unauthorized"})
+				continue
+			}
+			req.userID = userID
+		}
+		result, errResolve := resolve(s, req)
+		if errResolve != nil {
+			errs = append(errs, responseError{Message: errResolve.Error()})
+			continue
+		}
+		data[field] = result
+	}
+	if len(errs) > 0 && len(data) == 0 {
+		return nil, errs
+	}
+	return data, errs
+}
+
+func (s *Server) resolveLogin(req *request) (interface{}, error) {
+	usernameOrEmail := req.stringVar("usernameOrEmail")
+	password := req.stringVar("password")
+	for userID, user := range s.users {
+		if (strings.EqualFold(string(user.user.Username), usernameOrEmail) || strings.EqualFold(string(user.user.Email.Address), usernameOrEmail)) &&
+			user.password == password {
+			return graphql.Login{
+				AuthToken: s.issueAuthToken(userID),
+				User:      user.user,
+				Settings:  user.settings,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid username or password")
+}
+
+func (s *Server) resolveLoginWithToken(req *request) (interface{}, error) {
+	authToken := req.stringVar("authToken")
+	userID, ok := s.authTokens[authToken]
+	if !ok {
+		// The backend answers outdated tokens with an empty login
+		return graphql.Login{}, nil
+	}
+	return graphql.Login{
+		AuthToken: authToken,
+		User:      s.users[userID].user,
+		Settings:  s.users[userID].settings,
+	}, nil
+}
+
+func (s *Server) resolveWelcome(req *request) (interface{}, error) {
+	return graphql.Welcome{WebVersion: "graphqltest"}, nil
+}
+
+func (s *Server) resolveAITrainerGroup(req *request) (interface{}, error) {
+	trainerGroup, ok := s.trainerGroups[req.stringVar("aiTrainerGroupId")]
+	if !ok {
+		return nil, fmt.Errorf("AI trainer group not found")
+	}
+	// The response is encoded after stateMtx is released, so it must not share state
+	result := *trainerGroup
+	result.Documents = append([]graphql.AIDocument{}, trainerGroup.Documents...)
+	return result, nil
+}
+
+func (s *Server) resolveCreateAITrainerGroup(req *request) (interface{}, error) {
+	input := graphql.AITrainerGroupInput{}
+	if errDecode := req.decodeVar("input", &input); errDecode != nil {
+		return nil, fmt.Errorf("Variable \"$input\" got invalid value: %v", errDecode)
+	}
+	if input.Name == nil || strings.TrimSpace(*input.Name) == "" {
+		return nil, fmt.Errorf("Invalid AI trainer group: name is required")
+	}
+
+	trainerGroup := &graphql.AITrainerGroup{
+		ID:            gql.String(s.nextID("group")),
+		Documents:     make([]graphql.AIDocument, 0),
+		Personalities: make([][]gql.String, 0),
+		Status:        graphql.AITrainerGroupStatusDraft,
+		Tags:          make([]gql.String, 0),
+		User:          s.users[req.userID].user,
+	}
+	if errApply := applyAITrainerGroupInput(trainerGroup, input); errApply != nil {
+		return nil, errApply
+	}
+	s.trainerGroups[string(trainerGroup.ID)] = trainerGroup
+	return *trainerGroup, nil
+}
+
+func (s *Server) resolveUpdateAITrainerGroup(req *request) (interface{}, error) {
+	trainerGroup, errGroup := s.ownAITrainerGroup(req)
+	if errGroup != nil {
+		return nil, errGroup
+	}
+	input := graphql.AITrainerGroupInput{}
+	if errDecode := req.decodeVar("input", &input); errDecode != nil {
+		return nil, fmt.Errorf("Variable \"$input\" got invalid value: %v", errDecode)
+	}
+	// Validate on a copy, so invalid input doesn't leave the group half changed
+	updated := *trainerGroup
+	if errApply := applyAITrainerGroupInput(&updated, input); errApply != nil {
+		return nil, errApply
+	}
+	*trainerGroup = updated
+	return updated, nil
+}
+
+func (s *Server) resolveDatasetLines(req *request) (interface{}, error) {
+	aiTrainerGroupID := req.stringVar("aiTrainerGroupId")
+	if _, ok := s.trainerGroups[aiTrainerGroupID]; !ok {
+		return nil, fmt.Errorf("AI trainer group not found")
+	}
+	limit, offset := req.intVar("limit"), req.intVar("offset")
+	if limit < 1 || limit > graphql.DatasetLinesMaxPageSize || offset < 0 {
+		return nil, fmt.Errorf("Invalid limit or offset")
+	}
+
+	searchQuery := strings.ToLower(req.stringVar("searchQuery"))
+	matches := make([]graphql.DatasetLine, 0)
+	for _, line := range s.datasetLines[aiTrainerGroupID] {
+		if line.Deleted {
+			continue
+		}
+		if searchQuery == "" ||
+			strings.Contains(strings.ToLower(string(line.UserMessage)), searchQuery) ||
+			strings.Contains(strings.ToLower(string(line.Message)), searchQuery) {
+			matches = append(matches, line)
+		}
+	}
+	return page(matches, limit, offset), nil
+}
+
+func (s *Server) resolveAddToDataset(req *request) (interface{}, error) {
+	aiTrainerGroupID := req.stringVar("aiTrainerGroupId")
+	if _, ok := s.trainerGroups[aiTrainerGroupID]; !ok {
+		return nil, fmt.Errorf("AI trainer group not found")
+	}
+	dialogues := make([]graphql.AiDialogueInput, 0)
+	if errDecode := req.decodeVar("dialogues", &dialogues); errDecode != nil {
+		return nil, fmt.Errorf("Variable \"$dialogues\" got invalid value: %v", errDecode)
+	}
+
+	result := graphql.AIEditorResult{
+		Added:            make([]graphql.DatasetLine, 0, len(dialogues)),
+		AITrainerGroupID: gql.String(aiTrainerGroupID),
+		DeletedIDs:       make([]gql.String, 0),
+		Generated:        make([]graphql.DatasetLine, 0),
+	}
+	for _, dialogue := range dialogues {
+		if errValidate := validateDialogue(dialogue); errValidate != nil {
+			return nil, errValidate
+		}
+		line := lineFromDialogue(dialogue)
+		result.Added = append(result.Added, s.addDatasetLine(aiTrainerGroupID, line))
+	}
+	s.updateCount(aiTrainerGroupID)
+	result.Count = s.trainerGroups[aiTrainerGroupID].Count
+	return result, nil
+}
+
+func (s *Server) resolveCreateAIDocument(req *request) (interface{}, error) {
+	trainerGroup, ok := s.trainerGroups[req.stringVar("aiTrainerGroupId")]
+	if !ok {
+		return nil, fmt.Errorf("AI trainer group not found")
+	}
+	title := req.stringVar("title")
+	if strings.TrimSpace(title) == "" {
+		return nil, fmt.Errorf("Invalid document: title is required")
+	}
+
+	document := graphql.AIDocument{
+		ID:        gql.String(s.nextID("document")),
+		Order:     gql.Int(len(trainerGroup.Documents) + 1),
+		Title:     gql.String(title),
+		Content:   gql.String(req.stringVar("content")),
+		CreatedAt: now(),
+	}
+	queueBuild(&document)
+	trainerGroup.Documents = append(trainerGroup.Documents, document)
+	return document, nil
+}
+
+func (s *Server) resolveUpdateAIDocument(req *request) (interface{}, error) {
+	document, errDocument := s.findAIDocument(req.stringVar("aiTrainerGroupId"), req.stringVar("aiDocumentId"))
+	if errDocument != nil {
+		return nil, errDocument
+	}
+	title := req.stringVar("title")
+	if strings.TrimSpace(title) == "" {
+		return nil, fmt.Errorf("Invalid document: title is required")
+	}
+	document.Title = gql.String(title)
+	document.Content = gql.String(req.stringVar("content"))
+	queueBuild(document)
+	return *document, nil
+}
+
+func (s *Server) resolveReorderAIDocuments(req *request) (interface{}, error) {
+	trainerGroup, ok := s.trainerGroups[req.stringVar("aiTrainerGroupId")]
+	if !ok {
+		return nil, fmt.Errorf("AI trainer group not found")
+	}
+	aiDocumentIDs := make([]string, 0)
+	if errDecode := req.decodeVar("aiDocumentIds", &aiDocumentIDs); errDecode != nil {
+		return nil, fmt.Errorf("Variable \"$aiDocumentIds\" got invalid value: %v", errDecode)
+	}
+	if len(aiDocumentIDs) != len(trainerGroup.Documents) {
+		return nil, fmt.Errorf("Invalid document order: expected %d document IDs, got %d", len(trainerGroup.Documents), len(aiDocumentIDs))
+	}
+
+	reordered := make([]graphql.AIDocument, 0, len(aiDocumentIDs))
+	for i, aiDocumentID := range aiDocumentIDs {
+		document, errDocument := s.findAIDocument(string(trainerGroup.ID), aiDocumentID)
+		if errDocument != nil {
+			return nil, errDocument
+		}
+		for _, previous := range reordered {
+			if previous.ID == document.ID {
+				return nil, fmt.Errorf("Invalid document order: duplicate document ID %v", aiDocumentID)
+			}
+		}
+		reorderedDocument := *document
+		reorderedDocument.Order = gql.Int(i + 1)
+		reordered = append(reordered, reorderedDocument)
+	}
+	trainerGroup.Documents = reordered
+	return append([]graphql.AIDocument{}, reordered...), nil
+}
+
+func (s *Server) resolveDeleteAIDocument(req *request) (interface{}, error) {
+	aiTrainerGroupID, aiDocumentID := req.stringVar("aiTrainerGroupId"), req.stringVar("aiDocumentId")
+	if _, errDocument := s.findAIDocument(aiTrainerGroupID, aiDocumentID); errDocument != nil {
+		return nil, errDocument
+	}
+	trainerGroup := s.trainerGroups[aiTrainerGroupID]
+	remaining := make([]graphql.AIDocument, 0, len(trainerGroup.Documents))
+	for _, document := range trainerGroup.Documents {
+		if string(document.ID) != aiDocumentID {
+			document.Order = gql.Int(len(remaining) + 1)
+			remaining = append(remaining, document)
+		}
+	}
+	trainerGroup.Documents = remaining
+	return append([]graphql.AIDocument{}, remaining...), nil
+}
+
+func (s *Server) resolveRebuildAIDocument(req *request) (interface{}, error) {
+	document, errDocument := s.findAIDocument(req.stringVar("aiTrainerGroupId"), req.stringVar("aiDocumentId"))
+	if errDocument != nil {
+		return nil, errDocument
+	}
+	queueBuild(document)
+	return *document, nil
+}
+
+func (s *Server) resolveRoom(req *request) (interface{}, error) {
+	room, ok := s.rooms[req.stringVar("chatRoomId")]
+	if !ok {
+		return nil, fmt.Errorf("Room not found")
+	}
+	return room, nil
+}
+
+func (s *Server) resolveRoomHistory(req *request) (interface{}, error) {
+	chatRoomID := req.stringVar("chatRoomId")
+	room, ok := s.rooms[chatRoomID]
+	if !ok {
+		return nil, fmt.Errorf("Room not found")
+	}
+	return graphql.RoomHistory{
+		ID:         room.ID,
+		ChatRoomID: room.ChatRoomID,
+		KajiID:     room.KajiID,
+		Messages:   append([]graphql.ChatMessage{}, s.chatMessages[chatRoomID]...),
+	}, nil
+}
+
+// ownAITrainerGroup looks up the trainer group of the request, which must not belong to another user; stateMtx must be held
+func (s *Server) ownAITrainerGroup(req *request) (*graphql.AITrainerGroup, error) {
+	trainerGroup, ok := s.trainerGroups[req.stringVar("aiTrainerGroupId")]
+	if !ok {
+		return nil, fmt.Errorf("AI trainer group not found")
+	}
+	if trainerGroup.User.ID != "" && string(trainerGroup.User.ID) != req.userID {
+		return nil, fmt.Errorf("Forbidden: AI trainer group belongs to another user")
+	}
+	return trainerGroup, nil
+}
+
+// findAIDocument looks up a document of a trainer group; stateMtx must be held
+func (s *Server) findAIDocument(aiTrainerGroupID, aiDocumentID string) (*graphql.AIDocument, error) {
+	trainerGroup, ok := s.trainerGroups[aiTrainerGroupID]
+	if !ok {
+		return nil, fmt.Errorf("AI trainer group not found")
+	}
+	for i := range trainerGroup.Documents {
+		if string(trainerGroup.Documents[i].ID) == aiDocumentID {
+			return &trainerGroup.Documents[i], nil
+		}
+	}
+	return nil, fmt.Errorf("AI document not found")
+}
+
+// issueAuthToken creates a new auth token; stateMtx must be held
+func (s *Server) issueAuthToken(userID string) string {
+	authToken := s.nextID("token")
+	s.authTokens[authToken] = userID
+	return authToken
+}
+
+// addDatasetLine stores a line; stateMtx must be held
+func (s *Server) addDatasetLine(aiTrainerGroupID string, line graphql.DatasetLine) graphql.DatasetLine {
+	if line.ID == "" {
+		line.ID = gql.String(s.nextID("line"))
+	}
+	if line.History == nil {
+		line.History = make([]gql.String, 0)
+	}
+	line.AITrainerGroupID = gql.String(aiTrainerGroupID)
+	s.datasetLines[aiTrainerGroupID] = append(s.datasetLines[aiTrainerGroupID], line)
+	return line
+}
+
+// updateCount refreshes the line count of a trainer group; stateMtx must be held
+func (s *Server) updateCount(aiTrainerGroupID string) {
+	trainerGroup, ok := s.trainerGroups[aiTrainerGroupID]
+	if !ok {
+		return
+	}
+	count := 0
+	for _, line := range s.datasetLines[aiTrainerGroupID] {
+		if !line.Deleted {
+			count++
+		}
+	}
+	trainerGroup.Count = gql.Int(count)
+}
+
+// nextID generates a unique ID; stateMtx must be held
+func (s *Server) nextID(prefix string) string {
+	s.idCounter++
+	return fmt.Sprintf("%v-%d", prefix, s.idCounter)
+}
+
+// applyAITrainerGroupInput sets all non-nil fields of input
+func applyAITrainerGroupInput(trainerGroup *graphql.AITrainerGroup, input graphql.AITrainerGroupInput) error {
+	if input.Name != nil {
+		if strings.TrimSpace(*input.Name) == "" {
+			return fmt.Errorf("Invalid AI trainer group: name must not be empty")
+		}
+		trainerGroup.Name = gql.String(*input.Name)
+	}
+	if input.Description != nil {
+		trainerGroup.Description = gql.String(*input.Description)
+	}
+	if input.Tags != nil {
+		trainerGroup.Tags = make([]gql.String, 0, len(input.Tags))
+		for _, tag := range input.Tags {
+			trainerGroup.Tags = append(trainerGroup.Tags, gql.String(tag))
+		}
+	}
+	if input.NSFW != nil {
+		trainerGroup.NSFW = gql.Boolean(*input.NSFW)
+	}
+	if input.Personalities != nil {
+		trainerGroup.Personalities = make([][]gql.String, 0, len(input.Personalities))
+		for _, personality := range input.Personalities {
+			traits := make([]gql.String, 0, len(personality))
+			for _, trait := range personality {
+				traits = append(traits, gql.String(trait))
+			}
+			trainerGroup.Personalities = append(trainerGroup.Personalities, traits)
+		}
+	}
+	if input.Price != nil {
+		if *input.Price < 0 {
+			return fmt.Errorf("Invalid AI trainer group: price must not be negative")
+		}
+		trainerGroup.Price = gql.Int(*input.Price)
+	}
+	if input.ProfilePhotoUri != nil {
+		trainerGroup.ProfilePhotoUri = gql.String(*input.ProfilePhotoUri)
+	}
+	trainerGroup.UpdatedAt = now()
+	return nil
+}
+
+// page returns the items of a single page, never nil
+func page[T any](items []T, limit, offset int) []T {
+	result := make([]T, 0)
+	for i := offset; i < len(items) && i < offset+limit; i++ {
+		result = append(result, items[i])
+	}
+	return result
+}
+
+// queueBuild marks a document as queued for building, as the backend does after every change
+func queueBuild(document *graphql.AIDocument) {
+	document.QueueStatus = graphql.AIDocumentStatusQueued
+	document.QueuedAt = now()
+	document.UpdatedAt = document.QueuedAt
+}
+
+// now returns the current time in milliseconds, as used by the timestamps of the backend
+func now() uint64 {
+	return uint64(time.Now().UnixMilli())
+}
+
+func validateDialogue(dialogue graphql.AiDialogueInput) error {
+	if strings.TrimSpace(string(dialogue.UserMessage)) == "" || strings.TrimSpace(string(dialogue.Message)) == "" {
+		return fmt.Errorf("Invalid dialogue: user message and message are required")
+	}
+	return nil
+}
+
+// lineFromDialogue converts a dialogue input into a dataset line without ID
+func lineFromDialogue(dialogue graphql.AiDialogueInput) graphql.DatasetLine {
+	line := graphql.DatasetLine{
+		UserMessage: dialogue.UserMessage,
+		Message:     dialogue.Message,
+		History:     dialogue.History,
+	}
+	if dialogue.Conditions.ASM != nil {
+		line.ASM = gql.String(*dialogue.Conditions.ASM)
+	}
+	if dialogue.Conditions.Endearment != nil {
+		line.Endearment = gql.String(*dialogue.Conditions.Endearment)
+	}
+	if dialogue.Conditions.Recent != nil {
+		line.Recent = gql.String(*dialogue.Conditions.Recent)
+	}
+	if dialogue.Conditions.Time != nil {
+		line.Time = gql.String(*dialogue.Conditions.Time)
+	}
+	if line.History == nil {
+		line.History = make([]gql.String, 0)
+	}
+	return line
+}
+
+func (r *request) stringVar(name string) string {
+	value, _ := r.Variables[name].(string)
+	return value
+}
+
+func (r *request) intVar(name string) int {
+	value, _ := r.Variables[name].(float64)
+	return int(value)
+}
+
+// decodeVar converts a variable into target by re-encoding it as JSON
+func (r *request) decodeVar(name string, target interface{}) error {
+	data, errMarshal := json.Marshal(r.Variables[name])
+	if errMarshal != nil {
+		return errMarshal
+	}
+	return json.Unmarshal(data, target)
+}
+
+// rootFields extracts the names of the top level fields of a query or mutation, e.g. "login" and "welcome" for
+// `mutation ($password:String!){login(password: $password){authToken},welcome{webVersion}}`
+func rootFields(query string) []string {
+	fields := make([]string, 0)
+	start := strings.Index(query, "{")
+	if start < 0 {
+		return fields
+	}
+	braces, parens := 0, 0
+	name := strings.Builder{}
+	flush := func() {
+		if name.Len() > 0 {
+			fields = append(fields, name.String())
+			name.Reset()
+		}
+	}
+	for _, c := range query[start:] {
+		switch {
+		case c == '(':
+			flush()
+			parens++
+		case c == ')':
+			parens--
+		case parens > 0:
+			// Skip arguments
+		case c == '{':
+			flush()
+			braces++
+		case c == '}':
+			flush()
+			braces--
+		case braces != 1:
+			// Skip nested selections
+		case c == ':':
+			// Alias; the field name follows
+			name.Reset()
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9':
+			name.WriteRune(c)
+		default:
+			flush()
+		}
+	}
+	return fields
+}
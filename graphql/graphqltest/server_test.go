@@ -0,0 +1,333 @@
+// Package graphqltest
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphqltest
+
+import (
+	"context"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/runtimeracer/kajiwoto-clientsdk-golang/graphql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type ServerTestSuite struct {
+	suite.Suite
+	mock   *Server
+	server *httptest.Server
+	client *graphql.KajiwotoGraphQLClient
+	user   graphql.User
+	group  graphql.AITrainerGroup
+	room   graphql.Room
+}
+
+func TestServerTestSuite(t *testing.T) {
+	suite.Run(t, new(ServerTestSuite))
+}
+
+func (s *ServerTestSuite) SetupTest() {
+	s.mock = NewServer()
+	s.user = s.mock.AddUser(graphql.User{Username: "wanda", DisplayName: "Wanda", Email: graphql.Email{Address: "wanda@example.com"}}, "secret")
+	s.group = s.mock.AddAITrainerGroup(graphql.AITrainerGroup{Name: "Wanda's dataset"})
+	for i := 0; i < 150; i++ {
+		s.mock.AddDatasetLines(string(s.group.ID), graphql.DatasetLine{
+			UserMessage: gql.String(fmt.Sprintf("question %d", i)),
+			Message:     gql.String(fmt.Sprintf("answer %d", i)),
+		})
+	}
+	s.room = s.mock.AddRoom(graphql.Room{ChatRoomID: "c3d4", KajiDisplayName: "Wanda"})
+	s.mock.AddChatMessages("c3d4", graphql.ChatMessage{Message: "hi"}, graphql.ChatMessage{Message: "hey there"})
+
+	s.server = httptest.NewServer(s.mock)
+	s.client = graphql.GetKajiwotoGraphQLClient(s.server.URL)
+}
+
+func (s *ServerTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *ServerTestSuite) helperLogin() string {
+	loginResult, errLogin := s.client.DoLoginUserPW("wanda", "secret")
+	if !assert.Nil(s.T(), errLogin) {
+		s.T().FailNow()
+	}
+	return loginResult.Login.AuthToken
+}
+
+func (s *ServerTestSuite) TestLogin() {
+	loginResult, errLogin := s.client.DoLoginUserPW("wanda@example.com", "secret")
+	assert.Nil(s.T(), errLogin)
+	assert.NotEmpty(s.T(), loginResult.Login.AuthToken)
+	assert.Equal(s.T(), gql.String("Wanda"), loginResult.Login.User.DisplayName)
+
+	_, errLogin = s.client.DoLoginUserPW("wanda", "wrong")
+	assert.ErrorIs(s.T(), errLogin, graphql.ErrUnauthorized)
+}
+
+func (s *ServerTestSuite) TestLoginWithToken() {
+	authToken := s.helperLogin()
+	loginResult, errLogin := s.client.DoLoginAuthToken(authToken)
+	assert.Nil(s.T(), errLogin)
+	assert.Equal(s.T(), authToken, loginResult.Login.AuthToken)
+	assert.Equal(s.T(), s.user.ID, loginResult.Login.User.ID)
+
+	// Outdated tokens result in an empty login
+	s.mock.RevokeAuthTokens()
+	loginResult, errLogin = s.client.DoLoginAuthToken(authToken)
+	assert.Nil(s.T(), errLogin)
+	assert.Empty(s.T(), loginResult.Login.AuthToken)
+}
+
+func (s *ServerTestSuite) TestGetRoom() {
+	authToken := s.helperLogin()
+	room, errRoom := s.client.GetRoom("c3d4", "", authToken)
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), s.room.ID, room.ID)
+	assert.Equal(s.T(), gql.String("Wanda"), room.KajiDisplayName)
+
+	_, errRoom = s.client.GetRoom("unknown", "", authToken)
+	assert.ErrorIs(s.T(), errRoom, graphql.ErrNotFound)
+
+	_, errRoom = s.client.GetRoom("c3d4", "", "invalid")
+	assert.ErrorIs(s.T(), errRoom, graphql.ErrUnauthorized)
+}
+
+func (s *ServerTestSuite) TestGetRooms() {
+	settings := graphql.Settings{}
+	for i := 0; i < 3; i++ {
+		room := s.mock.AddRoom(graphql.Room{KajiDisplayName: gql.String(fmt.Sprintf("Kaji %d", i)), OwnerID: s.user.ID})
+		settings.PersonalRoomOrder = append(settings.PersonalRoomOrder, room.ChatRoomID)
+	}
+	assert.Nil(s.T(), s.mock.SetSettings(string(s.user.ID), settings))
+	authToken := s.helperLogin()
+
+	rooms, errRooms := s.client.GetRooms(authToken, 2, 0)
+	assert.Nil(s.T(), errRooms)
+	assert.Len(s.T(), rooms, 2)
+	assert.Equal(s.T(), gql.String("Kaji 0"), rooms[0].KajiDisplayName)
+	rooms, errRooms = s.client.GetRooms(authToken, 2, 2)
+	assert.Nil(s.T(), errRooms)
+	assert.Len(s.T(), rooms, 1)
+	assert.Equal(s.T(), gql.String("Kaji 2"), rooms[0].KajiDisplayName)
+
+	_, errRooms = s.client.GetRooms(authToken, graphql.ListMaxPageSize+1, 0)
+	assert.NotNil(s.T(), errRooms)
+	_, errRooms = s.client.GetRooms("outdated", 2, 0)
+	assert.ErrorIs(s.T(), errRooms, graphql.ErrUnauthorized)
+
+	session := graphql.NewSession(s.client, "wanda", "secret")
+	rooms, errRooms = session.GetRooms(context.Background(), graphql.ListMaxPageSize, 0)
+	assert.Nil(s.T(), errRooms)
+	assert.Len(s.T(), rooms, 3)
+	rooms, errRooms = session.GetRooms(context.Background(), graphql.ListMaxPageSize, 3)
+	assert.Nil(s.T(), errRooms)
+	assert.Empty(s.T(), rooms)
+}
+
+func (s *ServerTestSuite) TestGetRoomHistory() {
+	roomHistory, errHistory := s.client.GetRoomHistory("c3d4", "", s.helperLogin())
+	assert.Nil(s.T(), errHistory)
+	assert.Equal(s.T(), gql.String("c3d4"), roomHistory.ChatRoomID)
+	assert.Len(s.T(), roomHistory.Messages, 2)
+	assert.Equal(s.T(), gql.String("hey there"), roomHistory.Messages[1].Message)
+}
+
+func (s *ServerTestSuite) TestDataset() {
+	authToken := s.helperLogin()
+	trainerGroup, errGroup := s.client.GetAITrainerGroup(string(s.group.ID), authToken)
+	assert.Nil(s.T(), errGroup)
+	assert.Equal(s.T(), gql.String("Wanda's dataset"), trainerGroup.Name)
+	assert.Equal(s.T(), gql.Int(150), trainerGroup.Count)
+
+	lines, errLines := s.client.IterateDatasetLines(string(s.group.ID), "", authToken, 0).Collect(context.Background())
+	assert.Nil(s.T(), errLines)
+	assert.Len(s.T(), lines, 150)
+
+	lines, errLines = s.client.GetDatasetLines(string(s.group.ID), "question 149", authToken, 100, 0)
+	assert.Nil(s.T(), errLines)
+	assert.Len(s.T(), lines, 1)
+
+	time := "NIGHT"
+	result, errAdd := s.client.AddToDataset(string(s.group.ID), authToken, []*graphql.AiDialogueInput{
+		{UserMessage: "good night", Message: "sweet dreams", History: []gql.String{"hi"}, Conditions: graphql.AITrainingCondition{Time: &time}},
+	})
+	assert.Nil(s.T(), errAdd)
+	assert.Len(s.T(), result.Added, 1)
+	assert.Equal(s.T(), gql.Int(151), result.Count)
+	stored := s.mock.DatasetLines(string(s.group.ID))
+	assert.Equal(s.T(), result.Added[0], stored[150])
+	assert.Equal(s.T(), gql.String("NIGHT"), stored[150].Time)
+
+	_, errAdd = s.client.AddToDataset(string(s.group.ID), authToken, []*graphql.AiDialogueInput{{UserMessage: "", Message: "nobody asked"}})
+	assert.ErrorIs(s.T(), errAdd, graphql.ErrValidation)
+}
+
+func (s *ServerTestSuite) TestAITrainerGroupMutations() {
+	authToken := s.helperLogin()
+	name, description, nsfw, price := "Wanda", "canine musketeer", false, 100
+
+	created, errCreate := s.client.CreateAITrainerGroup(authToken, &graphql.AITrainerGroupInput{
+		Name:          &name,
+		Description:   &description,
+		Tags:          []string{"fantasy"},
+		NSFW:          &nsfw,
+		Personalities: [][]string{{"loyal", "brave"}},
+		Price:         &price,
+	})
+	assert.Nil(s.T(), errCreate)
+	assert.NotEmpty(s.T(), created.ID)
+	assert.Equal(s.T(), gql.String("Wanda"), created.Name)
+	assert.Equal(s.T(), []gql.String{"fantasy"}, created.Tags)
+	assert.Equal(s.T(), [][]gql.String{{"loyal", "brave"}}, created.Personalities)
+	assert.Equal(s.T(), gql.Int(100), created.Price)
+	assert.Equal(s.T(), gql.String(graphql.AITrainerGroupStatusDraft), created.Status)
+	assert.Equal(s.T(), s.user.ID, created.User.ID)
+
+	empty := " "
+	_, errCreate = s.client.CreateAITrainerGroup(authToken, &graphql.AITrainerGroupInput{Name: &empty})
+	assert.ErrorIs(s.T(), errCreate, graphql.ErrValidation)
+
+	// Only given fields are changed
+	photo := "https://example.com/wanda.png"
+	updated, errUpdate := s.client.UpdateAITrainerGroup(string(created.ID), authToken, &graphql.AITrainerGroupInput{ProfilePhotoUri: &photo, Tags: []string{}})
+	assert.Nil(s.T(), errUpdate)
+	assert.Equal(s.T(), gql.String(photo), updated.ProfilePhotoUri)
+	assert.Empty(s.T(), updated.Tags)
+	assert.Equal(s.T(), gql.String("canine musketeer"), updated.Description)
+	assert.Equal(s.T(), gql.Int(100), updated.Price)
+
+	negative := -1
+	_, errUpdate = s.client.UpdateAITrainerGroup(string(created.ID), authToken, &graphql.AITrainerGroupInput{Price: &negative})
+	assert.ErrorIs(s.T(), errUpdate, graphql.ErrValidation)
+
+	// Groups of other users can't be changed
+	other := s.mock.AddAITrainerGroup(graphql.AITrainerGroup{Name: "Not yours", User: graphql.User{ID: "user-0"}})
+	_, errUpdate = s.client.UpdateAITrainerGroup(string(other.ID), authToken, &graphql.AITrainerGroupInput{ProfilePhotoUri: &photo})
+	assert.ErrorIs(s.T(), errUpdate, graphql.ErrUnauthorized)
+
+	session := graphql.NewSession(s.client, "wanda", "secret")
+	free := 0
+	updated, errUpdate = session.UpdateAITrainerGroup(context.Background(), string(created.ID), &graphql.AITrainerGroupInput{Price: &free})
+	assert.Nil(s.T(), errUpdate)
+	assert.Equal(s.T(), gql.Int(0), updated.Price)
+}
+
+func (s *ServerTestSuite) TestAITrainerGroupInputOmitsUnsetFields() {
+	authToken := s.helperLogin()
+	recorder := NewRecorder()
+	client := graphql.GetKajiwotoGraphQLClient(s.server.URL, graphql.WithMiddleware(recorder.Wrap))
+
+	price := 0
+	_, errUpdate := client.UpdateAITrainerGroup(string(s.group.ID), authToken, &graphql.AITrainerGroupInput{Price: &price, Tags: []string{}})
+	assert.Nil(s.T(), errUpdate)
+	interactions := recorder.Cassette().Interactions
+	if assert.Len(s.T(), interactions, 1) {
+		// Unset fields are omitted instead of being sent as null, zero values and empty slices are sent
+		assert.Equal(s.T(), map[string]interface{}{"price": float64(0), "tags": []interface{}{}}, interactions[0].Request.Variables["input"])
+	}
+}
+
+func (s *ServerTestSuite) TestAIDocuments() {
+	authToken := s.helperLogin()
+	groupID := string(s.group.ID)
+
+	backstory, errCreate := s.client.CreateAIDocument(groupID, authToken, "Backstory", "Wanda grew up in the woods.")
+	assert.Nil(s.T(), errCreate)
+	assert.Equal(s.T(), gql.Int(1), backstory.Order)
+	assert.True(s.T(), backstory.IsPending())
+	hobbies, errCreate := s.client.CreateAIDocument(groupID, authToken, "Hobbies", "Fencing")
+	assert.Nil(s.T(), errCreate)
+	assert.Equal(s.T(), gql.Int(2), hobbies.Order)
+	_, errCreate = s.client.CreateAIDocument(groupID, authToken, " ", "")
+	assert.ErrorIs(s.T(), errCreate, graphql.ErrValidation)
+
+	hobbies, errUpdate := s.client.UpdateAIDocument(groupID, string(hobbies.ID), authToken, "Hobbies", "Fencing, baking")
+	assert.Nil(s.T(), errUpdate)
+	assert.Equal(s.T(), gql.String("Fencing, baking"), hobbies.Content)
+	_, errUpdate = s.client.UpdateAIDocument(groupID, "document-0", authToken, "Hobbies", "")
+	assert.ErrorIs(s.T(), errUpdate, graphql.ErrNotFound)
+
+	documents, errReorder := s.client.ReorderAIDocuments(groupID, authToken, []string{string(hobbies.ID), string(backstory.ID)})
+	assert.Nil(s.T(), errReorder)
+	assert.Equal(s.T(), []gql.String{hobbies.ID, backstory.ID}, []gql.String{documents[0].ID, documents[1].ID})
+	assert.Equal(s.T(), gql.Int(2), documents[1].Order)
+	_, errReorder = s.client.ReorderAIDocuments(groupID, authToken, []string{string(hobbies.ID), string(hobbies.ID)})
+	assert.ErrorIs(s.T(), errReorder, graphql.ErrValidation)
+
+	// Builds finish once the mock is told so
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = s.mock.SetAIDocumentQueueStatus(groupID, string(backstory.ID), graphql.AIDocumentStatusBuilt)
+	}()
+	built, errWait := s.client.WaitForAIDocumentBuild(context.Background(), groupID, string(backstory.ID), authToken, graphql.WaitOptions{PollInterval: 10 * time.Millisecond})
+	assert.Nil(s.T(), errWait)
+	assert.Equal(s.T(), gql.String(graphql.AIDocumentStatusBuilt), built.QueueStatus)
+	assert.NotZero(s.T(), built.BuiltAt)
+
+	assert.Nil(s.T(), s.mock.SetAIDocumentQueueStatus(groupID, string(hobbies.ID), graphql.AIDocumentStatusFailed))
+	_, errWait = s.client.WaitForAIDocumentBuild(context.Background(), groupID, string(hobbies.ID), authToken, graphql.WaitOptions{PollInterval: 10 * time.Millisecond})
+	assert.ErrorIs(s.T(), errWait, graphql.ErrAIDocumentBuildFailed)
+	rebuilt, errRebuild := s.client.RebuildAIDocument(groupID, string(hobbies.ID), authToken)
+	assert.Nil(s.T(), errRebuild)
+	assert.True(s.T(), rebuilt.IsPending())
+
+	session := graphql.NewSession(s.client, "wanda", "secret")
+	documents, errDelete := session.DeleteAIDocument(context.Background(), groupID, string(hobbies.ID))
+	assert.Nil(s.T(), errDelete)
+	assert.Len(s.T(), documents, 1)
+	assert.Equal(s.T(), gql.Int(1), documents[0].Order)
+	document, errGet := s.client.GetAIDocument(groupID, string(backstory.ID), authToken)
+	assert.Nil(s.T(), errGet)
+	assert.Equal(s.T(), gql.Int(1), document.Order)
+	_, errGet = s.client.GetAIDocument(groupID, string(hobbies.ID), authToken)
+	assert.ErrorIs(s.T(), errGet, graphql.ErrNotFound)
+}
+
+func (s *ServerTestSuite) TestExec() {
+	session := graphql.NewSession(s.client, "wanda", "secret")
+	var result struct {
+		Room graphql.Room `json:"room"`
+	}
+	errExec := session.Exec(context.Background(), `query ($chatRoomId: String!, $kajiId: String!) { room (chatRoomId: $chatRoomId, kajiId: $kajiId) { kajiDisplayName } }`,
+		map[string]interface{}{"chatRoomId": "c3d4", "kajiId": ""}, &result)
+	assert.Nil(s.T(), errExec)
+	assert.Equal(s.T(), gql.String("Wanda"), result.Room.KajiDisplayName)
+
+	errExec = session.Exec(context.Background(), `{ unknownField { id } }`, nil, nil)
+	assert.ErrorIs(s.T(), errExec, graphql.ErrValidation)
+}
+
+func (s *ServerTestSuite) TestSession() {
+	session := graphql.NewSession(s.client, "wanda", "secret")
+	_, errRoom := session.GetRoom(context.Background(), "c3d4", "")
+	assert.Nil(s.T(), errRoom)
+
+	// Session logs in again once its token expires
+	s.mock.RevokeAuthTokens()
+	_, errRoom = session.GetRoom(context.Background(), "c3d4", "")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), []string{"login", "welcome", "room", "room", "login", "welcome", "room"}, s.mock.Operations())
+}
+
+func (s *ServerTestSuite) TestRootFields() {
+	assert.Equal(s.T(), []string{"login", "welcome"}, rootFields(`mutation ($password:String!){login (password: $password, deviceType: WEB){authToken,user{id}},welcome{webVersion}}`))
+	assert.Equal(s.T(), []string{"room"}, rootFields(`query ($id:String!){ r: room(chatRoomId: $id) { id chatRoom { id } } }`))
+	assert.Empty(s.T(), rootFields("nonsense"))
+}
@@ -0,0 +1,128 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+)
+
+const (
+	// DatasetLinesMaxPageSize is the highest limit accepted by the datasetLines query
+	DatasetLinesMaxPageSize = 100
+)
+
+// DatasetLinesPageFunc fetches a single page of dataset lines
+type DatasetLinesPageFunc func(ctx context.Context, limit, offset int) ([]DatasetLine, error)
+
+// DatasetLineIterator walks all dataset lines of an AI trainer group page by page.
+// Usage:
+//
+//	it := client.IterateDatasetLines(aiTrainerGroupID, "", authToken, 0)
+//	for it.Next(ctx) {
+//		line := it.Line()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type DatasetLineIterator struct {
+	fetchPage DatasetLinesPageFunc
+	pageSize  int
+	offset    int
+	page      []DatasetLine
+	current   DatasetLine
+	lastPage  bool
+	err       error
+}
+
+// NewDatasetLineIterator returns an iterator which fetches its lines page by page using fetchPage.
+// A pageSize < 1 or > DatasetLinesMaxPageSize uses DatasetLinesMaxPageSize.
+func NewDatasetLineIterator(fetchPage DatasetLinesPageFunc, pageSize int) *DatasetLineIterator {
+	if pageSize < 1 || pageSize > DatasetLinesMaxPageSize {
+		pageSize = DatasetLinesMaxPageSize
+	}
+	return &DatasetLineIterator{
+		fetchPage: fetchPage,
+		pageSize:  pageSize,
+	}
+}
+
+// IterateDatasetLines returns an iterator over all dataset lines of an AI trainer group matching searchQuery.
+// An empty searchQuery matches all lines; a pageSize < 1 or > DatasetLinesMaxPageSize uses DatasetLinesMaxPageSize.
+func (c *KajiwotoGraphQLClient) IterateDatasetLines(aiTrainerGroupID, searchQuery, authToken string, pageSize int) *DatasetLineIterator {
+	return NewDatasetLineIterator(func(ctx context.Context, limit, offset int) ([]DatasetLine, error) {
+		return c.GetDatasetLinesWithContext(ctx, aiTrainerGroupID, searchQuery, authToken, limit, offset)
+	}, pageSize)
+}
+
+// IterateDatasetLines returns an iterator over all dataset lines of an AI trainer group matching searchQuery.
+// An empty searchQuery matches all lines; a pageSize < 1 or > DatasetLinesMaxPageSize uses DatasetLinesMaxPageSize.
+func (s *Session) IterateDatasetLines(aiTrainerGroupID, searchQuery string, pageSize int) *DatasetLineIterator {
+	return NewDatasetLineIterator(func(ctx context.Context, limit, offset int) ([]DatasetLine, error) {
+		return s.GetDatasetLines(ctx, aiTrainerGroupID, searchQuery, limit, offset)
+	}, pageSize)
+}
+
+// Next advances the iterator to the next line, fetching the next page if required.
+// It returns false once all lines have been read, ctx is done or a request failed; check Err afterwards.
+func (it *DatasetLineIterator) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if errCtx := ctx.Err(); errCtx != nil {
+		it.err = errCtx
+		return false
+	}
+	if len(it.page) == 0 {
+		if it.lastPage {
+			return false
+		}
+		page, errFetch := it.fetchPage(ctx, it.pageSize, it.offset)
+		if errFetch != nil {
+			it.err = errFetch
+			return false
+		}
+		it.offset += len(page)
+		// A short page means there is nothing left to fetch
+		it.lastPage = len(page) < it.pageSize
+		it.page = page
+		if len(it.page) == 0 {
+			return false
+		}
+	}
+	it.current = it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+// Line returns the line the iterator currently points to
+func (it *DatasetLineIterator) Line() DatasetLine {
+	return it.current
+}
+
+// Err returns the error which stopped the iteration, if any
+func (it *DatasetLineIterator) Err() error {
+	return it.err
+}
+
+// Collect reads all remaining lines of the iterator
+func (it *DatasetLineIterator) Collect(ctx context.Context) ([]DatasetLine, error) {
+	lines := make([]DatasetLine, 0)
+	for it.Next(ctx) {
+		lines = append(lines, it.Line())
+	}
+	return lines, it.Err()
+}
@@ -0,0 +1,134 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type DatasetLineIteratorTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	lines    []DatasetLine
+	requests atomic.Int32
+}
+
+func TestDatasetLineIteratorTestSuite(t *testing.T) {
+	suite.Run(t, new(DatasetLineIteratorTestSuite))
+}
+
+func (s *DatasetLineIteratorTestSuite) SetupTest() {
+	s.lines = make([]DatasetLine, 0)
+	for i := 0; i < 250; i++ {
+		message := fmt.Sprintf("message %d", i)
+		if i%10 == 0 {
+			message += " *smiles*"
+		}
+		s.lines = append(s.lines, DatasetLine{ID: gql.String(fmt.Sprintf("line-%d", i)), Message: gql.String(message)})
+	}
+	s.requests.Store(0)
+	s.server = httptest.NewServer(http.HandlerFunc(s.handleRequest))
+}
+
+func (s *DatasetLineIteratorTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+// handleRequest emulates the datasetLines query of the backend
+func (s *DatasetLineIteratorTestSuite) handleRequest(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	in := struct {
+		Variables struct {
+			SearchQuery string `json:"searchQuery"`
+			Limit       int    `json:"limit"`
+			Offset      int    `json:"offset"`
+		} `json:"variables"`
+	}{}
+	_ = json.NewDecoder(r.Body).Decode(&in)
+
+	matches := make([]DatasetLine, 0)
+	for _, line := range s.lines {
+		if strings.Contains(string(line.Message), in.Variables.SearchQuery) {
+			matches = append(matches, line)
+		}
+	}
+	page := make([]DatasetLine, 0)
+	for i := in.Variables.Offset; i < len(matches) && i < in.Variables.Offset+in.Variables.Limit; i++ {
+		page = append(page, matches[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"datasetLines": page}})
+}
+
+func (s *DatasetLineIteratorTestSuite) TestIterateAllLines() {
+	client := GetKajiwotoGraphQLClient(s.server.URL)
+	lines, errCollect := client.IterateDatasetLines("group", "", "token", 0).Collect(context.Background())
+	assert.Nil(s.T(), errCollect)
+	assert.Equal(s.T(), s.lines, lines)
+	assert.Equal(s.T(), int32(3), s.requests.Load())
+}
+
+func (s *DatasetLineIteratorTestSuite) TestIterateExactPageBoundary() {
+	client := GetKajiwotoGraphQLClient(s.server.URL)
+	lines, errCollect := client.IterateDatasetLines("group", "", "token", 50).Collect(context.Background())
+	assert.Nil(s.T(), errCollect)
+	assert.Len(s.T(), lines, 250)
+	// Last full page requires one more request to detect the end
+	assert.Equal(s.T(), int32(6), s.requests.Load())
+}
+
+func (s *DatasetLineIteratorTestSuite) TestIterateSearchQuery() {
+	client := GetKajiwotoGraphQLClient(s.server.URL)
+	lines, errCollect := client.IterateDatasetLines("group", "*smiles*", "token", 10).Collect(context.Background())
+	assert.Nil(s.T(), errCollect)
+	assert.Len(s.T(), lines, 25)
+}
+
+func (s *DatasetLineIteratorTestSuite) TestIterateCancelled() {
+	client := GetKajiwotoGraphQLClient(s.server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it := client.IterateDatasetLines("group", "", "token", 10)
+	count := 0
+	for it.Next(ctx) {
+		count++
+		if count == 15 {
+			cancel()
+		}
+	}
+	assert.Equal(s.T(), 15, count)
+	assert.ErrorIs(s.T(), it.Err(), context.Canceled)
+}
+
+func (s *DatasetLineIteratorTestSuite) TestIteratePageSizeClamped() {
+	client := GetKajiwotoGraphQLClient(s.server.URL)
+	lines, errCollect := client.IterateDatasetLines("group", "", "token", DatasetLinesMaxPageSize+1).Collect(context.Background())
+	assert.Nil(s.T(), errCollect)
+	assert.Len(s.T(), lines, 250)
+	// Pages of DatasetLinesMaxPageSize are fetched
+	assert.Equal(s.T(), int32(3), s.requests.Load())
+}
@@ -34,6 +34,14 @@ type kajiwotoDatasetAITrainerGroupQuery struct {
 	AITrainerGroup AITrainerGroup `graphql:"aiTrainerGroup (aiTrainerGroupId: $aiTrainerGroupId)"`
 }
 
+type kajiwotoCreateAITrainerGroupMutation struct {
+	AITrainerGroup AITrainerGroup `graphql:"createAITrainerGroup (input: $input)"`
+}
+
+type kajiwotoUpdateAITrainerGroupMutation struct {
+	AITrainerGroup AITrainerGroup `graphql:"updateAITrainerGroup (aiTrainerGroupId: $aiTrainerGroupId, input: $input)"`
+}
+
 type kajiwotoDatasetLinesQuery struct {
 	DatasetLines []DatasetLine `graphql:"datasetLines (aiTrainerGroupId: $aiTrainerGroupId, searchQuery: $searchQuery, limit: $limit, offset: $offset )"`
 }
@@ -42,6 +50,26 @@ type kajiwotoAddToDatasetMutation struct {
 	AIEditorResult AIEditorResult `graphql:"addToDataset (aiTrainerGroupId: $aiTrainerGroupId, editorType: $editorType, generateResults: $generateResults, dialogues: $dialogues )"`
 }
 
+type kajiwotoCreateAIDocumentMutation struct {
+	AIDocument AIDocument `graphql:"createAIDocument (aiTrainerGroupId: $aiTrainerGroupId, title: $title, content: $content )"`
+}
+
+type kajiwotoUpdateAIDocumentMutation struct {
+	AIDocument AIDocument `graphql:"updateAIDocument (aiTrainerGroupId: $aiTrainerGroupId, aiDocumentId: $aiDocumentId, title: $title, content: $content )"`
+}
+
+type kajiwotoReorderAIDocumentsMutation struct {
+	AIDocuments []AIDocument `graphql:"reorderAIDocuments (aiTrainerGroupId: $aiTrainerGroupId, aiDocumentIds: $aiDocumentIds )"`
+}
+
+type kajiwotoDeleteAIDocumentMutation struct {
+	AIDocuments []AIDocument `graphql:"deleteAIDocument (aiTrainerGroupId: $aiTrainerGroupId, aiDocumentId: $aiDocumentId )"`
+}
+
+type kajiwotoRebuildAIDocumentMutation struct {
+	AIDocument AIDocument `graphql:"rebuildAIDocument (aiTrainerGroupId: $aiTrainerGroupId, aiDocumentId: $aiDocumentId )"`
+}
+
 type kajiwotoRoomQuery struct {
 	Room Room `graphql:"room (chatRoomId: $chatRoomId, kajiId: $kajiId)"`
 }
@@ -0,0 +1,253 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// Session holds the credentials and current login of a single Kajiwoto account.
+// It logs in lazily on first use and logs in again whenever the auth token turns out to be empty or expired,
+// so the data methods can be called without handling auth tokens manually.
+// A Session is safe for concurrent use; multiple sessions can share one KajiwotoGraphQLClient.
+type Session struct {
+	client   *KajiwotoGraphQLClient
+	username string
+	password string
+	// Login state
+	login           Login
+	loggedInAt      time.Time
+	refreshInterval time.Duration
+	tokenStore      TokenStore
+	loginMtx        sync.Mutex
+}
+
+// NewSession creates a session for the given account. No request is sent until the session is used.
+func NewSession(client *KajiwotoGraphQLClient, username, password string) *Session {
+	return &Session{
+		client:   client,
+		username: username,
+		password: password,
+	}
+}
+
+// SetAuthToken sets a previously stored auth token, which is tried before falling back to username / password
+func (s *Session) SetAuthToken(authToken string) {
+	s.loginMtx.Lock()
+	s.login = Login{AuthToken: authToken}
+	s.loggedInAt = time.Time{}
+	s.loginMtx.Unlock()
+}
+
+// SetRefreshInterval makes the session re-validate its auth token once it is older than interval.
+// An interval of 0 disables the refresh; the token is then only renewed once the backend rejects it.
+func (s *Session) SetRefreshInterval(interval time.Duration) {
+	s.loginMtx.Lock()
+	s.refreshInterval = interval
+	s.loginMtx.Unlock()
+}
+
+// SetTokenStore makes the session load its auth token from store before the first login,
+// and save every successful login to it. Logins are stored using the username as key.
+func (s *Session) SetTokenStore(store TokenStore) {
+	s.loginMtx.Lock()
+	s.tokenStore = store
+	s.loginMtx.Unlock()
+}
+
+// Login returns the current login of the session, logging in if there is no valid login yet
+func (s *Session) Login(ctx context.Context) (Login, error) {
+	s.loginMtx.Lock()
+	defer s.loginMtx.Unlock()
+	if s.isLoggedIn() {
+		return s.login, nil
+	}
+	if s.login.AuthToken == "" && s.tokenStore != nil {
+		storedLogin, errLoad := s.tokenStore.LoadLogin(s.username)
+		if errLoad == nil {
+			s.login.AuthToken = storedLogin.AuthToken
+		} else if !errors.Is(errLoad, ErrTokenNotFound) {
+			log.Warnf("Unable to load stored login for '%v'. Error: %v", s.username, errLoad)
+		}
+	}
+	if errLogin := s.doLogin(ctx); errLogin != nil {
+		return Login{}, errLogin
+	}
+	return s.login, nil
+}
+
+// Relogin discards the current auth token and logs in again using username / password
+func (s *Session) Relogin(ctx context.Context) (Login, error) {
+	s.loginMtx.Lock()
+	defer s.loginMtx.Unlock()
+	s.login = Login{}
+	if errLogin := s.doLogin(ctx); errLogin != nil {
+		return Login{}, errLogin
+	}
+	return s.login, nil
+}
+
+// AuthToken returns a valid auth token for the session, logging in if required
+func (s *Session) AuthToken(ctx context.Context) (string, error) {
+	login, errLogin := s.Login(ctx)
+	if errLogin != nil {
+		return "", errLogin
+	}
+	return login.AuthToken, nil
+}
+
+// GetAITrainerGroup fetches the AI trainer group with the given ID
+func (s *Session) GetAITrainerGroup(ctx context.Context, aiTrainerGroupID string) (AITrainerGroup, error) {
+	return withSession(ctx, s, func(authToken string) (AITrainerGroup, error) {
+		return s.client.GetAITrainerGroupWithContext(ctx, aiTrainerGroupID, authToken)
+	})
+}
+
+// GetDatasetLines fetches a page of dataset lines of an AI trainer group
+func (s *Session) GetDatasetLines(ctx context.Context, aiTrainerGroupID, searchQuery string, limit, offset int) ([]DatasetLine, error) {
+	return withSession(ctx, s, func(authToken string) ([]DatasetLine, error) {
+		return s.client.GetDatasetLinesWithContext(ctx, aiTrainerGroupID, searchQuery, authToken, limit, offset)
+	})
+}
+
+// AddToDataset adds the given dialogues to the dataset of an AI trainer group
+func (s *Session) AddToDataset(ctx context.Context, aiTrainerGroupID string, dialogues []*AiDialogueInput) (AIEditorResult, error) {
+	return withSession(ctx, s, func(authToken string) (AIEditorResult, error) {
+		return s.client.AddToDatasetWithContext(ctx, aiTrainerGroupID, authToken, dialogues)
+	})
+}
+
+// GetRoom fetches the room data for the given chat room
+func (s *Session) GetRoom(ctx context.Context, chatRoomID, kajiID string) (Room, error) {
+	return withSession(ctx, s, func(authToken string) (Room, error) {
+		return s.client.GetRoomWithContext(ctx, chatRoomID, kajiID, authToken)
+	})
+}
+
+// GetRoomHistory fetches the message history for the given chat room
+func (s *Session) GetRoomHistory(ctx context.Context, chatRoomID, kajiID string) (RoomHistory, error) {
+	return withSession(ctx, s, func(authToken string) (RoomHistory, error) {
+		return s.client.GetRoomHistoryWithContext(ctx, chatRoomID, kajiID, authToken)
+	})
+}
+
+// GetRooms fetches a page of the personal rooms of the session's user
+func (s *Session) GetRooms(ctx context.Context, limit, offset int) ([]Room, error) {
+	return withSession(ctx, s, func(authToken string) ([]Room, error) {
+		return s.client.GetRoomsWithContext(ctx, authToken, limit, offset)
+	})
+}
+
+// withSession runs call with the session's auth token.
+// If the backend rejects the token, the session logs in again and call is retried once.
+func withSession[T any](ctx context.Context, s *Session, call func(authToken string) (T, error)) (T, error) {
+	authToken, errLogin := s.AuthToken(ctx)
+	if errLogin != nil {
+		var empty T
+		return empty, errLogin
+	}
+	result, errCall := call(authToken)
+	if errCall == nil || !isAuthError(errCall) {
+		return result, errCall
+	}
+
+	log.Debugf("Auth token rejected by backend, logging in again. Error: %v", errCall)
+	if authToken, errLogin = s.invalidate(ctx, authToken); errLogin != nil {
+		var empty T
+		return empty, errLogin
+	}
+	return call(authToken)
+}
+
+// invalidate drops the rejected auth token and logs in again.
+// If another caller already replaced the token in the meantime, the new token is returned instead.
+func (s *Session) invalidate(ctx context.Context, rejectedToken string) (string, error) {
+	s.loginMtx.Lock()
+	defer s.loginMtx.Unlock()
+	if s.login.AuthToken != rejectedToken && s.isLoggedIn() {
+		return s.login.AuthToken, nil
+	}
+	s.login = Login{}
+	if errLogin := s.doLogin(ctx); errLogin != nil {
+		return "", errLogin
+	}
+	return s.login.AuthToken, nil
+}
+
+// isLoggedIn checks whether the current login can be used; loginMtx must be held
+func (s *Session) isLoggedIn() bool {
+	if s.login.AuthToken == "" || s.loggedInAt.IsZero() {
+		return false
+	}
+	if s.refreshInterval > 0 && time.Since(s.loggedInAt) > s.refreshInterval {
+		return false
+	}
+	return true
+}
+
+// doLogin logs in via auth token if available, with username / password as fallback; loginMtx must be held
+func (s *Session) doLogin(ctx context.Context) error {
+	var loginResult LoginResult
+	var errLogin error
+	if s.login.AuthToken != "" {
+		log.Debug("Performing login via auth token")
+		loginResult, errLogin = s.client.DoLoginAuthTokenWithContext(ctx, s.login.AuthToken)
+		if errLogin != nil {
+			log.Debugf("Unable to login via auth token, trying with username / password. Error: %v", errLogin)
+		} else if loginResult.Login.AuthToken == "" {
+			log.Debug("No User information returned from server. Session may be outdated. Trying with username / password.")
+		}
+	}
+	if s.login.AuthToken == "" || errLogin != nil || loginResult.Login.AuthToken == "" {
+		// Context errors should not be hidden behind a second login attempt
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Debug("Performing login via Username / Password combo")
+		loginResult, errLogin = s.client.DoLoginUserPWWithContext(ctx, s.username, s.password)
+		if errLogin != nil {
+			s.login = Login{}
+			return errLogin
+		}
+	}
+
+	// Validate response
+	if loginResult.Login.AuthToken == "" {
+		s.login = Login{}
+		return errors.New("invalid response from server: Auth token empty")
+	}
+
+	s.login = loginResult.Login
+	s.loggedInAt = time.Now()
+
+	// Persist login; the session stays usable even if this fails
+	if s.tokenStore != nil {
+		if errSave := s.tokenStore.SaveLogin(s.username, s.login); errSave != nil {
+			log.Warnf("Unable to store login for '%v'. Error: %v", s.username, errSave)
+		}
+	}
+	return nil
+}
+
+// isAuthError checks whether err indicates that the backend did not accept the auth token
+func isAuthError(err error) bool {
+	return errors.Is(err, ErrUnauthorized)
+}
@@ -0,0 +1,170 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type SessionTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+	// Backend state
+	mtx          sync.Mutex
+	validTokens  map[string]bool
+	tokenCounter int
+	pwLogins     int
+}
+
+func TestSessionTestSuite(t *testing.T) {
+	suite.Run(t, new(SessionTestSuite))
+}
+
+func (s *SessionTestSuite) SetupTest() {
+	// Set Log level for all tests
+	log.SetLevel(log.DebugLevel)
+
+	s.validTokens = map[string]bool{}
+	s.tokenCounter = 0
+	s.pwLogins = 0
+	s.server = httptest.NewServer(http.HandlerFunc(s.handleRequest))
+}
+
+func (s *SessionTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+// handleRequest emulates the login and room endpoints of the backend
+func (s *SessionTestSuite) handleRequest(w http.ResponseWriter, r *http.Request) {
+	in := struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}{}
+	_ = json.NewDecoder(r.Body).Decode(&in)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.Contains(in.Query, "loginWithToken"):
+		authToken, _ := in.Variables["authToken"].(string)
+		if !s.validTokens[authToken] {
+			authToken = ""
+		}
+		_, _ = fmt.Fprintf(w, `{"data":{"loginWithToken":{"authToken":%q}}}`, authToken)
+	case strings.Contains(in.Query, "login"):
+		if in.Variables["usernameOrEmail"] != "user" || in.Variables["password"] != "pw" {
+			_, _ = fmt.Fprint(w, `{"errors":[{"message":"Invalid username or password"}]}`)
+			return
+		}
+		s.pwLogins++
+		s.tokenCounter++
+		authToken := fmt.Sprintf("token-%d", s.tokenCounter)
+		s.validTokens[authToken] = true
+		_, _ = fmt.Fprintf(w, `{"data":{"login":{"authToken":%q,"user":{"displayName":"User"}}}}`, authToken)
+	case strings.Contains(in.Query, "room"):
+		if !s.validTokens[r.Header.Get("auth_token")] {
+			_, _ = fmt.Fprint(w, `{"errors":[{"message":"Unauthorized"}]}`)
+			return
+		}
+		_, _ = fmt.Fprint(w, `{"data":{"room":{"id":"r1","chatRoomId":"c3d4"}}}`)
+	}
+}
+
+func (s *SessionTestSuite) revokeAllTokens() {
+	s.mtx.Lock()
+	s.validTokens = map[string]bool{}
+	s.mtx.Unlock()
+}
+
+func (s *SessionTestSuite) TestSessionLazyLogin() {
+	session := NewSession(GetKajiwotoGraphQLClient(s.server.URL), "user", "pw")
+	assert.Equal(s.T(), 0, s.pwLogins)
+
+	room, errRoom := session.GetRoom(context.Background(), "c3d4", "")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), "r1", string(room.ID))
+
+	// Second call reuses the login
+	_, errRoom = session.GetRoom(context.Background(), "c3d4", "")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), 1, s.pwLogins)
+}
+
+func (s *SessionTestSuite) TestSessionOutdatedAuthTokenFallback() {
+	session := NewSession(GetKajiwotoGraphQLClient(s.server.URL), "user", "pw")
+	session.SetAuthToken("outdated")
+
+	authToken, errLogin := session.AuthToken(context.Background())
+	assert.Nil(s.T(), errLogin)
+	assert.Equal(s.T(), "token-1", authToken)
+	assert.Equal(s.T(), 1, s.pwLogins)
+}
+
+func (s *SessionTestSuite) TestSessionReloginOnRejectedToken() {
+	session := NewSession(GetKajiwotoGraphQLClient(s.server.URL), "user", "pw")
+	_, errLogin := session.Login(context.Background())
+	assert.Nil(s.T(), errLogin)
+
+	// Backend forgets the token, session has to log in again
+	s.revokeAllTokens()
+	room, errRoom := session.GetRoom(context.Background(), "c3d4", "")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), "r1", string(room.ID))
+	assert.Equal(s.T(), 2, s.pwLogins)
+
+	authToken, _ := session.AuthToken(context.Background())
+	assert.Equal(s.T(), "token-2", authToken)
+}
+
+func (s *SessionTestSuite) TestSessionWrongCredentials() {
+	session := NewSession(GetKajiwotoGraphQLClient(s.server.URL), "user", "wrong")
+	_, errRoom := session.GetRoom(context.Background(), "c3d4", "")
+	assert.NotNil(s.T(), errRoom)
+}
+
+func (s *SessionTestSuite) TestSessionTokenStore() {
+	store := NewMemoryTokenStore()
+	client := GetKajiwotoGraphQLClient(s.server.URL)
+
+	// First session logs in with password and stores the token
+	session := NewSession(client, "user", "pw")
+	session.SetTokenStore(store)
+	authToken, errLogin := session.AuthToken(context.Background())
+	assert.Nil(s.T(), errLogin)
+	stored, errLoad := store.LoadLogin("user")
+	assert.Nil(s.T(), errLoad)
+	assert.Equal(s.T(), authToken, stored.AuthToken)
+
+	// Restarted session reuses the stored token without password login
+	restarted := NewSession(client, "user", "pw")
+	restarted.SetTokenStore(store)
+	restartedToken, errLogin := restarted.AuthToken(context.Background())
+	assert.Nil(s.T(), errLogin)
+	assert.Equal(s.T(), authToken, restartedToken)
+	assert.Equal(s.T(), 1, s.pwLogins)
+}
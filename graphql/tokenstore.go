@@ -0,0 +1,164 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrTokenNotFound = errors.New("no login stored for key")
+)
+
+// TokenStore persists Login sessions, so a restarted application can reuse its auth token instead of logging in again.
+// Logins are stored by key, which is usually the username of the account.
+type TokenStore interface {
+	LoadLogin(key string) (Login, error)
+	SaveLogin(key string, login Login) error
+	DeleteLogin(key string) error
+}
+
+// MemoryTokenStore keeps logins in memory only. Useful for tests, or for sharing logins between sessions of one process.
+type MemoryTokenStore struct {
+	logins map[string]Login
+	mtx    sync.RWMutex
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		logins: make(map[string]Login),
+	}
+}
+
+func (m *MemoryTokenStore) LoadLogin(key string) (Login, error) {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	login, ok := m.logins[key]
+	if !ok {
+		return Login{}, ErrTokenNotFound
+	}
+	return login, nil
+}
+
+func (m *MemoryTokenStore) SaveLogin(key string, login Login) error {
+	m.mtx.Lock()
+	m.logins[key] = login
+	m.mtx.Unlock()
+	return nil
+}
+
+func (m *MemoryTokenStore) DeleteLogin(key string) error {
+	m.mtx.Lock()
+	delete(m.logins, key)
+	m.mtx.Unlock()
+	return nil
+}
+
+// FileTokenStore keeps logins in a JSON file. The file is only readable by the current user, since it contains auth tokens.
+type FileTokenStore struct {
+	path string
+	mtx  sync.Mutex
+}
+
+func NewFileTokenStore(path string) *FileTokenStore {
+	return &FileTokenStore{
+		path: path,
+	}
+}
+
+func (f *FileTokenStore) LoadLogin(key string) (Login, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	logins, errRead := f.readFile()
+	if errRead != nil {
+		return Login{}, errRead
+	}
+	login, ok := logins[key]
+	if !ok {
+		return Login{}, ErrTokenNotFound
+	}
+	return login, nil
+}
+
+func (f *FileTokenStore) SaveLogin(key string, login Login) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	logins, errRead := f.readFile()
+	if errRead != nil {
+		return errRead
+	}
+	logins[key] = login
+	return f.writeFile(logins)
+}
+
+func (f *FileTokenStore) DeleteLogin(key string) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	logins, errRead := f.readFile()
+	if errRead != nil {
+		return errRead
+	}
+	if _, ok := logins[key]; !ok {
+		return nil
+	}
+	delete(logins, key)
+	return f.writeFile(logins)
+}
+
+// readFile reads all stored logins; a missing file is treated as empty store
+func (f *FileTokenStore) readFile() (map[string]Login, error) {
+	logins := make(map[string]Login)
+	data, errRead := os.ReadFile(f.path)
+	if errors.Is(errRead, os.ErrNotExist) {
+		return logins, nil
+	} else if errRead != nil {
+		return nil, errRead
+	}
+	if errUnmarshal := json.Unmarshal(data, &logins); errUnmarshal != nil {
+		return nil, errUnmarshal
+	}
+	return logins, nil
+}
+
+// writeFile replaces the store file via rename, so a crash can't leave a truncated file behind
+func (f *FileTokenStore) writeFile(logins map[string]Login) error {
+	data, errMarshal := json.MarshalIndent(logins, "", "  ")
+	if errMarshal != nil {
+		return errMarshal
+	}
+	tmpFile, errCreate := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".tmp*")
+	if errCreate != nil {
+		return errCreate
+	}
+	defer os.Remove(tmpFile.Name())
+	if errChmod := tmpFile.Chmod(0600); errChmod != nil {
+		tmpFile.Close()
+		return errChmod
+	}
+	if _, errWrite := tmpFile.Write(data); errWrite != nil {
+		tmpFile.Close()
+		return errWrite
+	}
+	if errClose := tmpFile.Close(); errClose != nil {
+		return errClose
+	}
+	return os.Rename(tmpFile.Name(), f.path)
+}
@@ -0,0 +1,69 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type TokenStoreTestSuite struct {
+	suite.Suite
+}
+
+func TestTokenStoreTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenStoreTestSuite))
+}
+
+func (s *TokenStoreTestSuite) helperStoreRoundTrip(store TokenStore) {
+	// Missing login
+	_, errLoad := store.LoadLogin("user")
+	assert.ErrorIs(s.T(), errLoad, ErrTokenNotFound)
+
+	// Save & load
+	login := Login{AuthToken: "token-1", User: User{ID: "a1b2", DisplayName: "RuntimeRacer"}}
+	assert.Nil(s.T(), store.SaveLogin("user", login))
+	assert.Nil(s.T(), store.SaveLogin("other", Login{AuthToken: "token-2"}))
+	loaded, errLoad := store.LoadLogin("user")
+	assert.Nil(s.T(), errLoad)
+	assert.Equal(s.T(), login, loaded)
+
+	// Delete
+	assert.Nil(s.T(), store.DeleteLogin("user"))
+	_, errLoad = store.LoadLogin("user")
+	assert.ErrorIs(s.T(), errLoad, ErrTokenNotFound)
+	other, errLoad := store.LoadLogin("other")
+	assert.Nil(s.T(), errLoad)
+	assert.Equal(s.T(), "token-2", other.AuthToken)
+}
+
+func (s *TokenStoreTestSuite) TestMemoryTokenStore() {
+	s.helperStoreRoundTrip(NewMemoryTokenStore())
+}
+
+func (s *TokenStoreTestSuite) TestFileTokenStore() {
+	path := filepath.Join(s.T().TempDir(), "tokens.json")
+	s.helperStoreRoundTrip(NewFileTokenStore(path))
+
+	// File must only be accessible by the owner
+	info, errStat := os.Stat(path)
+	assert.Nil(s.T(), errStat)
+	assert.Equal(s.T(), os.FileMode(0600), info.Mode().Perm())
+}
@@ -0,0 +1,125 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"fmt"
+	gql "github.com/runtimeracer/go-graphql-client"
+)
+
+// Publication states of an AITrainerGroup
+const (
+	AITrainerGroupStatusDraft     = "DRAFT"
+	AITrainerGroupStatusPublished = "PUBLISHED"
+)
+
+// CreateAITrainerGroup calls CreateAITrainerGroupWithContext using a background context
+func (c *KajiwotoGraphQLClient) CreateAITrainerGroup(authToken string, input *AITrainerGroupInput) (result AITrainerGroup, err error) {
+	return c.CreateAITrainerGroupWithContext(context.Background(), authToken, input)
+}
+
+// CreateAITrainerGroupWithContext creates a new AI trainer group owned by the logged-in user; input.Name is required
+//
+// Experimental: the createAITrainerGroup mutation is not verified against the live backend yet, see README.md.
+func (c *KajiwotoGraphQLClient) CreateAITrainerGroupWithContext(ctx context.Context, authToken string, input *AITrainerGroupInput) (result AITrainerGroup, err error) {
+	// Sanity check
+	if authToken == "" {
+		return result, fmt.Errorf("invalid auth token")
+	}
+	if input == nil || input.Name == nil || *input.Name == "" {
+		return result, fmt.Errorf("invalid trainer group name")
+	}
+
+	vars := map[string]interface{}{
+		"input": input,
+	}
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	createResult := kajiwotoCreateAITrainerGroupMutation{}
+	if errCreate := c.performGraphMutation(ctx, vars, &createResult); errCreate != nil {
+		return result, newError("create AI trainer group", errCreate)
+	}
+
+	// Build generic Result object
+	result = createResult.AITrainerGroup
+	return result, nil
+}
+
+// UpdateAITrainerGroup calls UpdateAITrainerGroupWithContext using a background context
+func (c *KajiwotoGraphQLClient) UpdateAITrainerGroup(aiTrainerGroupID, authToken string, input *AITrainerGroupInput) (result AITrainerGroup, err error) {
+	return c.UpdateAITrainerGroupWithContext(context.Background(), aiTrainerGroupID, authToken, input)
+}
+
+// UpdateAITrainerGroupWithContext changes the metadata of an AI trainer group; nil fields of input are left unchanged
+//
+// Experimental: the updateAITrainerGroup mutation is not verified against the live backend yet, see README.md.
+func (c *KajiwotoGraphQLClient) UpdateAITrainerGroupWithContext(ctx context.Context, aiTrainerGroupID, authToken string, input *AITrainerGroupInput) (result AITrainerGroup, err error) {
+	vars, errVars := aiTrainerGroupVars(aiTrainerGroupID, authToken)
+	if errVars != nil {
+		return result, errVars
+	}
+	if input == nil {
+		return result, fmt.Errorf("invalid trainer group input")
+	}
+	if input.Name != nil && *input.Name == "" {
+		return result, fmt.Errorf("invalid trainer group name")
+	}
+	vars["input"] = input
+
+	// Send Auth-Token header with this request only
+	ctx = withAuthToken(ctx, authToken)
+
+	updateResult := kajiwotoUpdateAITrainerGroupMutation{}
+	if errUpdate := c.performGraphMutation(ctx, vars, &updateResult); errUpdate != nil {
+		return result, newError("update AI trainer group", errUpdate)
+	}
+
+	// Build generic Result object
+	result = updateResult.AITrainerGroup
+	return result, nil
+}
+
+// CreateAITrainerGroup creates a new AI trainer group owned by the session's user
+func (s *Session) CreateAITrainerGroup(ctx context.Context, input *AITrainerGroupInput) (AITrainerGroup, error) {
+	return withSession(ctx, s, func(authToken string) (AITrainerGroup, error) {
+		return s.client.CreateAITrainerGroupWithContext(ctx, authToken, input)
+	})
+}
+
+// UpdateAITrainerGroup changes the metadata of an AI trainer group
+func (s *Session) UpdateAITrainerGroup(ctx context.Context, aiTrainerGroupID string, input *AITrainerGroupInput) (AITrainerGroup, error) {
+	return withSession(ctx, s, func(authToken string) (AITrainerGroup, error) {
+		return s.client.UpdateAITrainerGroupWithContext(ctx, aiTrainerGroupID, authToken, input)
+	})
+}
+
+// aiTrainerGroupVars validates the parameters of mutations on a single trainer group
+func aiTrainerGroupVars(aiTrainerGroupID, authToken string) (map[string]interface{}, error) {
+	// Sanity check
+	if authToken == "" {
+		return nil, fmt.Errorf("invalid auth token")
+	}
+	if aiTrainerGroupID == "" {
+		return nil, fmt.Errorf("invalid trainer group ID")
+	}
+	return map[string]interface{}{
+		"aiTrainerGroupId": gql.String(aiTrainerGroupID),
+	}, nil
+}
@@ -0,0 +1,311 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRetryMaxRetries is the number of retries per request if not configured otherwise
+	DefaultRetryMaxRetries = 3
+	// DefaultRetryBaseDelay is the backoff before the first retry if not configured otherwise; it doubles with every further retry
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+	// DefaultRetryMaxDelay caps the backoff between retries if not configured otherwise
+	DefaultRetryMaxDelay = 30 * time.Second
+)
+
+// Middleware wraps the transport of a KajiwotoGraphQLClient, e.g. to retry or throttle requests
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc allows using a function as http.RoundTripper
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// ClientOption configures a KajiwotoGraphQLClient
+type ClientOption func(config *clientConfig)
+
+type clientConfig struct {
+	baseTransport http.RoundTripper
+	middlewares   []Middleware
+}
+
+// WithBaseTransport sets the transport which finally sends the requests; http.DefaultTransport by default
+func WithBaseTransport(base http.RoundTripper) ClientOption {
+	return func(config *clientConfig) {
+		config.baseTransport = base
+	}
+}
+
+// WithMiddleware adds middlewares to the transport of the client.
+// Middlewares are applied in the given order, so the first one sees each request first.
+func WithMiddleware(middlewares ...Middleware) ClientOption {
+	return func(config *clientConfig) {
+		config.middlewares = append(config.middlewares, middlewares...)
+	}
+}
+
+// buildTransport chains the configured middlewares in front of the base transport
+func (config *clientConfig) buildTransport() http.RoundTripper {
+	transport := config.baseTransport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	for i := len(config.middlewares) - 1; i >= 0; i-- {
+		transport = config.middlewares[i](transport)
+	}
+	return transport
+}
+
+// RetryOptions configures RetryMiddleware
+type RetryOptions struct {
+	// MaxRetries is the number of retries per request; DefaultRetryMaxRetries if 0, no retries if < 0
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry; DefaultRetryBaseDelay if 0, no backoff if < 0
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff; DefaultRetryMaxDelay if 0, raised to BaseDelay if lower.
+	// Responses asking for a longer wait via Retry-After are returned to the caller instead of being retried.
+	MaxDelay time.Duration
+	// RetryMutations retries GraphQL mutations as well. A mutation whose response got lost is applied again
+	// by the retry, so only enable it if all mutations sent through the client are idempotent.
+	RetryMutations bool
+}
+
+// RetryMiddleware retries requests failing with a network error, a 5xx status or 429 Too Many Requests.
+// The backoff grows exponentially with jitter; a Retry-After header sent by the backend takes precedence.
+// GraphQL mutations are not retried unless RetryOptions.RetryMutations is set.
+func RetryMiddleware(options RetryOptions) Middleware {
+	if options.MaxRetries == 0 {
+		options.MaxRetries = DefaultRetryMaxRetries
+	}
+	if options.BaseDelay == 0 {
+		options.BaseDelay = DefaultRetryBaseDelay
+	} else if options.BaseDelay < 0 {
+		options.BaseDelay = 0
+	}
+	if options.MaxDelay == 0 {
+		options.MaxDelay = DefaultRetryMaxDelay
+	}
+	if options.MaxDelay < options.BaseDelay {
+		options.MaxDelay = options.BaseDelay
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return roundTripWithRetry(next, req, options)
+		})
+	}
+}
+
+func roundTripWithRetry(next http.RoundTripper, req *http.Request, options RetryOptions) (*http.Response, error) {
+	// The body has to be sent again for every attempt
+	getBody := req.GetBody
+	bufferedBody := false
+	if req.Body != nil && req.Body != http.NoBody && getBody == nil {
+		body, errRead := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if errRead != nil {
+			return nil, errRead
+		}
+		getBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+		bufferedBody = true
+	}
+	// A retry applies a mutation again if only its response got lost, so mutations are retried on request only
+	if options.MaxRetries > 0 && !options.RetryMutations && getBody != nil {
+		if body, errBody := getBody(); errBody == nil {
+			mutation := isMutation(body)
+			_ = body.Close()
+			if mutation {
+				options.MaxRetries = 0
+			}
+		}
+	}
+
+	backoff := options.BaseDelay
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if getBody != nil && (attempt > 0 || bufferedBody) {
+			body, errBody := getBody()
+			if errBody != nil {
+				return nil, errBody
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, errRoundTrip := next.RoundTrip(attemptReq)
+		if attempt >= options.MaxRetries || req.Context().Err() != nil || !shouldRetry(resp, errRoundTrip) {
+			return resp, errRoundTrip
+		}
+
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if retryAfter, ok := parseRetryAfter(resp); ok {
+			if retryAfter > options.MaxDelay {
+				return resp, errRoundTrip
+			}
+			delay = retryAfter
+		}
+		if resp != nil {
+			// Drain the body, so the connection can be reused
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		log.Debugf("Request failed, retrying in %v. Attempt %v of %v", delay, attempt+1, options.MaxRetries)
+		if errWait := sleep(req.Context(), delay); errWait != nil {
+			return nil, errWait
+		}
+		if backoff *= 2; backoff > options.MaxDelay {
+			backoff = options.MaxDelay
+		}
+	}
+}
+
+// isMutation checks whether body is the payload of a GraphQL mutation
+func isMutation(body io.Reader) bool {
+	payload := struct {
+		Query string `json:"query"`
+	}{}
+	if errDecode := json.NewDecoder(body).Decode(&payload); errDecode != nil {
+		return false
+	}
+	return strings.HasPrefix(strings.TrimSpace(payload.Query), "mutation")
+}
+
+// shouldRetry checks whether the outcome of a round trip is worth retrying
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// parseRetryAfter reads the Retry-After header, which holds either seconds or an HTTP date
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, errParse := strconv.Atoi(value); errParse == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, errParse := http.ParseTime(value); errParse == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
+
+// RateLimitMiddleware throttles requests using a token bucket which refills at requestsPerSecond
+// and holds up to burst tokens. Every client using the middleware gets its own bucket.
+func RateLimitMiddleware(requestsPerSecond float64, burst int) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		bucket := newTokenBucket(requestsPerSecond, burst)
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if errWait := bucket.wait(req.Context()); errWait != nil {
+				return nil, errWait
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// tokenBucket is a simple token bucket rate limiter
+type tokenBucket struct {
+	rate     float64 // Tokens per second
+	burst    float64
+	tokens   float64
+	last     time.Time
+	tokenMtx sync.Mutex
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until a token is available or ctx is done
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		delay := b.take()
+		if delay <= 0 {
+			return nil
+		}
+		if errWait := sleep(ctx, delay); errWait != nil {
+			return errWait
+		}
+	}
+}
+
+// take removes a token if one is available; otherwise it returns the time until the next token
+func (b *tokenBucket) take() time.Duration {
+	b.tokenMtx.Lock()
+	defer b.tokenMtx.Unlock()
+	if b.rate <= 0 {
+		return 0
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// sleep waits for the given duration or until ctx is done
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
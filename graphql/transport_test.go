@@ -0,0 +1,254 @@
+// Package graphql
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TransportTestSuite struct {
+	suite.Suite
+	server *httptest.Server
+	// Backend state
+	mtx       sync.Mutex
+	responses []func(w http.ResponseWriter) // Responses for the next requests; success once exhausted
+	bodies    []string
+	tokens    []string
+}
+
+func TestTransportTestSuite(t *testing.T) {
+	suite.Run(t, new(TransportTestSuite))
+}
+
+func (s *TransportTestSuite) SetupTest() {
+	s.responses = nil
+	s.bodies = nil
+	s.tokens = nil
+	s.server = httptest.NewServer(http.HandlerFunc(s.handleRequest))
+}
+
+func (s *TransportTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *TransportTestSuite) handleRequest(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mtx.Lock()
+	s.bodies = append(s.bodies, string(body))
+	s.tokens = append(s.tokens, r.Header.Get(headerAuthToken))
+	var respond func(w http.ResponseWriter)
+	if len(s.responses) > 0 {
+		respond, s.responses = s.responses[0], s.responses[1:]
+	}
+	s.mtx.Unlock()
+
+	if respond != nil {
+		respond(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"data":{"room":{"id":"c3d4"}}}`))
+}
+
+func respondStatus(statusCode int, retryAfter string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(statusCode)
+	}
+}
+
+func (s *TransportTestSuite) post(transport http.RoundTripper, ctx context.Context) (*http.Response, error) {
+	return s.postBody(transport, ctx, `{"query":"q"}`)
+}
+
+func (s *TransportTestSuite) postBody(transport http.RoundTripper, ctx context.Context, body string) (*http.Response, error) {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, s.server.URL, strings.NewReader(body))
+	return transport.RoundTrip(req)
+}
+
+func (s *TransportTestSuite) TestRetryServerErrors() {
+	s.responses = append(s.responses, respondStatus(http.StatusServiceUnavailable, ""), respondStatus(http.StatusTooManyRequests, ""))
+	transport := RetryMiddleware(RetryOptions{BaseDelay: time.Millisecond})(http.DefaultTransport)
+
+	resp, errPost := s.post(transport, context.Background())
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	// Body is sent with every attempt
+	assert.Equal(s.T(), []string{`{"query":"q"}`, `{"query":"q"}`, `{"query":"q"}`}, s.bodies)
+}
+
+func (s *TransportTestSuite) TestNoRetryOnClientErrors() {
+	s.responses = append(s.responses, respondStatus(http.StatusBadRequest, ""))
+	transport := RetryMiddleware(RetryOptions{BaseDelay: time.Millisecond})(http.DefaultTransport)
+
+	resp, errPost := s.post(transport, context.Background())
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusBadRequest, resp.StatusCode)
+	assert.Len(s.T(), s.bodies, 1)
+}
+
+func (s *TransportTestSuite) TestNoRetryOnMutations() {
+	mutation := `{"query":"mutation ($aiTrainerGroupId:String!){addToDataset(aiTrainerGroupId: $aiTrainerGroupId){count}}"}`
+	s.responses = append(s.responses, respondStatus(http.StatusServiceUnavailable, ""))
+	transport := RetryMiddleware(RetryOptions{BaseDelay: time.Millisecond})(http.DefaultTransport)
+
+	resp, errPost := s.postBody(transport, context.Background(), mutation)
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Len(s.T(), s.bodies, 1)
+
+	// Retrying mutations is opt-in
+	s.bodies = nil
+	s.responses = append(s.responses, respondStatus(http.StatusServiceUnavailable, ""))
+	transport = RetryMiddleware(RetryOptions{BaseDelay: time.Millisecond, RetryMutations: true})(http.DefaultTransport)
+	resp, errPost = s.postBody(transport, context.Background(), mutation)
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(s.T(), []string{mutation, mutation}, s.bodies)
+}
+
+func (s *TransportTestSuite) TestNegativeDelays() {
+	s.responses = append(s.responses, respondStatus(http.StatusServiceUnavailable, ""), respondStatus(http.StatusServiceUnavailable, ""))
+	transport := RetryMiddleware(RetryOptions{BaseDelay: -time.Second, MaxDelay: -time.Second})(http.DefaultTransport)
+
+	resp, errPost := s.post(transport, context.Background())
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	assert.Len(s.T(), s.bodies, 3)
+}
+
+func (s *TransportTestSuite) TestRetriesExhausted() {
+	for i := 0; i < 3; i++ {
+		s.responses = append(s.responses, respondStatus(http.StatusBadGateway, ""))
+	}
+	transport := RetryMiddleware(RetryOptions{MaxRetries: 2, BaseDelay: time.Millisecond})(http.DefaultTransport)
+
+	resp, errPost := s.post(transport, context.Background())
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusBadGateway, resp.StatusCode)
+	assert.Len(s.T(), s.bodies, 3)
+}
+
+func (s *TransportTestSuite) TestRetryAfter() {
+	// Retry-After takes precedence over the backoff
+	s.responses = append(s.responses, respondStatus(http.StatusTooManyRequests, "0"))
+	transport := RetryMiddleware(RetryOptions{BaseDelay: time.Minute, MaxDelay: time.Minute})(http.DefaultTransport)
+	start := time.Now()
+	resp, errPost := s.post(transport, context.Background())
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	assert.Less(s.T(), time.Since(start), 5*time.Second)
+
+	// Waiting longer than MaxDelay is left to the caller
+	s.bodies = nil
+	s.responses = append(s.responses, respondStatus(http.StatusTooManyRequests, "120"))
+	resp, errPost = s.post(transport, context.Background())
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusTooManyRequests, resp.StatusCode)
+	assert.Len(s.T(), s.bodies, 1)
+}
+
+func (s *TransportTestSuite) TestRetryNetworkError() {
+	attempts := 0
+	flaky := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if attempts++; attempts == 1 {
+			return nil, errors.New("connection reset by peer")
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	resp, errPost := s.post(RetryMiddleware(RetryOptions{BaseDelay: time.Millisecond})(flaky), context.Background())
+	assert.Nil(s.T(), errPost)
+	assert.Equal(s.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(s.T(), 2, attempts)
+}
+
+func (s *TransportTestSuite) TestRetryCancelledDuringBackoff() {
+	s.responses = append(s.responses, respondStatus(http.StatusServiceUnavailable, ""))
+	transport := RetryMiddleware(RetryOptions{BaseDelay: time.Minute})(http.DefaultTransport)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, errPost := s.post(transport, ctx)
+	assert.ErrorIs(s.T(), errPost, context.DeadlineExceeded)
+	assert.Len(s.T(), s.bodies, 1)
+}
+
+func (s *TransportTestSuite) TestRateLimit() {
+	transport := RateLimitMiddleware(20, 2)(http.DefaultTransport)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		resp, errPost := s.post(transport, context.Background())
+		assert.Nil(s.T(), errPost)
+		_ = resp.Body.Close()
+	}
+	// 2 requests of burst, 4 more at 20 per second
+	assert.GreaterOrEqual(s.T(), time.Since(start), 150*time.Millisecond)
+
+	// Waiting for a token honours the context
+	slow := RateLimitMiddleware(0.1, 1)(http.DefaultTransport)
+	resp, errPost := s.post(slow, context.Background())
+	assert.Nil(s.T(), errPost)
+	_ = resp.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, errPost = s.post(slow, ctx)
+	assert.ErrorIs(s.T(), errPost, context.DeadlineExceeded)
+}
+
+func (s *TransportTestSuite) TestMiddlewareOrder() {
+	order := make([]string, 0)
+	named := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	base := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		order = append(order, "base")
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	client := GetKajiwotoGraphQLClient(s.server.URL, WithBaseTransport(base), WithMiddleware(named("first"), named("second")))
+	_, errRoom := client.GetRoomWithContext(context.Background(), "c3d4", "", "token")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), []string{"first", "second", "base"}, order)
+}
+
+func (s *TransportTestSuite) TestClientWithRetry() {
+	s.responses = append(s.responses, respondStatus(http.StatusServiceUnavailable, ""))
+	client := GetKajiwotoGraphQLClient(s.server.URL, WithMiddleware(RetryMiddleware(RetryOptions{BaseDelay: time.Millisecond}), RateLimitMiddleware(100, 10)))
+
+	room, errRoom := client.GetRoomWithContext(context.Background(), "c3d4", "", "token")
+	assert.Nil(s.T(), errRoom)
+	assert.Equal(s.T(), "c3d4", fmt.Sprint(room.ID))
+	// Headers are sent with the retried request as well
+	assert.Equal(s.T(), []string{"token", "token"}, s.tokens)
+}
@@ -0,0 +1,145 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAckTimeout is the time SendMessageWithAck waits for the ack, unless ctx has a deadline
+	DefaultAckTimeout = 10 * time.Second
+)
+
+// ackState matches incoming acks to the senders waiting for them
+type ackState struct {
+	nextID  int
+	pending map[int]chan *KajiwotoWebSocketMessage
+	ackMtx  sync.Mutex
+}
+
+// register reserves a new ack ID and returns the channel its ack is delivered to
+func (a *ackState) register() (int, chan *KajiwotoWebSocketMessage) {
+	a.ackMtx.Lock()
+	defer a.ackMtx.Unlock()
+	if a.pending == nil {
+		a.pending = make(map[int]chan *KajiwotoWebSocketMessage)
+	}
+	ackID := a.nextID
+	a.nextID++
+	replyChannel := make(chan *KajiwotoWebSocketMessage, 1)
+	a.pending[ackID] = replyChannel
+	return ackID, replyChannel
+}
+
+// resolve delivers an ack to its sender; returns false if nobody waits for it
+func (a *ackState) resolve(message *KajiwotoWebSocketMessage) bool {
+	if message.MessageCode != SocketCodeMessageAck || message.AckID == nil {
+		return false
+	}
+	a.ackMtx.Lock()
+	defer a.ackMtx.Unlock()
+	replyChannel, ok := a.pending[*message.AckID]
+	if !ok {
+		return false
+	}
+	delete(a.pending, *message.AckID)
+	replyChannel <- message
+	return true
+}
+
+// forget stops waiting for an ack
+func (a *ackState) forget(ackID int) {
+	a.ackMtx.Lock()
+	delete(a.pending, ackID)
+	a.ackMtx.Unlock()
+}
+
+// failAll stops all senders waiting for an ack, since their connection is gone
+func (a *ackState) failAll() {
+	a.ackMtx.Lock()
+	defer a.ackMtx.Unlock()
+	for ackID, replyChannel := range a.pending {
+		close(replyChannel)
+		delete(a.pending, ackID)
+	}
+}
+
+// SendMessageWithAck sends an event message with a new ack ID and waits for the ack of the server.
+// The returned ack message holds the arguments of the ack as JSON array in MessageContent.
+// If ctx has no deadline, DefaultAckTimeout applies; ErrAckTimeout is returned if the ack isn't received in time.
+// The given message is not modified.
+func (c *KajiwotoWebSocketClient) SendMessageWithAck(ctx context.Context, message *KajiwotoWebSocketMessage) (*KajiwotoWebSocketMessage, error) {
+	if message.MessageCode != SocketCodeMessageEvent {
+		return nil, fmt.Errorf("acks are only supported for event messages, message code was %v", message.MessageCode)
+	}
+	if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultAckTimeout)
+		defer cancel()
+	}
+
+	ackID, replyChannel := c.acks.register()
+	ackMessage := *message
+	ackMessage.AckID = &ackID
+	if errSend := c.SendMessageWithContext(ctx, &ackMessage); errSend != nil {
+		c.acks.forget(ackID)
+		return nil, errSend
+	}
+
+	select {
+	case reply, ok := <-replyChannel:
+		if !ok {
+			return nil, ErrNotConnected
+		}
+		return reply, nil
+	case <-ctx.Done():
+		c.acks.forget(ackID)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: ack id %v", ErrAckTimeout, ackID)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// SendAck calls SendAckWithContext using a background context
+func (c *KajiwotoWebSocketClient) SendAck(request *KajiwotoWebSocketMessage, data ...interface{}) error {
+	return c.SendAckWithContext(context.Background(), request, data...)
+}
+
+// SendAckWithContext answers a message of the server which carries an ack ID; data is sent as the arguments of the ack.
+// Use it in a message handler; returns ErrNoAckRequested if the server did not ask for an ack.
+func (c *KajiwotoWebSocketClient) SendAckWithContext(ctx context.Context, request *KajiwotoWebSocketMessage, data ...interface{}) error {
+	if request.AckID == nil {
+		return ErrNoAckRequested
+	}
+	if data == nil {
+		data = []interface{}{}
+	}
+	ackID := *request.AckID
+	ackMessage := &KajiwotoWebSocketMessage{
+		MessageCode:    SocketCodeMessageAck,
+		Namespace:      request.Namespace,
+		AckID:          &ackID,
+		MessageContent: data,
+	}
+	return c.SendMessageWithContext(ctx, ackMessage)
+}
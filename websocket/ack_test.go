@@ -0,0 +1,130 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type WebSocketAckTestSuite struct {
+	suite.Suite
+	server     *testServer
+	client     *KajiwotoWebSocketClient
+	serverConn *testServerConn
+}
+
+func TestWebSocketAckTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketAckTestSuite))
+}
+
+func (s *WebSocketAckTestSuite) SetupTest() {
+	s.server = newTestServer()
+	s.client = GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), s.client.Connect())
+	s.serverConn = s.server.nextConnection()
+}
+
+func (s *WebSocketAckTestSuite) TearDownTest() {
+	assert.Nil(s.T(), s.client.Close(context.Background()))
+	s.server.Close()
+}
+
+func (s *WebSocketAckTestSuite) helperEvent() *KajiwotoWebSocketMessage {
+	return CreateKajiwotoWebSocketEventMessage(&KajiwotoRPCChatLeaveMessage{ChatRoom: KajiwotoRPCChatRoomId{ChatRoomId: "c3d4"}})
+}
+
+func (s *WebSocketAckTestSuite) TestSendMessageWithAck() {
+	type result struct {
+		reply *KajiwotoWebSocketMessage
+		err   error
+	}
+	results := make(chan result, 2)
+	message := s.helperEvent()
+	for i := 0; i < 2; i++ {
+		go func() {
+			reply, errAck := s.client.SendMessageWithAck(context.Background(), message)
+			results <- result{reply, errAck}
+		}()
+		// Wait for the event, so the ack IDs are assigned in order
+		_, sent := s.serverConn.expect(`42` + string(rune('0'+i)) + `["chatLeave"`)
+		assert.True(s.T(), sent)
+	}
+	assert.Nil(s.T(), message.AckID)
+
+	// Answer in reverse order, with an unknown ack in between
+	assert.Nil(s.T(), s.serverConn.send(`431[{"left":"second"}]`))
+	assert.Nil(s.T(), s.serverConn.send(`4399[]`))
+	assert.Nil(s.T(), s.serverConn.send(`430[{"left":"first"}]`))
+	for _, expected := range []string{"second", "first"} {
+		received := <-results
+		assert.Nil(s.T(), received.err)
+		if assert.NotNil(s.T(), received.reply) {
+			data := make([]map[string]string, 0)
+			assert.Nil(s.T(), json.Unmarshal(received.reply.MessageContent.([]byte), &data))
+			assert.Equal(s.T(), expected, data[0]["left"])
+		}
+	}
+}
+
+func (s *WebSocketAckTestSuite) TestSendMessageWithAckTimeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, errAck := s.client.SendMessageWithAck(ctx, s.helperEvent())
+	assert.ErrorIs(s.T(), errAck, ErrAckTimeout)
+
+	// A late ack is ignored
+	assert.Nil(s.T(), s.serverConn.send(`430[]`))
+	s.client.acks.ackMtx.Lock()
+	assert.Empty(s.T(), s.client.acks.pending)
+	s.client.acks.ackMtx.Unlock()
+
+	_, errAck = s.client.SendMessageWithAck(context.Background(), &KajiwotoWebSocketMessage{MessageCode: SocketCodePing})
+	assert.NotNil(s.T(), errAck)
+}
+
+func (s *WebSocketAckTestSuite) TestSendMessageWithAckConnectionClosed() {
+	results := make(chan error, 1)
+	go func() {
+		_, errAck := s.client.SendMessageWithAck(context.Background(), s.helperEvent())
+		results <- errAck
+	}()
+	_, sent := s.serverConn.expect(`420["chatLeave"`)
+	assert.True(s.T(), sent)
+	assert.Nil(s.T(), s.client.Close(context.Background()))
+	assert.ErrorIs(s.T(), <-results, ErrNotConnected)
+}
+
+func (s *WebSocketAckTestSuite) TestSendAck() {
+	s.client.AddMessageHandler(func(message *KajiwotoWebSocketMessage) error {
+		if message.MessageCode != SocketCodeMessageEvent {
+			return ErrUnableToHandleMessage
+		}
+		return s.client.SendAck(message, map[string]bool{"received": true})
+	}, false)
+
+	assert.Nil(s.T(), s.serverConn.send(`425["typing",{"chatRoomId":"c3d4"}]`))
+	ack, received := s.serverConn.expect(SocketCodeMessageAck)
+	assert.True(s.T(), received)
+	assert.Equal(s.T(), `435[{"received":true}]`, ack)
+
+	assert.ErrorIs(s.T(), s.client.SendAck(&KajiwotoWebSocketMessage{MessageCode: SocketCodeMessageEvent}), ErrNoAckRequested)
+}
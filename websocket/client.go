@@ -19,6 +19,7 @@ package websocket
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
@@ -49,11 +50,24 @@ type KajiwotoWebSocketClient struct {
 	wsConn        *websocket.Conn
 	options       *websocket.DialOptions
 	socketID      string
+	openPacket    *KajiwotoWebSocketOpenPacket
+	namespaces    map[string]string // Socket IDs of the connected namespaces besides the default one
+	pingTimer     *time.Timer       // Detects a dead server if it stops sending pings
+	connMtx       sync.RWMutex      // Guards wsConn, socketID, openPacket, namespaces and pingTimer, which are replaced when reconnecting
+	connLost      atomic.Bool       // Set by the listener when the connection failed
+	pingTimedOut  atomic.Bool       // Set if the connection was given up, because no ping was received in time
 	listen        atomic.Bool
 	listenCtx     context.Context
 	listenCtxStop context.CancelFunc
+	listenDone    chan struct{} // Closed when the listener goroutine exits
 	handlers      map[string]*MessageHandler
 	handlerMtx    sync.RWMutex
+	acks          ackState // Senders waiting for an ack of the server
+	// Keys of the handlers added by AddDefaultHandlers, removed again on Close
+	defaultHandlerKeys []string
+	// Reconnect Handling
+	reconnect reconnectState
+	session   sessionState
 }
 
 func GetKajiwotoWebSocketClient(endpoint, apiKey string) *KajiwotoWebSocketClient {
@@ -67,92 +81,305 @@ func GetKajiwotoWebSocketClient(endpoint, apiKey string) *KajiwotoWebSocketClien
 }
 
 func (c *KajiwotoWebSocketClient) Connect() error {
-	if c.wsConn != nil {
+	return c.connect(context.Background())
+}
+
+// connect dials the backend and authenticates; ctx aborts the handshake
+func (c *KajiwotoWebSocketClient) connect(ctx context.Context) error {
+	if c.conn() != nil {
 		return errors.New("client is already connected")
 	}
 	// Dial Backend using client config
-	conn, _, errClient := websocket.Dial(context.Background(), c.endpoint, c.options)
+	conn, _, errClient := websocket.Dial(ctx, c.endpoint, c.options)
 	if errClient != nil {
 		return errClient
 	}
 	// Update conn reference
+	c.connMtx.Lock()
 	c.wsConn = conn
+	c.connMtx.Unlock()
+	c.connLost.Store(false)
 
 	// Get Welcome message for initial handshake
-	msgType, data, errWelcome := c.wsConn.Read(context.Background())
+	msgType, data, errWelcome := conn.Read(ctx)
 	if errWelcome != nil {
+		c.abortConnect()
 		return errWelcome
 	}
 
 	// Check if Server responded with welcome message
 	if strconv.Itoa(int(msgType)) != DataFrameText {
+		c.abortConnect()
 		return fmt.Errorf("server did not respond with text frame. Message was: (%v)[%v]", msgType, string(data))
 	}
+	openPacket, errOpen := parseOpenPacket(data)
+	if errOpen != nil {
+		c.abortConnect()
+		return errOpen
+	}
+	c.connMtx.Lock()
+	c.openPacket = openPacket
+	c.connMtx.Unlock()
+	log.Debugf("Engine.IO session %v opened. Ping interval: %vms, ping timeout: %vms, max payload: %v bytes",
+		openPacket.Sid, openPacket.PingInterval, openPacket.PingTimeout, openPacket.MaxPayload)
 
 	// Add Default Handlers
 	c.AddDefaultHandlers()
 	// Set WS Connection to listen for incoming messages
 	c.StartListeningToMessages()
+	c.startPingTimer(openPacket.HeartbeatTimeout(), c.listenCtxStop)
 
+	// Authenticate against the default namespace
+	sid, errAuth := c.authenticate(ctx, DefaultNamespace)
+	if errAuth != nil {
+		c.abortConnect()
+		return errAuth
+	}
+	c.connMtx.Lock()
+	c.socketID = sid
+	c.connMtx.Unlock()
+	log.Debugf("Assigned Socket ID: %v", sid)
+	return nil
+}
+
+// authenticate connects to a namespace using the API key and returns the socket ID assigned by the server
+func (c *KajiwotoWebSocketClient) authenticate(ctx context.Context, namespace string) (string, error) {
 	// Add Auth Response Handler and wit for Auth to be confirmed
 	authChannel := make(chan *KaiwotoWebSocketAuthResponse, 1)
-	c.AddMessageHandler(NewKajiwotoWebSocketAuthResponseHandler(c, authChannel), true)
+	authHandlerKey := c.AddMessageHandler(newNamespaceAuthResponseHandler(namespace, authChannel), true)
 
 	// Send API Key to authenticate against the Kajiwoto websocket backend
 	authMessage := &KajiwotoWebSocketMessage{
 		MessageCode: SocketCodeMessageConnect,
+		Namespace:   namespace,
 		MessageContent: &KaiwotoWebSocketAuthRequest{
 			ApiKey: c.apiKey,
 		},
 	}
-	if errAuth := c.SendMessage(authMessage); errAuth != nil {
-		return errAuth
+	if errAuth := c.SendMessageWithContext(ctx, authMessage); errAuth != nil {
+		c.RemoveMessageHandler(authHandlerKey)
+		return "", errAuth
 	}
 
 	// Wait for Auth channel to return socket ID as confirmation of successful login, or timeout is hit
 	connectTimeout := time.NewTimer(time.Second * 5)
-	for {
-		select {
-		case authResponse := <-authChannel:
-			// Check if Socket ID was set
-			if len(authResponse.Sid) > 0 {
-				c.socketID = authResponse.Sid
-				log.Debugf("Assigned Socket ID: %v", c.socketID)
-				return nil
-			}
-			// In any other case, error
-			c.StopListeningToMessages()
-			c.RemoveAllMessageHandlers()
-			return fmt.Errorf("server returned invalid auth message result: %+v", authResponse)
-		case <-connectTimeout.C:
-			c.StopListeningToMessages()
-			c.RemoveAllMessageHandlers()
-			return errors.New("connection timeout")
+	defer connectTimeout.Stop()
+	select {
+	case authResponse := <-authChannel:
+		// Check if Socket ID was set
+		if len(authResponse.Sid) > 0 {
+			return authResponse.Sid, nil
 		}
+		// In any other case, error
+		return "", fmt.Errorf("server returned invalid auth message result: %+v", authResponse)
+	case <-connectTimeout.C:
+		c.RemoveMessageHandler(authHandlerKey)
+		return "", errors.New("connection timeout")
+	case <-ctx.Done():
+		c.RemoveMessageHandler(authHandlerKey)
+		return "", ctx.Err()
+	}
+}
+
+// parseOpenPacket decodes the Engine.IO open packet the server sends as welcome message
+func parseOpenPacket(data []byte) (*KajiwotoWebSocketOpenPacket, error) {
+	message := &KajiwotoWebSocketMessage{}
+	if errMessage := message.FromBytes(data); errMessage != nil {
+		return nil, errMessage
+	}
+	content, hasContent := message.MessageContent.([]byte)
+	if message.MessageCode != SocketCodeOpen || !hasContent {
+		return nil, fmt.Errorf("server did not respond with open packet. Message was: %v", string(data))
+	}
+	openPacket := &KajiwotoWebSocketOpenPacket{}
+	if errUnmarshal := json.Unmarshal(content, openPacket); errUnmarshal != nil {
+		return nil, fmt.Errorf("unable to parse open packet: %w", errUnmarshal)
+	}
+	return openPacket, nil
+}
+
+// startPingTimer gives the connection up using stopListening if the server sends no ping within timeout.
+// The timer is reset by the listener for every ping; no timer is started if timeout is 0.
+func (c *KajiwotoWebSocketClient) startPingTimer(timeout time.Duration, stopListening context.CancelFunc) {
+	c.pingTimedOut.Store(false)
+	if timeout <= 0 {
+		return
+	}
+	timer := time.AfterFunc(timeout, func() {
+		log.Errorf("No ping received from server within %v, closing connection", timeout)
+		c.pingTimedOut.Store(true)
+		// Cancelling the read of the listener closes the connection, which the listener handles as connection loss
+		stopListening()
+	})
+	c.connMtx.Lock()
+	c.pingTimer = timer
+	c.connMtx.Unlock()
+}
+
+// resetPingTimer restarts the ping timeout after a ping was received
+func (c *KajiwotoWebSocketClient) resetPingTimer() {
+	c.connMtx.RLock()
+	defer c.connMtx.RUnlock()
+	if c.pingTimer != nil && c.openPacket != nil {
+		c.pingTimer.Reset(c.openPacket.HeartbeatTimeout())
+	}
+}
+
+// OpenPacket returns the Engine.IO handshake of the current connection, or nil if the client is not connected
+func (c *KajiwotoWebSocketClient) OpenPacket() *KajiwotoWebSocketOpenPacket {
+	c.connMtx.RLock()
+	defer c.connMtx.RUnlock()
+	if c.openPacket == nil {
+		return nil
+	}
+	openPacket := *c.openPacket
+	return &openPacket
+}
+
+// abortConnect closes the connection after a failed handshake, so Connect can be called again
+func (c *KajiwotoWebSocketClient) abortConnect() {
+	if errClose := c.closeConnection(context.Background()); errClose != nil {
+		log.Debugf("Unable to close connection after failed handshake. Error: %v", errClose)
 	}
 }
 
 func (c *KajiwotoWebSocketClient) IsConnected() bool {
-	return c.wsConn != nil && len(c.socketID) > 0
+	c.connMtx.RLock()
+	defer c.connMtx.RUnlock()
+	return c.wsConn != nil && len(c.socketID) > 0 && !c.connLost.Load()
+}
+
+// conn returns the current connection, or nil if the client is not connected
+func (c *KajiwotoWebSocketClient) conn() *websocket.Conn {
+	c.connMtx.RLock()
+	defer c.connMtx.RUnlock()
+	return c.wsConn
+}
+
+// Close leaves the Socket.IO namespace, closes the websocket with a normal closure status and waits for the
+// listener to exit. Message handlers added by the caller are kept, so the client can be connected again.
+// A running reconnect is stopped, and the recorded session is discarded.
+// If ctx is done before the close handshake finished, the connection is closed forcefully and ctx.Err() is returned;
+// other errors of the close handshake are only logged, since the connection is closed anyway.
+// Closing a client which is not connected does nothing.
+func (c *KajiwotoWebSocketClient) Close(ctx context.Context) error {
+	c.suppressReconnect(true)
+	defer c.suppressReconnect(false)
+	c.stopReconnect()
+	c.session.reset()
+	return c.closeConnection(ctx)
+}
+
+// closeConnection closes the connection and resets the connection state, but keeps the session
+func (c *KajiwotoWebSocketClient) closeConnection(ctx context.Context) error {
+	c.connMtx.RLock()
+	conn, socketID := c.wsConn, c.socketID
+	c.connMtx.RUnlock()
+	if conn == nil {
+		return nil
+	}
+
+	// Mark listener as stopped, but keep its read running, so it receives the close frame of the server.
+	// Cancelling the read would close the connection without handshake.
+	c.listen.Store(false)
+
+	// Leave the namespaces gracefully if they were joined, the default namespace last
+	if len(socketID) > 0 {
+		for _, namespace := range append(c.connectedNamespaces(), DefaultNamespace) {
+			disconnectMessage := &KajiwotoWebSocketMessage{
+				MessageCode: SocketCodeMessageDisconnect,
+				Namespace:   namespace,
+			}
+			if errDisconnect := c.SendMessageWithContext(ctx, disconnectMessage); errDisconnect != nil {
+				log.Debugf("Unable to send disconnect message for namespace %v. Error: %v", namespace, errDisconnect)
+			}
+		}
+	}
+
+	closeResult := make(chan error, 1)
+	go func(conn *websocket.Conn) {
+		closeResult <- conn.Close(websocket.StatusNormalClosure, "")
+	}(conn)
+	select {
+	case errClose := <-closeResult:
+		// The connection is closed in any case, e.g. if it was already lost, so this is no failure
+		if errClose != nil {
+			log.Debugf("Close handshake failed. Error: %v", errClose)
+		}
+	case <-ctx.Done():
+	}
+
+	if c.listenDone != nil {
+		// A cancelled read closes the connection immediately, in case the handshake didn't finish
+		c.listenCtxStop()
+		<-c.listenDone
+		c.listenCtx = nil
+		c.listenDone = nil
+	}
+
+	for _, handlerKey := range c.defaultHandlerKeys {
+		c.RemoveMessageHandler(handlerKey)
+	}
+	c.defaultHandlerKeys = nil
+	c.acks.failAll()
+	c.connMtx.Lock()
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+	}
+	c.wsConn = nil
+	c.socketID = ""
+	c.openPacket = nil
+	c.namespaces = nil
+	c.pingTimer = nil
+	c.connMtx.Unlock()
+	return ctx.Err()
 }
 
 // AddDefaultHandlers
 // ensures all basic handlers required to operate the WebSocket Client long term are set up and added to the client.
 func (c *KajiwotoWebSocketClient) AddDefaultHandlers() {
 	// Ping Handler
-	c.AddMessageHandler(NewKajiwotoWebSocketPingHandler(c), false)
+	c.defaultHandlerKeys = append(c.defaultHandlerKeys, c.AddMessageHandler(NewKajiwotoWebSocketPingHandler(c), false))
 }
 
 func (c *KajiwotoWebSocketClient) StartListeningToMessages() {
 	// Start goroutine to handle incoming messages if it's not active
 	if c.listen.CompareAndSwap(false, true) {
 		c.listenCtx, c.listenCtxStop = context.WithCancel(context.Background())
-		go func(c *KajiwotoWebSocketClient) {
+		c.listenDone = make(chan struct{})
+		go func(c *KajiwotoWebSocketClient, ctx context.Context, done chan struct{}) {
+			defer close(done)
 			log.Debugf("Listening to incoming messages...")
 			for c.listen.Load() {
-				message, errRead := c.ReadMessage(c.listenCtx)
+				message, connectionLost, errRead := c.readMessage(ctx)
 				if errRead != nil {
+					if !c.listen.Load() {
+						// Read was interrupted by closing the connection
+						break
+					}
 					log.Errorf("error reading websocket messages. Error: %v", errRead.Error())
+					if connectionLost {
+						// Reading again would fail forever; give the connection up and reconnect if enabled
+						c.connLost.Store(true)
+						c.listen.Store(false)
+						if c.pingTimedOut.Load() {
+							errRead = ErrPingTimeout
+						}
+						c.reconnectAfter(errRead)
+						break
+					}
+					continue
+				}
+
+				if message.MessageCode == SocketCodePing {
+					c.resetPingTimer()
+				}
+				if message.MessageCode == SocketCodeMessageDisconnect && !message.IsDefaultNamespace() {
+					log.Debugf("Server disconnected namespace %v", message.Namespace)
+					c.removeNamespace(message.Namespace)
+				}
+				if c.acks.resolve(message) {
+					// Acks are delivered to the sender waiting for it only
 					continue
 				}
 
@@ -170,7 +397,7 @@ func (c *KajiwotoWebSocketClient) StartListeningToMessages() {
 				c.handlerMtx.RUnlock()
 			}
 			log.Debugf("Stopped listening to incoming messages.")
-		}(c)
+		}(c, c.listenCtx, c.listenDone)
 	}
 }
 
@@ -209,38 +436,68 @@ func (c *KajiwotoWebSocketClient) RemoveAllMessageHandlers() {
 }
 
 func (c *KajiwotoWebSocketClient) SendMessage(message *KajiwotoWebSocketMessage) error {
+	return c.SendMessageWithContext(context.Background(), message)
+}
+
+// SendMessageWithContext sends a message; ctx limits the time spent writing it
+func (c *KajiwotoWebSocketClient) SendMessageWithContext(ctx context.Context, message *KajiwotoWebSocketMessage) error {
+	c.connMtx.RLock()
+	conn, openPacket := c.wsConn, c.openPacket
+	_, namespaceConnected := c.namespaces[message.Namespace]
+	c.connMtx.RUnlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	if !message.IsDefaultNamespace() && !namespaceConnected && message.MessageCode != SocketCodeMessageConnect {
+		return fmt.Errorf("%w: %v", ErrNamespaceNotConnected, message.Namespace)
+	}
 	bytes, errMessage := message.ToBytes()
 	if errMessage != nil {
 		return errMessage
 	}
-	// TODO: better context handling here
+	if openPacket != nil && openPacket.MaxPayload > 0 && len(bytes) > openPacket.MaxPayload {
+		return fmt.Errorf("%w: message has %v bytes, server accepts %v", ErrMessageTooLarge, len(bytes), openPacket.MaxPayload)
+	}
 	log.Debugf("Sending message: %v", string(bytes))
-	if errWrite := c.wsConn.Write(context.Background(), 1, bytes); errWrite != nil {
+	if errWrite := conn.Write(ctx, websocket.MessageText, bytes); errWrite != nil {
 		return errWrite
 	}
+	// Remember the messages which need to be repeated after reconnecting
+	c.session.record(message)
 	return nil
 }
 
 func (c *KajiwotoWebSocketClient) ReadMessage(ctx context.Context) (*KajiwotoWebSocketMessage, error) {
+	message, _, errRead := c.readMessage(ctx)
+	return message, errRead
+}
+
+// readMessage reads the next message; connectionLost reports whether the error ended the connection
+func (c *KajiwotoWebSocketClient) readMessage(ctx context.Context) (message *KajiwotoWebSocketMessage, connectionLost bool, err error) {
 	// Ensure this is only called once
 	if c.listenCtx != nil && c.listenCtx != ctx {
-		return nil, fmt.Errorf("client is already listening for new messages. Stop listening to manually handle reads")
+		return nil, false, fmt.Errorf("client is already listening for new messages. Stop listening to manually handle reads")
+	}
+	conn := c.conn()
+	if conn == nil {
+		return nil, false, ErrNotConnected
 	}
 
-	msgType, data, errAPIResponse := c.wsConn.Read(ctx)
+	msgType, data, errAPIResponse := conn.Read(ctx)
 	if errAPIResponse != nil {
-		return nil, errAPIResponse
+		// The websocket library closes the connection on any read error
+		return nil, true, errAPIResponse
 	}
 	// Check if Server responded with valid message
 	if strconv.Itoa(int(msgType)) != DataFrameText {
-		return nil, fmt.Errorf("server did not respond with text frame. Message was: (%v)[%v]", msgType, string(data))
+		return nil, false, fmt.Errorf("server did not respond with text frame. Message was: (%v)[%v]", msgType, string(data))
 	}
 	log.Debugf("Received message: %v", string(data))
-	message := &KajiwotoWebSocketMessage{}
+	message = &KajiwotoWebSocketMessage{}
 	if errMessage := message.FromBytes(data); errMessage != nil {
-		return nil, errMessage
+		return nil, false, errMessage
 	}
-	return message, nil
+	return message, false, nil
 }
 
 // BuildLocalUserTime is sent whenever the backend needs to know the current time at the location of the user
@@ -0,0 +1,113 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"nhooyr.io/websocket"
+	"testing"
+	"time"
+)
+
+type WebSocketCloseTestSuite struct {
+	suite.Suite
+	server *testServer
+}
+
+func TestWebSocketCloseTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketCloseTestSuite))
+}
+
+func (s *WebSocketCloseTestSuite) SetupTest() {
+	s.server = newTestServer()
+}
+
+func (s *WebSocketCloseTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *WebSocketCloseTestSuite) TestClose() {
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), client.Connect())
+	serverConn := s.server.nextConnection()
+	assert.True(s.T(), client.IsConnected())
+	userHandler := client.AddMessageHandler(func(message *KajiwotoWebSocketMessage) error {
+		return ErrUnableToHandleMessage
+	}, false)
+
+	assert.Nil(s.T(), client.Close(context.Background()))
+	_, disconnected := serverConn.expect(SocketCodeMessageDisconnect)
+	assert.True(s.T(), disconnected)
+	assert.Equal(s.T(), websocket.StatusNormalClosure, serverConn.waitClosed())
+	assert.False(s.T(), client.IsConnected())
+	assert.Empty(s.T(), client.socketID)
+	assert.False(s.T(), client.listen.Load())
+	// Only the handlers added by the client itself are removed
+	client.handlerMtx.RLock()
+	assert.Len(s.T(), client.handlers, 1)
+	assert.Contains(s.T(), client.handlers, userHandler)
+	client.handlerMtx.RUnlock()
+
+	// Closing twice does nothing
+	assert.Nil(s.T(), client.Close(context.Background()))
+}
+
+func (s *WebSocketCloseTestSuite) TestReconnectAfterClose() {
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), client.Connect())
+	assert.Equal(s.T(), "socket-sid-1", client.socketID)
+	assert.Nil(s.T(), client.Close(context.Background()))
+
+	assert.Nil(s.T(), client.Connect())
+	assert.Equal(s.T(), "socket-sid-2", client.socketID)
+
+	// The new connection is served by the listener, with a single ping handler
+	s.server.nextConnection()
+	serverConn := s.server.nextConnection()
+	assert.Nil(s.T(), serverConn.send(SocketCodePing))
+	_, pong := serverConn.expect(SocketCodePong)
+	assert.True(s.T(), pong)
+	assert.Len(s.T(), client.defaultHandlerKeys, 1)
+	assert.Nil(s.T(), client.Close(context.Background()))
+}
+
+func (s *WebSocketCloseTestSuite) TestCloseAfterFailedConnect() {
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "invalid")
+	assert.NotNil(s.T(), client.Connect())
+	assert.Nil(s.T(), client.wsConn)
+	assert.False(s.T(), client.listen.Load())
+
+	// Client can be connected again
+	client.apiKey = "key"
+	assert.Nil(s.T(), client.Connect())
+	assert.True(s.T(), client.IsConnected())
+	assert.Nil(s.T(), client.Close(context.Background()))
+}
+
+func (s *WebSocketCloseTestSuite) TestCloseTimeout() {
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), client.Connect())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	assert.ErrorIs(s.T(), client.Close(ctx), context.Canceled)
+	assert.Less(s.T(), time.Since(start), time.Second)
+	assert.False(s.T(), client.IsConnected())
+	assert.False(s.T(), client.listen.Load())
+}
@@ -25,25 +25,27 @@ import (
  * use these as inspiration for your own handler implementations when working with the SDK
  */
 
-// NewKajiwotoWebSocketAuthResponseHandler is used to handle an auth message response
+// NewKajiwotoWebSocketAuthResponseHandler is used to handle an auth message response of the default namespace
 func NewKajiwotoWebSocketAuthResponseHandler(c *KajiwotoWebSocketClient, responseChannel chan *KaiwotoWebSocketAuthResponse) MessageHandlerFunc {
+	return newNamespaceAuthResponseHandler(DefaultNamespace, responseChannel)
+}
+
+// newNamespaceAuthResponseHandler is used to handle the auth message response of a namespace
+func newNamespaceAuthResponseHandler(namespace string, responseChannel chan *KaiwotoWebSocketAuthResponse) MessageHandlerFunc {
 	return func(message *KajiwotoWebSocketMessage) error {
-		if message.MessageCode == SocketCodeMessageConnect {
-			// Try to umarshall into required response
-			// If this won't work, message is not of expected type
-			response := &KaiwotoWebSocketAuthResponse{}
-			if errUnmarshall := json.Unmarshal(message.MessageContent.([]byte), response); errUnmarshall != nil {
-				return errUnmarshall
-			}
-			responseChannel <- response
-			return nil
-		} else if message.MessageCode == SocketCodeMessageError {
+		if !sameNamespace(message.Namespace, namespace) {
+			return ErrUnableToHandleMessage
+		}
+		if message.MessageCode == SocketCodeMessageConnect || message.MessageCode == SocketCodeMessageError {
 			// Try to umarshall into required response
 			// If this won't work, message is not of expected type
 			response := &KaiwotoWebSocketAuthResponse{}
-			if errUnmarshall := json.Unmarshal(message.MessageContent.([]byte), response); errUnmarshall != nil {
-				return errUnmarshall
+			if content, hasContent := message.MessageContent.([]byte); hasContent {
+				if errUnmarshall := json.Unmarshal(content, response); errUnmarshall != nil {
+					return errUnmarshall
+				}
 			}
+			// Responses without content carry no socket ID, so they are rejected as failed auth
 			responseChannel <- response
 			return nil
 		}
@@ -68,3 +70,40 @@ func NewKajiwotoWebSocketPingHandler(c *KajiwotoWebSocketClient) MessageHandlerF
 		return ErrUnableToHandleMessage
 	}
 }
+
+// DefaultRoomChangeActions are the chatActivity actions after which NewKajiwotoWebSocketRoomChangeHandler
+// considers the data of a room changed: a user joining, or the kaji's pet data being updated with a pet message
+var DefaultRoomChangeActions = []string{ChatActivityJoinRoom, ChatActivityPetMessage}
+
+// NewKajiwotoWebSocketRoomChangeHandler calls onRoomChange with the chat room ID of every chatActivity event
+// with one of the given actions, or one of DefaultRoomChangeActions if none are given.
+// Use it to evict cached room data, e.g. with graphql.CachedClient.InvalidateRoom.
+func NewKajiwotoWebSocketRoomChangeHandler(onRoomChange func(chatRoomID string), actions ...string) MessageHandlerFunc {
+	if len(actions) == 0 {
+		actions = DefaultRoomChangeActions
+	}
+	return func(message *KajiwotoWebSocketMessage) error {
+		if message.MessageCode != SocketCodeMessageEvent {
+			return ErrUnableToHandleMessage
+		}
+		rpcMessage := &KaiwotoRPCBaseMessage{}
+		if errDeserialize := rpcMessage.Deserialize(message.MessageContent); errDeserialize != nil {
+			return errDeserialize
+		}
+		activityMessage := &KajiwotoRPCChatActivityMessage{}
+		if !activityMessage.FromRPCBaseMessage(rpcMessage) {
+			return ErrUnableToHandleMessage
+		}
+		activity := activityMessage.ActivityData.Data
+		if activity.ChatRoomId == "" {
+			return ErrUnableToHandleMessage
+		}
+		for _, action := range actions {
+			if activity.Action == action {
+				onRoomChange(activity.ChatRoomId)
+				return nil
+			}
+		}
+		return ErrUnableToHandleMessage
+	}
+}
@@ -0,0 +1,84 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type WebSocketHandlersTestSuite struct {
+	suite.Suite
+}
+
+func TestWebSocketHandlersTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketHandlersTestSuite))
+}
+
+func (s *WebSocketHandlersTestSuite) helperMessage(messageString string) *KajiwotoWebSocketMessage {
+	wsMessage := &KajiwotoWebSocketMessage{}
+	assert.Nil(s.T(), wsMessage.FromBytes([]byte(messageString)))
+	return wsMessage
+}
+
+func (s *WebSocketHandlersTestSuite) TestRoomChangeHandler() {
+	changed := make([]string, 0)
+	handler := NewKajiwotoWebSocketRoomChangeHandler(func(chatRoomID string) {
+		changed = append(changed, chatRoomID)
+	})
+
+	assert.Nil(s.T(), handler(s.helperMessage("42[\"chatActivity\",{\"data\":{\"action\":\"join-room\",\"chatRoomId\":\"c3d4\"}}]")))
+	assert.Nil(s.T(), handler(s.helperMessage("42[\"chatActivity\",{\"data\":{\"action\":\"petMessage\",\"chatRoomId\":\"e5f6\"}}]")))
+	assert.Equal(s.T(), []string{"c3d4", "e5f6"}, changed)
+
+	// Typing indicators, other events and other codes don't change the room
+	assert.ErrorIs(s.T(), handler(s.helperMessage("42[\"chatActivity\",{\"data\":{\"action\":\"activity\",\"chatRoomId\":\"c3d4\",\"activity\":{\"type\":\"TYPING\"}}}]")), ErrUnableToHandleMessage)
+	assert.ErrorIs(s.T(), handler(s.helperMessage("42[\"typing\",{\"chatRoomId\":\"c3d4\"}]")), ErrUnableToHandleMessage)
+	assert.ErrorIs(s.T(), handler(s.helperMessage("2")), ErrUnableToHandleMessage)
+	assert.Len(s.T(), changed, 2)
+
+	// Malformed events are rejected without panicking
+	assert.NotNil(s.T(), handler(s.helperMessage("42[5,{\"data\":{\"action\":\"join-room\",\"chatRoomId\":\"c3d4\"}}]")))
+	assert.NotNil(s.T(), handler(s.helperMessage("42")))
+	assert.NotNil(s.T(), handler(s.helperMessage("42{\"action\":\"join-room\"}")))
+	assert.Len(s.T(), changed, 2)
+}
+
+func (s *WebSocketHandlersTestSuite) TestRoomChangeHandlerActions() {
+	changed := make([]string, 0)
+	handler := NewKajiwotoWebSocketRoomChangeHandler(func(chatRoomID string) {
+		changed = append(changed, chatRoomID)
+	}, ChatActivityMessage)
+
+	assert.ErrorIs(s.T(), handler(s.helperMessage("42[\"chatActivity\",{\"data\":{\"action\":\"join-room\",\"chatRoomId\":\"c3d4\"}}]")), ErrUnableToHandleMessage)
+	assert.Nil(s.T(), handler(s.helperMessage("42[\"chatActivity\",{\"data\":{\"action\":\"message\",\"chatRoomId\":\"c3d4\",\"message\":{\"message\":\"hi\"}}}]")))
+	assert.Equal(s.T(), []string{"c3d4"}, changed)
+}
+
+func (s *WebSocketHandlersTestSuite) TestAuthResponseHandler() {
+	responses := make(chan *KaiwotoWebSocketAuthResponse, 1)
+	handler := newNamespaceAuthResponseHandler("/chat", responses)
+
+	assert.ErrorIs(s.T(), handler(s.helperMessage("40{\"sid\":\"a1\"}")), ErrUnableToHandleMessage)
+	assert.Nil(s.T(), handler(s.helperMessage("40/chat,{\"sid\":\"a1\"}")))
+	assert.Equal(s.T(), "a1", (<-responses).Sid)
+
+	// Responses without content don't panic
+	assert.Nil(s.T(), handler(s.helperMessage("44/chat,")))
+	assert.Empty(s.T(), (<-responses).Sid)
+}
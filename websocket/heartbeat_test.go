@@ -0,0 +1,125 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+type WebSocketHeartbeatTestSuite struct {
+	suite.Suite
+	server *testServer
+}
+
+func TestWebSocketHeartbeatTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketHeartbeatTestSuite))
+}
+
+func (s *WebSocketHeartbeatTestSuite) SetupTest() {
+	s.server = newTestServer()
+}
+
+func (s *WebSocketHeartbeatTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *WebSocketHeartbeatTestSuite) TestOpenPacket() {
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), client.OpenPacket())
+	assert.Nil(s.T(), client.Connect())
+
+	openPacket := client.OpenPacket()
+	if assert.NotNil(s.T(), openPacket) {
+		assert.Equal(s.T(), "engine-sid", openPacket.Sid)
+		assert.Equal(s.T(), 25000, openPacket.PingInterval)
+		assert.Equal(s.T(), 20000, openPacket.PingTimeout)
+		assert.Equal(s.T(), 1000000, openPacket.MaxPayload)
+		assert.Equal(s.T(), 45*time.Second, openPacket.HeartbeatTimeout())
+	}
+
+	assert.Nil(s.T(), client.Close(context.Background()))
+	assert.Nil(s.T(), client.OpenPacket())
+}
+
+func (s *WebSocketHeartbeatTestSuite) TestInvalidOpenPacket() {
+	s.server.openPacket = `40{"sid":"engine-sid"}`
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.NotNil(s.T(), client.Connect())
+	assert.Nil(s.T(), client.conn())
+}
+
+func (s *WebSocketHeartbeatTestSuite) TestMaxPayload() {
+	s.server.openPacket = `0{"sid":"engine-sid","upgrades":[],"pingInterval":25000,"pingTimeout":20000,"maxPayload":100}`
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), client.Connect())
+	serverConn := s.server.nextConnection()
+
+	chatLeave := &KajiwotoRPCChatLeaveMessage{ChatRoom: KajiwotoRPCChatRoomId{ChatRoomId: "c3d4"}}
+	assert.Nil(s.T(), client.SendMessage(CreateKajiwotoWebSocketEventMessage(chatLeave)))
+	chatLeave.ChatRoom.ChatRoomId = strings.Repeat("c3d4", 25)
+	assert.ErrorIs(s.T(), client.SendMessage(CreateKajiwotoWebSocketEventMessage(chatLeave)), ErrMessageTooLarge)
+
+	// Only the first message was sent
+	_, sent := serverConn.expect(`42["chatLeave"`)
+	assert.True(s.T(), sent)
+	assert.Nil(s.T(), client.Close(context.Background()))
+	message, _ := serverConn.expect("4")
+	assert.Equal(s.T(), SocketCodeMessageDisconnect, message)
+}
+
+func (s *WebSocketHeartbeatTestSuite) TestPingKeepsConnectionAlive() {
+	s.server.openPacket = `0{"sid":"engine-sid","upgrades":[],"pingInterval":50,"pingTimeout":50,"maxPayload":1000000}`
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), client.Connect())
+	serverConn := s.server.nextConnection()
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(50 * time.Millisecond)
+		assert.Nil(s.T(), serverConn.send(SocketCodePing))
+		_, pong := serverConn.expect(SocketCodePong)
+		assert.True(s.T(), pong)
+	}
+	assert.True(s.T(), client.IsConnected())
+	assert.Nil(s.T(), client.Close(context.Background()))
+}
+
+func (s *WebSocketHeartbeatTestSuite) TestPingTimeout() {
+	s.server.openPacket = `0{"sid":"engine-sid","upgrades":[],"pingInterval":50,"pingTimeout":50,"maxPayload":1000000}`
+	client := GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	outages := make(chan Outage, 1)
+	client.EnableReconnect(ReconnectOptions{MaxAttempts: 1, BaseDelay: time.Millisecond}, func(outage Outage) {
+		outages <- outage
+	})
+	assert.Nil(s.T(), client.Connect())
+	s.server.reject.Store(true)
+
+	// The server sends no pings, so the connection is given up
+	select {
+	case outage := <-outages:
+		assert.ErrorIs(s.T(), outage.Cause, ErrPingTimeout)
+		assert.Less(s.T(), time.Since(outage.Start), time.Second)
+	case <-time.After(testServerTimeout):
+		assert.Fail(s.T(), "ping timeout was not detected")
+	}
+	assert.False(s.T(), client.IsConnected())
+	assert.Nil(s.T(), client.Close(context.Background()))
+}
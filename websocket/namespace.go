@@ -0,0 +1,104 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+)
+
+// ConnectNamespace joins a further Socket.IO namespace over the current connection, authenticating with the API key.
+// Messages of the namespace are passed to the message handlers with their Namespace set; set Namespace on a
+// message to send it to the namespace. Connected namespaces are joined again after a reconnect.
+func (c *KajiwotoWebSocketClient) ConnectNamespace(ctx context.Context, namespace string) error {
+	if isDefaultNamespace(namespace) {
+		return errors.New("the default namespace is connected by Connect")
+	}
+	if !c.IsConnected() {
+		return ErrNotConnected
+	}
+	if c.IsNamespaceConnected(namespace) {
+		return nil
+	}
+	sid, errAuth := c.authenticate(ctx, namespace)
+	if errAuth != nil {
+		return fmt.Errorf("unable to connect to namespace %v: %w", namespace, errAuth)
+	}
+	c.connMtx.Lock()
+	if c.namespaces == nil {
+		c.namespaces = make(map[string]string)
+	}
+	c.namespaces[namespace] = sid
+	c.connMtx.Unlock()
+	c.session.addNamespace(namespace)
+	log.Debugf("Assigned Socket ID %v for namespace %v", sid, namespace)
+	return nil
+}
+
+// DisconnectNamespace leaves a namespace joined with ConnectNamespace; the connection stays open
+func (c *KajiwotoWebSocketClient) DisconnectNamespace(ctx context.Context, namespace string) error {
+	if isDefaultNamespace(namespace) {
+		return errors.New("the default namespace is disconnected by Close")
+	}
+	c.session.removeNamespace(namespace)
+	if !c.IsNamespaceConnected(namespace) {
+		return nil
+	}
+	disconnectMessage := &KajiwotoWebSocketMessage{
+		MessageCode: SocketCodeMessageDisconnect,
+		Namespace:   namespace,
+	}
+	errDisconnect := c.SendMessageWithContext(ctx, disconnectMessage)
+	c.connMtx.Lock()
+	delete(c.namespaces, namespace)
+	c.connMtx.Unlock()
+	return errDisconnect
+}
+
+// IsNamespaceConnected checks whether the client joined the namespace; the default namespace is joined while connected
+func (c *KajiwotoWebSocketClient) IsNamespaceConnected(namespace string) bool {
+	if isDefaultNamespace(namespace) {
+		return c.IsConnected()
+	}
+	c.connMtx.RLock()
+	defer c.connMtx.RUnlock()
+	_, connected := c.namespaces[namespace]
+	return connected
+}
+
+// connectedNamespaces returns the namespaces joined besides the default one, sorted by name
+func (c *KajiwotoWebSocketClient) connectedNamespaces() []string {
+	c.connMtx.RLock()
+	defer c.connMtx.RUnlock()
+	namespaces := make([]string, 0, len(c.namespaces))
+	for namespace := range c.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// removeNamespace forgets a namespace the server disconnected, so it isn't joined again after a reconnect
+func (c *KajiwotoWebSocketClient) removeNamespace(namespace string) {
+	c.connMtx.Lock()
+	delete(c.namespaces, namespace)
+	c.connMtx.Unlock()
+	c.session.removeNamespace(namespace)
+}
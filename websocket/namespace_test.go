@@ -0,0 +1,154 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"testing"
+	"time"
+)
+
+type WebSocketNamespaceTestSuite struct {
+	suite.Suite
+	server     *testServer
+	client     *KajiwotoWebSocketClient
+	serverConn *testServerConn
+}
+
+func TestWebSocketNamespaceTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketNamespaceTestSuite))
+}
+
+func (s *WebSocketNamespaceTestSuite) SetupTest() {
+	s.server = newTestServer()
+	s.client = GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	assert.Nil(s.T(), s.client.Connect())
+	s.serverConn = s.server.nextConnection()
+}
+
+func (s *WebSocketNamespaceTestSuite) TearDownTest() {
+	assert.Nil(s.T(), s.client.Close(context.Background()))
+	s.server.Close()
+}
+
+func (s *WebSocketNamespaceTestSuite) TestConnectNamespace() {
+	chatMessage := &KajiwotoWebSocketMessage{
+		MessageCode:    SocketCodeMessageEvent,
+		Namespace:      "/chat",
+		MessageContent: []interface{}{"typing"},
+	}
+	assert.ErrorIs(s.T(), s.client.SendMessage(chatMessage), ErrNamespaceNotConnected)
+
+	assert.Nil(s.T(), s.client.ConnectNamespace(context.Background(), "/chat"))
+	assert.True(s.T(), s.client.IsNamespaceConnected("/chat"))
+	assert.True(s.T(), s.client.IsNamespaceConnected(DefaultNamespace))
+	assert.Equal(s.T(), "socket-sid-1/chat", s.client.namespaces["/chat"])
+	// The namespace connect doesn't change the socket ID of the default namespace
+	assert.Equal(s.T(), "socket-sid-1", s.client.socketID)
+	// Connecting again does nothing
+	assert.Nil(s.T(), s.client.ConnectNamespace(context.Background(), "/chat"))
+
+	assert.Nil(s.T(), s.client.SendMessage(chatMessage))
+	message, sent := s.serverConn.expect("42")
+	assert.True(s.T(), sent)
+	assert.Equal(s.T(), `42/chat,["typing"]`, message)
+
+	assert.NotNil(s.T(), s.client.ConnectNamespace(context.Background(), "/forbidden"))
+	assert.False(s.T(), s.client.IsNamespaceConnected("/forbidden"))
+
+	assert.Nil(s.T(), s.client.DisconnectNamespace(context.Background(), "/chat"))
+	message, sent = s.serverConn.expect("41")
+	assert.True(s.T(), sent)
+	assert.Equal(s.T(), "41/chat,", message)
+	assert.False(s.T(), s.client.IsNamespaceConnected("/chat"))
+	assert.True(s.T(), s.client.IsConnected())
+}
+
+func (s *WebSocketNamespaceTestSuite) TestMultiplexing() {
+	assert.Nil(s.T(), s.client.ConnectNamespace(context.Background(), "/chat"))
+	assert.Nil(s.T(), s.client.ConnectNamespace(context.Background(), "/status"))
+
+	received := make(chan *KajiwotoWebSocketMessage, 4)
+	s.client.AddMessageHandler(func(message *KajiwotoWebSocketMessage) error {
+		if message.MessageCode != SocketCodeMessageEvent {
+			return ErrUnableToHandleMessage
+		}
+		received <- message
+		// Answer in the namespace of the request
+		return s.client.SendAck(message)
+	}, false)
+
+	assert.Nil(s.T(), s.serverConn.send(`42/status,1["userStatus"]`))
+	select {
+	case message := <-received:
+		assert.Equal(s.T(), "/status", message.Namespace)
+	case <-time.After(testServerTimeout):
+		assert.Fail(s.T(), "message of namespace was not received")
+	}
+	ack, sent := s.serverConn.expect(SocketCodeMessageAck)
+	assert.True(s.T(), sent)
+	assert.Equal(s.T(), `43/status,1[]`, ack)
+
+	// Acks of requests sent to a namespace are matched as well
+	results := make(chan error, 1)
+	go func() {
+		_, errAck := s.client.SendMessageWithAck(context.Background(), &KajiwotoWebSocketMessage{
+			MessageCode:    SocketCodeMessageEvent,
+			Namespace:      "/chat",
+			MessageContent: []interface{}{"typing"},
+		})
+		results <- errAck
+	}()
+	_, sent = s.serverConn.expect(`42/chat,0[`)
+	assert.True(s.T(), sent)
+	assert.Nil(s.T(), s.serverConn.send(`43/chat,0[]`))
+	assert.Nil(s.T(), <-results)
+
+	// Server disconnects a namespace
+	assert.Nil(s.T(), s.serverConn.send(`41/status,`))
+	assert.Eventually(s.T(), func() bool {
+		return !s.client.IsNamespaceConnected("/status")
+	}, testServerTimeout, 10*time.Millisecond)
+	assert.True(s.T(), s.client.IsNamespaceConnected("/chat"))
+
+	// Close leaves all namespaces
+	assert.Nil(s.T(), s.client.Close(context.Background()))
+	message, _ := s.serverConn.expect("41")
+	assert.Equal(s.T(), "41/chat,", message)
+	message, _ = s.serverConn.expect("41")
+	assert.Equal(s.T(), SocketCodeMessageDisconnect, message)
+}
+
+func (s *WebSocketNamespaceTestSuite) TestReconnectRestoresNamespaces() {
+	s.client.EnableReconnect(ReconnectOptions{BaseDelay: time.Millisecond}, nil)
+	assert.Nil(s.T(), s.client.ConnectNamespace(context.Background(), "/chat"))
+	assert.Nil(s.T(), s.serverConn.drop())
+
+	serverConn := s.server.nextConnection()
+	if !assert.NotNil(s.T(), serverConn) {
+		return
+	}
+	message, _ := serverConn.expect(SocketCodeMessageConnect)
+	assert.Equal(s.T(), `40{"api_key":"key"}`, message)
+	message, _ = serverConn.expect(SocketCodeMessageConnect)
+	assert.Equal(s.T(), `40/chat,{"api_key":"key"}`, message)
+	assert.Eventually(s.T(), func() bool {
+		return s.client.IsNamespaceConnected("/chat")
+	}, testServerTimeout, 10*time.Millisecond)
+}
@@ -0,0 +1,424 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultReconnectMaxAttempts is the number of reconnect attempts per outage if not configured otherwise
+	DefaultReconnectMaxAttempts = 10
+	// DefaultReconnectBaseDelay is the backoff before the first attempt if not configured otherwise; it doubles with every further attempt
+	DefaultReconnectBaseDelay = time.Second
+	// DefaultReconnectMaxDelay caps the backoff between attempts if not configured otherwise
+	DefaultReconnectMaxDelay = 30 * time.Second
+	// DefaultReconnectJitter is the randomized share of the backoff if not configured otherwise
+	DefaultReconnectJitter = 0.5
+)
+
+var (
+	// ErrReconnectFailed means the connection could not be restored within the configured number of attempts
+	ErrReconnectFailed = errors.New("unable to reconnect")
+)
+
+// ReconnectOptions configures the automatic reconnect, see EnableReconnect
+type ReconnectOptions struct {
+	// MaxAttempts is the number of attempts per outage; DefaultReconnectMaxAttempts if 0, unlimited if < 0
+	MaxAttempts int
+	// BaseDelay is the backoff before the first attempt; DefaultReconnectBaseDelay if 0
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff; DefaultReconnectMaxDelay if 0
+	MaxDelay time.Duration
+	// Jitter is the share of the backoff which is randomized, between 0 and 1; DefaultReconnectJitter if 0, none if < 0
+	Jitter float64
+}
+
+// Outage describes a lost connection. It is reported once the connection was restored or reconnecting was given up.
+type Outage struct {
+	Start    time.Time // Time the connection loss was noticed
+	End      time.Time // Time the connection was restored or reconnecting was given up
+	Attempts int       // Number of reconnect attempts
+	Cause    error     // Error which ended the connection
+	Err      error     // Error of the last attempt, wrapping ErrReconnectFailed; nil if the connection was restored
+}
+
+// Restored checks whether the connection and session were restored
+func (o Outage) Restored() bool {
+	return o.Err == nil
+}
+
+// Duration returns the time the client was disconnected
+func (o Outage) Duration() time.Duration {
+	return o.End.Sub(o.Start)
+}
+
+type reconnectState struct {
+	enabled    bool
+	suppressed bool // Set while the client is closed deliberately
+	options    ReconnectOptions
+	onOutage   func(outage Outage)
+	cancel     context.CancelFunc // Stops the running reconnect; nil if none is running
+	done       chan struct{}
+	lostAgain  error // Set if the restored connection was lost while the reconnect was still finishing
+	stateMtx   sync.Mutex
+}
+
+// EnableReconnect makes the client reconnect when the connection is lost. After reconnecting, the client authenticates
+// again and repeats the last login, all subscriptions and all chat rooms entered, with fresh secrets.
+// onOutage is called in its own goroutine once the connection was restored or reconnecting was given up; it may be nil.
+// Message handlers are kept while reconnecting, but messages sent during an outage fail with ErrNotConnected.
+func (c *KajiwotoWebSocketClient) EnableReconnect(options ReconnectOptions, onOutage func(outage Outage)) {
+	if options.MaxAttempts == 0 {
+		options.MaxAttempts = DefaultReconnectMaxAttempts
+	}
+	if options.BaseDelay == 0 {
+		options.BaseDelay = DefaultReconnectBaseDelay
+	}
+	if options.MaxDelay == 0 {
+		options.MaxDelay = DefaultReconnectMaxDelay
+	}
+	if options.Jitter == 0 {
+		options.Jitter = DefaultReconnectJitter
+	}
+	c.reconnect.stateMtx.Lock()
+	c.reconnect.enabled = true
+	c.reconnect.options = options
+	c.reconnect.onOutage = onOutage
+	c.reconnect.stateMtx.Unlock()
+}
+
+// DisableReconnect turns the automatic reconnect off and stops a running reconnect
+func (c *KajiwotoWebSocketClient) DisableReconnect() {
+	c.reconnect.stateMtx.Lock()
+	c.reconnect.enabled = false
+	c.reconnect.stateMtx.Unlock()
+	c.stopReconnect()
+}
+
+// reconnectAfter starts reconnecting in the background if enabled; called by the listener when the connection was lost
+func (c *KajiwotoWebSocketClient) reconnectAfter(cause error) {
+	r := &c.reconnect
+	r.stateMtx.Lock()
+	defer r.stateMtx.Unlock()
+	if !r.enabled || r.suppressed {
+		return
+	}
+	if r.cancel != nil {
+		r.lostAgain = cause
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	r.cancel, r.done = cancel, done
+	options := r.options
+	go func() {
+		defer close(done)
+		for {
+			outage := c.runReconnect(ctx, cause, options)
+
+			r.stateMtx.Lock()
+			lostAgain := r.lostAgain
+			r.lostAgain = nil
+			finished := lostAgain == nil || ctx.Err() != nil
+			if finished {
+				r.cancel, r.done = nil, nil
+			}
+			onOutage := r.onOutage
+			r.stateMtx.Unlock()
+
+			// Outages ended by Close or DisableReconnect are not reported
+			if onOutage != nil && ctx.Err() == nil {
+				go onOutage(outage)
+			}
+			if finished {
+				cancel()
+				return
+			}
+			cause = lostAgain
+		}
+	}()
+}
+
+// stopReconnect cancels a running reconnect and waits for it to exit
+func (c *KajiwotoWebSocketClient) stopReconnect() {
+	c.reconnect.stateMtx.Lock()
+	cancel, done := c.reconnect.cancel, c.reconnect.done
+	c.reconnect.stateMtx.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// suppressReconnect prevents reconnects while the client is being closed
+func (c *KajiwotoWebSocketClient) suppressReconnect(suppressed bool) {
+	c.reconnect.stateMtx.Lock()
+	c.reconnect.suppressed = suppressed
+	c.reconnect.stateMtx.Unlock()
+}
+
+// runReconnect tries to restore the connection and session until it succeeds, the attempts are used up or ctx is done
+func (c *KajiwotoWebSocketClient) runReconnect(ctx context.Context, cause error, options ReconnectOptions) Outage {
+	outage := Outage{
+		Start: time.Now(),
+		Cause: cause,
+	}
+	log.Warnf("Lost connection to websocket backend, reconnecting. Error: %v", cause)
+	// Reset the state of the lost connection; the session is kept to be restored
+	if errClose := c.closeConnection(ctx); errClose != nil {
+		log.Debugf("Unable to close lost connection. Error: %v", errClose)
+	}
+
+	backoff := options.BaseDelay
+	for attempt := 1; options.MaxAttempts < 0 || attempt <= options.MaxAttempts; attempt++ {
+		delay := reconnectDelay(backoff, options.Jitter)
+		log.Debugf("Reconnecting in %v. Attempt %v of %v", delay, attempt, options.MaxAttempts)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			outage.End = time.Now()
+			outage.Err = ctx.Err()
+			return outage
+		case <-timer.C:
+		}
+
+		outage.Attempts = attempt
+		c.reconnect.stateMtx.Lock()
+		c.reconnect.lostAgain = nil
+		c.reconnect.stateMtx.Unlock()
+		errAttempt := c.connect(ctx)
+		if errAttempt == nil {
+			if errAttempt = c.restoreSession(ctx); errAttempt == nil {
+				outage.End = time.Now()
+				outage.Err = nil
+				log.Infof("Reconnected to websocket backend after %v", outage.Duration())
+				return outage
+			}
+			if errClose := c.closeConnection(ctx); errClose != nil {
+				log.Debugf("Unable to close connection after failed session restore. Error: %v", errClose)
+			}
+		}
+		outage.Err = errAttempt
+		log.Debugf("Reconnect attempt %v failed. Error: %v", attempt, errAttempt)
+
+		if backoff *= 2; backoff > options.MaxDelay {
+			backoff = options.MaxDelay
+		}
+	}
+	outage.End = time.Now()
+	outage.Err = fmt.Errorf("%w after %v attempts: %v", ErrReconnectFailed, outage.Attempts, outage.Err)
+	log.Errorf("Giving up reconnecting to websocket backend. Error: %v", outage.Err)
+	return outage
+}
+
+// reconnectDelay randomizes the given share of the backoff
+func reconnectDelay(backoff time.Duration, jitter float64) time.Duration {
+	if jitter <= 0 {
+		return backoff
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return backoff - time.Duration(rand.Float64()*jitter*float64(backoff))
+}
+
+// restoreSession joins the recorded namespaces again and repeats the recorded session messages with fresh secrets
+func (c *KajiwotoWebSocketClient) restoreSession(ctx context.Context) error {
+	for _, namespace := range c.session.connectedNamespaces() {
+		if errConnect := c.ConnectNamespace(ctx, namespace); errConnect != nil {
+			return fmt.Errorf("unable to restore session: %w", errConnect)
+		}
+	}
+	for _, rpcMessage := range c.session.messages() {
+		switch typed := rpcMessage.(type) {
+		case *KajiwotoRPCLoginMessage:
+			typed.UserData.Time = c.BuildLocalUserTime()
+			typed.Secret = CreateMessageSecret()
+		case *KajiwotoRPCSubscribeMessage:
+			typed.UserData.Time = c.BuildLocalUserTime()
+			typed.Secret = CreateMessageSecret()
+		case *KajiwotoRPCChatEnterMessage:
+			typed.UserData.Time = c.BuildLocalUserTime()
+			typed.Secret = CreateMessageSecret()
+		}
+		if errSend := c.SendMessageWithContext(ctx, CreateKajiwotoWebSocketEventMessage(rpcMessage)); errSend != nil {
+			return fmt.Errorf("unable to restore session: %w", errSend)
+		}
+	}
+	return nil
+}
+
+// sessionState records the namespaces and messages which define the session of a connection, to repeat them after reconnecting.
+// Only messages of the default namespace are recorded.
+type sessionState struct {
+	namespaces    []string
+	login         *KajiwotoRPCLoginMessage
+	subscriptions []*KajiwotoRPCSubscribeMessage
+	chatRooms     []*KajiwotoRPCChatEnterMessage
+	sessionMtx    sync.Mutex
+}
+
+// record remembers login, subscribe and chatEnter messages, and forgets chat rooms left with chatLeave
+func (s *sessionState) record(message *KajiwotoWebSocketMessage) {
+	if message.MessageCode != SocketCodeMessageEvent || !message.IsDefaultNamespace() || !isSessionAction(message.MessageContent) {
+		return
+	}
+	// Decode a copy, so the typed payload is independent of the sent message
+	var content interface{} = message.MessageContent
+	if _, isBytes := content.([]byte); !isBytes {
+		contentBytes, errMarshal := json.Marshal(content)
+		if errMarshal != nil {
+			return
+		}
+		content = contentBytes
+	}
+	rpcMessage := &KaiwotoRPCBaseMessage{}
+	if errDeserialize := rpcMessage.Deserialize(content); errDeserialize != nil {
+		return
+	}
+
+	s.sessionMtx.Lock()
+	defer s.sessionMtx.Unlock()
+	switch rpcMessage.Action {
+	case RPCMessageLogin:
+		login := &KajiwotoRPCLoginMessage{}
+		login.FromRPCBaseMessage(rpcMessage)
+		s.login = login
+	case RPCMessageSubscribe:
+		subscription := &KajiwotoRPCSubscribeMessage{}
+		subscription.FromRPCBaseMessage(rpcMessage)
+		for i, existing := range s.subscriptions {
+			if reflect.DeepEqual(existing.SubscribeArgs, subscription.SubscribeArgs) {
+				s.subscriptions[i] = subscription
+				return
+			}
+		}
+		s.subscriptions = append(s.subscriptions, subscription)
+	case RPCMessageChatEnter:
+		chatEnter := &KajiwotoRPCChatEnterMessage{}
+		chatEnter.FromRPCBaseMessage(rpcMessage)
+		s.removeChatRoom(chatEnter.ChatroomData.ChatRoomId)
+		s.chatRooms = append(s.chatRooms, chatEnter)
+	case RPCMessageChatLeave:
+		chatLeave := &KajiwotoRPCChatLeaveMessage{}
+		chatLeave.FromRPCBaseMessage(rpcMessage)
+		s.removeChatRoom(chatLeave.ChatRoom.ChatRoomId)
+	}
+}
+
+// removeChatRoom forgets a chat room; sessionMtx must be held
+func (s *sessionState) removeChatRoom(chatRoomID string) {
+	chatRooms := s.chatRooms[:0]
+	for _, chatEnter := range s.chatRooms {
+		if chatEnter.ChatroomData.ChatRoomId != chatRoomID {
+			chatRooms = append(chatRooms, chatEnter)
+		}
+	}
+	s.chatRooms = chatRooms
+}
+
+// messages returns copies of the recorded messages in the order they have to be repeated
+func (s *sessionState) messages() []KajiwotoRPCMessage {
+	s.sessionMtx.Lock()
+	defer s.sessionMtx.Unlock()
+	messages := make([]KajiwotoRPCMessage, 0, 1+len(s.subscriptions)+len(s.chatRooms))
+	if s.login != nil {
+		login := *s.login
+		messages = append(messages, &login)
+	}
+	for _, subscription := range s.subscriptions {
+		subscriptionCopy := *subscription
+		messages = append(messages, &subscriptionCopy)
+	}
+	for _, chatEnter := range s.chatRooms {
+		chatEnterCopy := *chatEnter
+		messages = append(messages, &chatEnterCopy)
+	}
+	return messages
+}
+
+func (s *sessionState) addNamespace(namespace string) {
+	s.sessionMtx.Lock()
+	defer s.sessionMtx.Unlock()
+	for _, existing := range s.namespaces {
+		if existing == namespace {
+			return
+		}
+	}
+	s.namespaces = append(s.namespaces, namespace)
+}
+
+func (s *sessionState) removeNamespace(namespace string) {
+	s.sessionMtx.Lock()
+	defer s.sessionMtx.Unlock()
+	namespaces := s.namespaces[:0]
+	for _, existing := range s.namespaces {
+		if existing != namespace {
+			namespaces = append(namespaces, existing)
+		}
+	}
+	s.namespaces = namespaces
+}
+
+// connectedNamespaces returns the namespaces to join again after reconnecting
+func (s *sessionState) connectedNamespaces() []string {
+	s.sessionMtx.Lock()
+	defer s.sessionMtx.Unlock()
+	return append([]string{}, s.namespaces...)
+}
+
+func (s *sessionState) reset() {
+	s.sessionMtx.Lock()
+	s.namespaces = nil
+	s.login = nil
+	s.subscriptions = nil
+	s.chatRooms = nil
+	s.sessionMtx.Unlock()
+}
+
+// isSessionAction checks whether message content is an RPC message which is part of the session
+func isSessionAction(content interface{}) bool {
+	var action string
+	switch typed := content.(type) {
+	case []interface{}:
+		if len(typed) > 0 {
+			action, _ = typed[0].(string)
+		}
+	case []byte:
+		rpcMessage := &KaiwotoRPCBaseMessage{}
+		if rpcMessage.Deserialize(typed) == nil {
+			action = rpcMessage.Action
+		}
+	}
+	switch action {
+	case RPCMessageLogin, RPCMessageSubscribe, RPCMessageChatEnter, RPCMessageChatLeave:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,190 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+type WebSocketReconnectTestSuite struct {
+	suite.Suite
+	server  *testServer
+	client  *KajiwotoWebSocketClient
+	outages chan Outage
+}
+
+func TestWebSocketReconnectTestSuite(t *testing.T) {
+	suite.Run(t, new(WebSocketReconnectTestSuite))
+}
+
+func (s *WebSocketReconnectTestSuite) SetupTest() {
+	s.server = newTestServer()
+	s.outages = make(chan Outage, 4)
+	s.client = GetKajiwotoWebSocketClient(s.server.URL(), "key")
+	s.client.EnableReconnect(ReconnectOptions{
+		MaxAttempts: 3,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    20 * time.Millisecond,
+	}, func(outage Outage) {
+		s.outages <- outage
+	})
+}
+
+func (s *WebSocketReconnectTestSuite) TearDownTest() {
+	assert.Nil(s.T(), s.client.Close(context.Background()))
+	s.server.Close()
+}
+
+func (s *WebSocketReconnectTestSuite) helperUserData() KajiwotoRPCUserData {
+	return KajiwotoRPCUserData{
+		DisplayName: "RuntimeRacer",
+		UserID:      "a1b2",
+		Username:    "RuntimeRacer",
+	}
+}
+
+// helperStartSession connects and sends a login, two subscriptions and enters two chat rooms, leaving one of them again
+func (s *WebSocketReconnectTestSuite) helperStartSession() *testServerConn {
+	assert.Nil(s.T(), s.client.Connect())
+	serverConn := s.server.nextConnection()
+	userData := s.helperUserData()
+	messages := []KajiwotoRPCMessage{
+		&KajiwotoRPCLoginMessage{UserData: userData, UserStatus: KajiwotoRPCUserStatus{Status: "ONLINE"}, Secret: CreateMessageSecret()},
+		&KajiwotoRPCSubscribeMessage{UserData: userData, SubscribeArgs: KajiwotoRPCSubscribeArgs{ChatRoomIds: []string{"c3d4"}}, Secret: CreateMessageSecret()},
+		&KajiwotoRPCSubscribeMessage{UserData: userData, SubscribeArgs: KajiwotoRPCSubscribeArgs{ChatRoomIds: []string{"e5f6"}}, Secret: CreateMessageSecret()},
+		&KajiwotoRPCSubscribeMessage{UserData: userData, SubscribeArgs: KajiwotoRPCSubscribeArgs{ChatRoomIds: []string{"c3d4"}}, Secret: CreateMessageSecret()},
+		&KajiwotoRPCChatEnterMessage{UserData: userData, ChatroomData: KajiwotoRPCChatRoomData{ChatRoomId: "c3d4"}, Secret: CreateMessageSecret()},
+		&KajiwotoRPCChatEnterMessage{UserData: userData, ChatroomData: KajiwotoRPCChatRoomData{ChatRoomId: "e5f6"}, Secret: CreateMessageSecret()},
+		&KajiwotoRPCChatLeaveMessage{ChatRoom: KajiwotoRPCChatRoomId{ChatRoomId: "e5f6"}, Secret: CreateMessageSecret()},
+	}
+	for _, message := range messages {
+		assert.Nil(s.T(), s.client.SendMessage(CreateKajiwotoWebSocketEventMessage(message)))
+	}
+	_, sent := serverConn.expect(`42["chatLeave"`)
+	assert.True(s.T(), sent)
+	return serverConn
+}
+
+func (s *WebSocketReconnectTestSuite) helperWaitOutage() (Outage, bool) {
+	select {
+	case outage := <-s.outages:
+		return outage, true
+	case <-time.After(testServerTimeout):
+		return Outage{}, false
+	}
+}
+
+func (s *WebSocketReconnectTestSuite) TestReconnectRestoresSession() {
+	serverConn := s.helperStartSession()
+	assert.Nil(s.T(), serverConn.drop())
+
+	// The new connection authenticates and repeats the session in order
+	serverConn = s.server.nextConnection()
+	if !assert.NotNil(s.T(), serverConn) {
+		return
+	}
+	expected := []string{`40{"api_key":"key"}`, `42["login"`, `42["subscribe"`, `42["subscribe"`, `42["chatEnter"`}
+	for _, prefix := range expected {
+		message, received := serverConn.expect("4")
+		assert.True(s.T(), received)
+		assert.True(s.T(), strings.HasPrefix(message, prefix), "expected %v, got %v", prefix, message)
+		if prefix == `42["chatEnter"` {
+			// The chat room which was left is not entered again
+			assert.Contains(s.T(), message, "c3d4")
+			assert.NotContains(s.T(), message, "e5f6")
+		}
+	}
+
+	outage, reported := s.helperWaitOutage()
+	assert.True(s.T(), reported)
+	assert.True(s.T(), outage.Restored())
+	assert.Equal(s.T(), 1, outage.Attempts)
+	assert.NotNil(s.T(), outage.Cause)
+	assert.True(s.T(), s.client.IsConnected())
+	assert.Equal(s.T(), "socket-sid-2", s.client.socketID)
+
+	// The session is restored again after the next outage
+	assert.Nil(s.T(), serverConn.drop())
+	serverConn = s.server.nextConnection()
+	if !assert.NotNil(s.T(), serverConn) {
+		return
+	}
+	_, reEntered := serverConn.expect(`42["chatEnter"`)
+	assert.True(s.T(), reEntered)
+	outage, reported = s.helperWaitOutage()
+	assert.True(s.T(), reported)
+	assert.True(s.T(), outage.Restored())
+}
+
+func (s *WebSocketReconnectTestSuite) TestReconnectGivesUp() {
+	serverConn := s.helperStartSession()
+	s.server.reject.Store(true)
+	assert.Nil(s.T(), serverConn.drop())
+
+	outage, reported := s.helperWaitOutage()
+	assert.True(s.T(), reported)
+	assert.False(s.T(), outage.Restored())
+	assert.ErrorIs(s.T(), outage.Err, ErrReconnectFailed)
+	assert.Equal(s.T(), 3, outage.Attempts)
+	assert.False(s.T(), s.client.IsConnected())
+	assert.ErrorIs(s.T(), s.client.SendMessage(&KajiwotoWebSocketMessage{MessageCode: SocketCodePing}), ErrNotConnected)
+}
+
+func (s *WebSocketReconnectTestSuite) TestCloseStopsReconnect() {
+	s.client.EnableReconnect(ReconnectOptions{MaxAttempts: -1, BaseDelay: time.Hour}, func(outage Outage) {
+		s.outages <- outage
+	})
+	serverConn := s.helperStartSession()
+	assert.Nil(s.T(), serverConn.drop())
+	// Wait for the listener to notice the outage
+	assert.Eventually(s.T(), func() bool {
+		return !s.client.IsConnected()
+	}, testServerTimeout, 10*time.Millisecond)
+
+	assert.Nil(s.T(), s.client.Close(context.Background()))
+	assert.Empty(s.T(), s.client.session.messages())
+	select {
+	case <-s.outages:
+		assert.Fail(s.T(), "outage ended by Close was reported")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *WebSocketReconnectTestSuite) TestNoReconnectIfDisabled() {
+	s.client.DisableReconnect()
+	serverConn := s.helperStartSession()
+	assert.Nil(s.T(), serverConn.drop())
+	assert.Eventually(s.T(), func() bool {
+		return !s.client.listen.Load()
+	}, testServerTimeout, 10*time.Millisecond)
+	assert.Nil(s.T(), s.client.Close(context.Background()))
+	assert.Equal(s.T(), 1, s.server.connCount)
+}
+
+func (s *WebSocketReconnectTestSuite) TestReconnectDelay() {
+	for i := 0; i < 100; i++ {
+		delay := reconnectDelay(time.Second, 0.5)
+		assert.GreaterOrEqual(s.T(), delay, 500*time.Millisecond)
+		assert.LessOrEqual(s.T(), delay, time.Second)
+	}
+	assert.Equal(s.T(), time.Second, reconnectDelay(time.Second, -1))
+}
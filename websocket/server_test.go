@@ -0,0 +1,166 @@
+// Package websocket
+/*
+Copyright © 2023 runtimeracer@gmail.com
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package websocket
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"nhooyr.io/websocket"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const testServerTimeout = 5 * time.Second
+
+// testServer is a minimal Socket.IO backend for tests. It sends the Engine.IO open packet, accepts any API key
+// except "invalid", any namespace except "/forbidden" and records all received messages.
+type testServer struct {
+	server      *httptest.Server
+	openPacket  string
+	connections chan *testServerConn
+	connCount   int
+	reject      atomic.Bool // Answers new connections with 503 Service Unavailable if set
+	serverMtx   sync.Mutex
+}
+
+// testServerConn is a connection accepted by testServer
+type testServerConn struct {
+	conn     *websocket.Conn
+	received chan string
+	closed   chan websocket.StatusCode
+}
+
+func newTestServer() *testServer {
+	ts := &testServer{
+		openPacket:  `0{"sid":"engine-sid","upgrades":[],"pingInterval":25000,"pingTimeout":20000,"maxPayload":1000000}`,
+		connections: make(chan *testServerConn, 16),
+	}
+	ts.server = httptest.NewServer(http.HandlerFunc(ts.handle))
+	return ts
+}
+
+// URL returns the websocket endpoint of the server
+func (ts *testServer) URL() string {
+	return "ws" + strings.TrimPrefix(ts.server.URL, "http") + "/socket.io/?EIO=4&transport=websocket"
+}
+
+func (ts *testServer) Close() {
+	ts.server.Close()
+}
+
+func (ts *testServer) handle(w http.ResponseWriter, r *http.Request) {
+	if ts.reject.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	conn, errAccept := websocket.Accept(w, r, nil)
+	if errAccept != nil {
+		return
+	}
+	ts.serverMtx.Lock()
+	ts.connCount++
+	sid := "socket-sid-" + string(rune('0'+ts.connCount))
+	openPacket := ts.openPacket
+	ts.serverMtx.Unlock()
+
+	serverConn := &testServerConn{
+		conn:     conn,
+		received: make(chan string, 64),
+		closed:   make(chan websocket.StatusCode, 1),
+	}
+	ts.connections <- serverConn
+	ctx := context.Background()
+	if conn.Write(ctx, websocket.MessageText, []byte(openPacket)) != nil {
+		return
+	}
+	for {
+		_, data, errRead := conn.Read(ctx)
+		if errRead != nil {
+			serverConn.closed <- websocket.CloseStatus(errRead)
+			close(serverConn.received)
+			return
+		}
+		message := string(data)
+		if strings.HasPrefix(message, SocketCodeMessageConnect+"{") {
+			if strings.Contains(message, `"invalid"`) {
+				_ = conn.Write(ctx, websocket.MessageText, []byte(`44{"message":"Invalid API key"}`))
+			} else {
+				_ = conn.Write(ctx, websocket.MessageText, []byte(`40{"sid":"`+sid+`"}`))
+			}
+		} else if strings.HasPrefix(message, SocketCodeMessageConnect+"/") {
+			// Namespaces other than "/forbidden" are accepted
+			namespace := message[len(SocketCodeMessageConnect):strings.Index(message, ",")]
+			if namespace == "/forbidden" {
+				_ = conn.Write(ctx, websocket.MessageText, []byte(`44`+namespace+`,{"message":"Invalid namespace"}`))
+			} else {
+				_ = conn.Write(ctx, websocket.MessageText, []byte(`40`+namespace+`,{"sid":"`+sid+namespace+`"}`))
+			}
+		}
+		serverConn.received <- message
+	}
+}
+
+// nextConnection waits for the next accepted connection
+func (ts *testServer) nextConnection() *testServerConn {
+	select {
+	case serverConn := <-ts.connections:
+		return serverConn
+	case <-time.After(testServerTimeout):
+		return nil
+	}
+}
+
+// drop closes the connection like a restarting server would
+func (sc *testServerConn) drop() error {
+	return sc.conn.Close(websocket.StatusGoingAway, "restarting")
+}
+
+// send writes a raw message to the client
+func (sc *testServerConn) send(message string) error {
+	return sc.conn.Write(context.Background(), websocket.MessageText, []byte(message))
+}
+
+// expect waits for the next message received from the client matching the prefix, skipping all others
+func (sc *testServerConn) expect(prefix string) (string, bool) {
+	timeout := time.After(testServerTimeout)
+	for {
+		select {
+		case message, ok := <-sc.received:
+			if !ok {
+				return "", false
+			}
+			if strings.HasPrefix(message, prefix) {
+				return message, true
+			}
+		case <-timeout:
+			return "", false
+		}
+	}
+}
+
+// waitClosed waits until the client closed the connection and returns the close status
+func (sc *testServerConn) waitClosed() websocket.StatusCode {
+	select {
+	case status := <-sc.closed:
+		return status
+	case <-time.After(testServerTimeout):
+		return -1
+	}
+}
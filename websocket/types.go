@@ -22,6 +22,9 @@ import (
 	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -36,6 +39,9 @@ const (
 	SocketCodePing  = "2"
 	SocketCodePong  = "3"
 
+	// Namespaces
+	DefaultNamespace = "/"
+
 	// Complex Codes
 	SocketCodeMessageConnect    = "40"
 	SocketCodeMessageDisconnect = "41"
@@ -64,16 +70,47 @@ const (
 
 var (
 	ErrUnableToHandleMessage = errors.New("unable to handle message")
+	ErrNotConnected          = errors.New("client is not connected")
+	ErrPingTimeout           = errors.New("no ping received from server within ping interval and timeout")
+	ErrMessageTooLarge       = errors.New("message exceeds max payload of server")
+	ErrAckTimeout            = errors.New("no ack received in time")
+	ErrNoAckRequested        = errors.New("message does not request an ack")
+	ErrNamespaceNotConnected = errors.New("namespace is not connected")
 )
 
 // Basic WebSocket Message Handling types
 type KajiwotoWebSocketMessage struct {
 	MessageCode    string
+	Namespace      string // Socket.IO namespace, e.g. "/chat"; empty for the default namespace
+	AckID          *int   // ID of a Socket.IO acknowledgement; set on events the sender expects an ack for, and on their ack
 	MessageContent interface{}
 }
 
+// IsDefaultNamespace checks whether the message belongs to the default namespace
+func (k *KajiwotoWebSocketMessage) IsDefaultNamespace() bool {
+	return isDefaultNamespace(k.Namespace)
+}
+
+func isDefaultNamespace(namespace string) bool {
+	return namespace == "" || namespace == DefaultNamespace
+}
+
+func sameNamespace(a, b string) bool {
+	return a == b || (isDefaultNamespace(a) && isDefaultNamespace(b))
+}
+
 func (k *KajiwotoWebSocketMessage) ToBytes() ([]byte, error) {
 	messageBytes := []byte(k.MessageCode)
+	if !k.IsDefaultNamespace() {
+		if !strings.HasPrefix(k.Namespace, "/") || strings.Contains(k.Namespace, ",") {
+			return nil, fmt.Errorf("invalid namespace %q", k.Namespace)
+		}
+		messageBytes = append(messageBytes, k.Namespace...)
+		messageBytes = append(messageBytes, ',')
+	}
+	if k.AckID != nil {
+		messageBytes = strconv.AppendInt(messageBytes, int64(*k.AckID), 10)
+	}
 	if k.MessageContent != nil {
 		messageContentBytes, errMarshal := json.Marshal(k.MessageContent)
 		if errMarshal != nil {
@@ -85,27 +122,52 @@ func (k *KajiwotoWebSocketMessage) ToBytes() ([]byte, error) {
 }
 
 func (k *KajiwotoWebSocketMessage) FromBytes(bytes []byte) error {
-	contentMatcher, errCompile := regexp.Compile(`(\d*)({.*}|\[.*\])`)
-	if errCompile != nil {
-		return errCompile
-	}
-	matches := contentMatcher.FindAllSubmatch(bytes, -1)
-	if matches != nil {
-		if len(matches[0]) != 3 {
-			return fmt.Errorf("unable to parse message. message data: %v", string(bytes))
-		}
-		// Build from regex result
-		k.MessageCode = string(matches[0][1])
-		k.MessageContent = matches[0][2] // Unmarshal in response handler
-	} else {
+	matches := messagePattern.FindSubmatch(bytes)
+	if matches == nil || (len(matches[1]) == 1 && len(matches[2])+len(matches[3]) > 0) {
 		// Assume message has no content, just a code, to be evaluated in handlers
 		k.MessageCode = string(bytes)
+		return nil
 	}
 
+	// Build from regex result
+	k.MessageCode = string(matches[1])
+	k.Namespace = strings.TrimSuffix(string(matches[2]), ",")
+	if len(matches[3]) > 0 {
+		ackID, errAckID := strconv.Atoi(string(matches[3]))
+		if errAckID != nil {
+			return fmt.Errorf("unable to parse message ack id. message data: %v", string(bytes))
+		}
+		k.AckID = &ackID
+	}
+	if len(matches[4]) > 0 {
+		k.MessageContent = matches[4] // Unmarshal in response handler
+	}
 	return nil
 }
 
+// messagePattern splits a message into code, namespace, ack id and content.
+// Socket.IO packets are prefixed by "4" and their packet type; only these can carry a namespace and an ack id.
+var messagePattern = regexp.MustCompile(`(?s)^(4\d|\d)(/[^,]*(?:,|$))?(\d*)({.*}|\[.*\])?$`)
+
 // WebSocket Message Content types
+
+// KajiwotoWebSocketOpenPacket is the Engine.IO handshake sent by the server after connecting
+type KajiwotoWebSocketOpenPacket struct {
+	Sid          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int      `json:"pingInterval"` // Milliseconds between pings sent by the server
+	PingTimeout  int      `json:"pingTimeout"`  // Milliseconds the server waits for a pong; the client waits as long on top of PingInterval
+	MaxPayload   int      `json:"maxPayload"`   // Max size of a message in bytes
+}
+
+// HeartbeatTimeout returns the time after which a server which sent no ping is considered dead; 0 if unknown
+func (k *KajiwotoWebSocketOpenPacket) HeartbeatTimeout() time.Duration {
+	if k.PingInterval <= 0 {
+		return 0
+	}
+	return time.Duration(k.PingInterval+k.PingTimeout) * time.Millisecond
+}
+
 type KaiwotoWebSocketAuthRequest struct {
 	ApiKey string `json:"api_key"`
 }
@@ -149,7 +211,11 @@ func (k *KaiwotoRPCBaseMessage) Deserialize(messageData interface{}) error {
 	}
 	// Fill in fields
 	if len(rpcMessageParts) > 0 {
-		k.Action = rpcMessageParts[0].(string)
+		action, okCastAction := rpcMessageParts[0].(string)
+		if !okCastAction {
+			return fmt.Errorf("cannot deserialize rpc message, action is not a string")
+		}
+		k.Action = action
 	}
 	if len(rpcMessageParts) > 1 {
 		k.Payload = rpcMessageParts[1:]
@@ -559,3 +559,95 @@ func (s *WebSocketTypesTestSuite) TestSerializeLiveSubRPCWebSocketMessage() {
 	wsString := string(wsBytes)
 	assert.Equal(s.T(), messageString, wsString)
 }
+
+func (s *WebSocketTypesTestSuite) TestSerializeAckWebSocketMessages() {
+	// Event requesting an ack
+	wsMessage := &KajiwotoWebSocketMessage{}
+	assert.Nil(s.T(), wsMessage.FromBytes([]byte("4212[\"chatEnter\",{\"chatRoomId\":\"c3d4\"}]")))
+	assert.Equal(s.T(), SocketCodeMessageEvent, wsMessage.MessageCode)
+	if assert.NotNil(s.T(), wsMessage.AckID) {
+		assert.Equal(s.T(), 12, *wsMessage.AckID)
+	}
+	assert.Equal(s.T(), []byte("[\"chatEnter\",{\"chatRoomId\":\"c3d4\"}]"), wsMessage.MessageContent)
+
+	// Ack with and without arguments
+	wsMessage = &KajiwotoWebSocketMessage{}
+	assert.Nil(s.T(), wsMessage.FromBytes([]byte("437[{\"ok\":true}]")))
+	assert.Equal(s.T(), SocketCodeMessageAck, wsMessage.MessageCode)
+	assert.Equal(s.T(), 7, *wsMessage.AckID)
+	wsMessage = &KajiwotoWebSocketMessage{}
+	assert.Nil(s.T(), wsMessage.FromBytes([]byte("430")))
+	assert.Equal(s.T(), SocketCodeMessageAck, wsMessage.MessageCode)
+	assert.Equal(s.T(), 0, *wsMessage.AckID)
+	assert.Nil(s.T(), wsMessage.MessageContent)
+
+	// Packets without ack
+	for messageString, code := range map[string]string{"42[\"typing\"]": SocketCodeMessageEvent, "40": SocketCodeMessageConnect, "2": SocketCodePing, "0{\"sid\":\"a1\"}": SocketCodeOpen, "2probe": "2probe"} {
+		wsMessage = &KajiwotoWebSocketMessage{}
+		assert.Nil(s.T(), wsMessage.FromBytes([]byte(messageString)))
+		assert.Equal(s.T(), code, wsMessage.MessageCode)
+		assert.Nil(s.T(), wsMessage.AckID)
+	}
+
+	// Serialize
+	ackID := 12
+	wsMessage = &KajiwotoWebSocketMessage{
+		MessageCode:    SocketCodeMessageAck,
+		AckID:          &ackID,
+		MessageContent: []interface{}{"ok"},
+	}
+	wsBytes, errBytes := wsMessage.ToBytes()
+	assert.Nil(s.T(), errBytes)
+	assert.Equal(s.T(), "4312[\"ok\"]", string(wsBytes))
+}
+
+func (s *WebSocketTypesTestSuite) TestSerializeNamespaceWebSocketMessages() {
+	wsMessage := &KajiwotoWebSocketMessage{}
+	assert.Nil(s.T(), wsMessage.FromBytes([]byte("42/chat,7[\"typing\",{\"chatRoomId\":\"c3d4\"}]")))
+	assert.Equal(s.T(), SocketCodeMessageEvent, wsMessage.MessageCode)
+	assert.Equal(s.T(), "/chat", wsMessage.Namespace)
+	assert.False(s.T(), wsMessage.IsDefaultNamespace())
+	assert.Equal(s.T(), 7, *wsMessage.AckID)
+	assert.Equal(s.T(), []byte("[\"typing\",{\"chatRoomId\":\"c3d4\"}]"), wsMessage.MessageContent)
+
+	wsMessage = &KajiwotoWebSocketMessage{}
+	assert.Nil(s.T(), wsMessage.FromBytes([]byte("40/chat,{\"sid\":\"a1\"}")))
+	assert.Equal(s.T(), SocketCodeMessageConnect, wsMessage.MessageCode)
+	assert.Equal(s.T(), "/chat", wsMessage.Namespace)
+	assert.Nil(s.T(), wsMessage.AckID)
+	assert.Equal(s.T(), []byte("{\"sid\":\"a1\"}"), wsMessage.MessageContent)
+
+	// The trailing comma may be omitted if nothing follows
+	for _, messageString := range []string{"41/chat,", "41/chat"} {
+		wsMessage = &KajiwotoWebSocketMessage{}
+		assert.Nil(s.T(), wsMessage.FromBytes([]byte(messageString)))
+		assert.Equal(s.T(), SocketCodeMessageDisconnect, wsMessage.MessageCode)
+		assert.Equal(s.T(), "/chat", wsMessage.Namespace)
+		assert.Nil(s.T(), wsMessage.MessageContent)
+	}
+
+	// Default namespace
+	wsMessage = &KajiwotoWebSocketMessage{}
+	assert.Nil(s.T(), wsMessage.FromBytes([]byte("42[\"typing\"]")))
+	assert.Empty(s.T(), wsMessage.Namespace)
+	assert.True(s.T(), wsMessage.IsDefaultNamespace())
+
+	// Serialize
+	ackID := 3
+	wsMessage = &KajiwotoWebSocketMessage{
+		MessageCode:    SocketCodeMessageAck,
+		Namespace:      "/chat",
+		AckID:          &ackID,
+		MessageContent: []interface{}{"ok"},
+	}
+	wsBytes, errBytes := wsMessage.ToBytes()
+	assert.Nil(s.T(), errBytes)
+	assert.Equal(s.T(), "43/chat,3[\"ok\"]", string(wsBytes))
+	wsMessage = &KajiwotoWebSocketMessage{MessageCode: SocketCodeMessageDisconnect, Namespace: DefaultNamespace}
+	wsBytes, errBytes = wsMessage.ToBytes()
+	assert.Nil(s.T(), errBytes)
+	assert.Equal(s.T(), SocketCodeMessageDisconnect, string(wsBytes))
+	wsMessage = &KajiwotoWebSocketMessage{MessageCode: SocketCodeMessageDisconnect, Namespace: "chat"}
+	_, errBytes = wsMessage.ToBytes()
+	assert.NotNil(s.T(), errBytes)
+}